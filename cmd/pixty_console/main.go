@@ -54,8 +54,10 @@ func main() {
 	imgSrvc := image.NewImageService()
 	restApi := rapi.NewAPI()
 	msqlPersist := model.NewMysqlPersister()
-	lbs := storage.NewLfsBlobStorage(cc.LbsDir, cc.GetLbsMaxSizeBytes())
+	blobStorage := newBlobStorage(cc)
 	fpcp := fpcp_serv.NewFPCPServer()
+	camMonitor := fpcp_serv.NewCamMonitor()
+	camControl := fpcp_serv.NewCamControl()
 	scnProc := scene.NewSceneProcessor()
 	dtaCtrlr := service.NewDataController()
 	authService := auth.NewAuthService()
@@ -64,11 +66,15 @@ func main() {
 	faceSweeper := sweeper.NewFacesSweeper()
 	imageSweeper := sweeper.NewImagesSweeper()
 	persSweeper := sweeper.NewOrphPersonsGuardian()
+	keysRotator := sweeper.NewKeysRotator()
+	blobScrubber := sweeper.NewBlobScrubber()
+	picsReconciler := sweeper.NewPicsReconciler()
+	cropsMigrator := sweeper.NewCropsMigrator()
 	mchr := matcher.NewMatcher()
 	matcherCache := matcher.NewMatcherCache()
 
-	injector.RegisterMany(cc, restApi, fpcp, dtaCtrlr, authService, sessService, lbs, esender, imgSrvc)
-	injector.RegisterMany(faceSweeper, imageSweeper, persSweeper)
+	injector.RegisterMany(cc, restApi, fpcp, camMonitor, camControl, dtaCtrlr, authService, sessService, blobStorage, esender, imgSrvc)
+	injector.RegisterMany(faceSweeper, imageSweeper, persSweeper, keysRotator, blobScrubber, picsReconciler, cropsMigrator)
 	// restAPI provides the interface
 	injector.RegisterOne(restApi, "cam2orgCache")
 	injector.RegisterOne(msqlPersist, "persister")
@@ -89,6 +95,31 @@ func initConsoleConfig() *common.ConsoleConfig {
 	return cc
 }
 
+func newBlobStorage(cc *common.ConsoleConfig) storage.BlobStorage {
+	var bc *storage.BlobCipher
+	if cc.BlobKeysFile != "" {
+		var err error
+		bc, err = storage.NewBlobCipherFromFile(cc.BlobKeysFile)
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	switch cc.BlobStorage {
+	case common.BLOB_STORAGE_S3:
+		s3bs := storage.NewS3BlobStorage(&storage.S3Config{Endpoint: cc.S3Endpoint, Region: cc.S3Region,
+			Bucket: cc.S3Bucket, AccessKey: cc.S3AccessKey, SecretKey: cc.S3SecretKey, KeyPrefix: cc.S3KeyPrefix})
+		s3bs.SetCipher(bc)
+		return s3bs
+	case common.BLOB_STORAGE_LFS, "":
+		lbs := storage.NewLfsBlobStorage(cc.LbsDir, cc.GetLbsMaxSizeBytes())
+		lbs.SetCipher(bc)
+		return lbs
+	}
+	panic("Unknown blob storage type " + cc.BlobStorage + ", expected \"" + common.BLOB_STORAGE_LFS +
+		"\" or \"" + common.BLOB_STORAGE_S3 + "\"")
+}
+
 func initLoging(cc *common.ConsoleConfig) {
 	if cc.LogConfigFN != "" {
 		log4g.GetLogger("pixty").Info("Loading log4g configuartion from ", cc.LogConfigFN)
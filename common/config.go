@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"io/ioutil"
 	"math"
+	"strconv"
 
 	"github.com/jrivets/gorivets"
 	"github.com/jrivets/log4g"
@@ -19,8 +20,56 @@ const (
 	CMP_PHASE_DB            = 1
 	CMP_PHASE_SCENE_SERVICE = 2
 	CMP_PHASE_FPCP          = 3
+
+	// *** FPCP client certificates authentication modes ***
+	FPCP_CLIENT_AUTH_NONE = ""
+	// cameras could authenticate with the certificate instead of the secret
+	FPCP_CLIENT_AUTH_OPTIONAL = "optional"
+	// every camera must present the certificate
+	FPCP_CLIENT_AUTH_REQUIRE = "require"
+
+	// *** Blob storage types ***
+	BLOB_STORAGE_LFS = "lfs"
+	BLOB_STORAGE_S3  = "s3"
+
+	// *** Picture table and blob storage reconciliation modes ***
+	RECONCILE_OFF     = "off"
+	RECONCILE_DRY_RUN = "dryrun"
+	RECONCILE_REPAIR  = "repair"
+
+	// *** Scene faces retention policies ***
+	// faces of new persons, then faces spaced in time or better ones
+	FACE_RETENTION_DEFAULT = "default"
+	// one face per MinIntervalSec
+	FACE_RETENTION_TIME_SAMPLED = "timesampled"
+	// BestN best quality faces per MaxIntervalSec
+	FACE_RETENTION_BEST_N = "bestn"
+	// all faces, for debugging only
+	FACE_RETENTION_KEEP_ALL = "keepall"
 )
 
+// The scene faces retention policy defines which faces of the persons seen
+// by a camera are stored. Zero values of a camera policy are taken from the
+// global one.
+type FaceRetention struct {
+	// One of FACE_RETENTION_ values
+	Policy string
+	// a person not seen for the time is considered seen first time again
+	CacheTTLSec int
+	// default: how many faces of a new person are stored anyway
+	NewPersonFaces int
+	// default: after the number of faces are stored, a face is stored only
+	// if it is captured MinIntervalSec after the last stored one
+	MaxFaces int
+	// default: see MaxFaces. timesampled: the sampling interval
+	MinIntervalSec int
+	// default: a face is stored if the last one is stored earlier than the
+	// interval. bestn: the interval the best faces are chosen in
+	MaxIntervalSec int
+	// bestn: how many faces are stored per MaxIntervalSec
+	BestN int
+}
+
 type ConsoleConfig struct {
 	// Logging configuration file name
 	LogConfigFN string
@@ -33,6 +82,21 @@ type ConsoleConfig struct {
 	GrpcFPCPPort int
 	// how many sessions (connection) can be kept in the FPCP at a time
 	GrpcFPCPSessCapacity int
+	// a camera, which doesn't send scenes or authenticate for the time, is
+	// considered offline
+	CamOfflineToSec int
+	// the FPCP server certificate and key files in PEM, FPCP is plaintext
+	// if the certificate is not set
+	GrpcFPCPTlsCert string
+	GrpcFPCPTlsKey  string
+	// the CA certificates file in PEM to verify the cameras certificates
+	// with, and one of FPCP_CLIENT_AUTH_ modes. The camera certificate
+	// common name is its camId.
+	GrpcFPCPClientCA   string
+	GrpcFPCPClientAuth string
+	// how often the certificate files are checked for changes, the changed
+	// files are reloaded without the restart
+	GrpcFPCPTlsReloadSec int
 
 	// Debug mode
 	DebugMode bool
@@ -41,14 +105,49 @@ type ConsoleConfig struct {
 	// example: "id:password@tcp(your-amazonaws-uri.com:3306)/dbname" etc.
 	MysqlDatasource string
 
+	// Blob storage type, one of BLOB_STORAGE_LFS("lfs") or BLOB_STORAGE_S3("s3")
+	BlobStorage string
+	// Encryption keys file, if provided all new objects are encrypted. The
+	// format is {"activeKeyId": "k2", "keys": {"k1": "<base64 32 bytes>", "k2": "..."}}
+	// To rotate keys add a new one and make it active, objects encrypted by
+	// other keys are re-encrypted in background after start.
+	BlobKeysFile string
+
 	// Local File System Blob Storage
 	LbsDir     string
 	LbsMaxSize string
+	// Per organization quota, the size of all org images cannot exceed the value.
+	// Empty value means no quota. LbsOrgMaxSizes allows to redefine the quota
+	// for specific orgs like {"12": "5G"}
+	LbsOrgMaxSize  string
+	LbsOrgMaxSizes map[string]string
+
+	// S3-compatible Blob Storage. The endpoint is like "https://s3.amazonaws.com"
+	// or "http://127.0.0.1:9000" for a local MinIO
+	S3Endpoint  string
+	S3Region    string
+	S3Bucket    string
+	S3AccessKey string
+	S3SecretKey string
+	S3KeyPrefix string
 
 	// HTTP images endpoint prefix
 	ImgsPrefix string
 	// how long to keep temporary images
 	ImgsTmpTTLSec int
+	// HMAC key for signing image URLs. If it is set, the image URLs returned
+	// by the API are signed, so they could be used without authentication
+	// till they expire
+	ImgsSignKey string
+	// signed image URLs are valid at least the time, but not longer than twice
+	ImgsSignTTLSec int
+	// maximum dimensions of the image renditions requested by width/height
+	ImgsMaxWidth  int
+	ImgsMaxHeight int
+	// If it is true, only frames are stored and face images are cut from
+	// them when requested. ImgsCropBorder is added around the face rectangle
+	ImgsCropOnDemand bool
+	ImgsCropBorder   int
 
 	// AuthN, AuthZ
 	AuthMaxSessions  int
@@ -65,6 +164,31 @@ type ConsoleConfig struct {
 	SweepImagesPackSize        int // pack size (hom many records served at a time
 	SweepImagesPackSizePauseMs int // pause between packs in ms, could be 0
 	SweepOrphPersonsMins       int // orphanting age (last seen) of persons who don't have match group assigned
+	SweepScrubToSec            int // pause between blob storage integrity checks
+	SweepReconcileToSec        int // pause between the picture table and the blob storage reconciliations
+	SweepKeysToSec             int // pause between re-encryptions of the blobs stored by not active keys
+	// One of RECONCILE_OFF("off"), RECONCILE_DRY_RUN("dryrun") - only report
+	// the drift, or RECONCILE_REPAIR("repair") - fix it as well
+	ReconcileMode string
+
+	// Scene faces retention policy and its redefinitions for specific
+	// cameras like {"12": {"Policy": "keepall"}}
+	ScnFaceRetention     FaceRetention
+	ScnCamFaceRetentions map[string]*FaceRetention
+
+	// Scenes received from a camera are queued and processed by the workers
+	// pool. The frame processor is asked to slow down if the camera queue is full
+	ScnQueueSize int
+	ScnWorkers   int
+	// A scene frame received again from the camera within the window is
+	// considered as retry and it is not stored twice
+	ScnDedupWindowSec int
+
+	// The directory where scenes, which could not be persisted because the
+	// DB is unavailable, are kept until the DB is back. Empty value turns the
+	// spool off. ScnSpoolMaxSize limits the spool size like "500M"
+	ScnSpoolDir     string
+	ScnSpoolMaxSize string
 
 	// Matcher
 	MchrCacheSize       int     // max cache size (counted in number of V128 records)
@@ -80,12 +204,25 @@ type ConsoleConfig struct {
 
 func (cc *ConsoleConfig) NiceString() string {
 	return fmt.Sprint("{\n\tLogConfigFN=", cc.LogConfigFN, ",\n\tHttpPort=", cc.HttpPort, ",\n\tHttpDebugMode=", cc.HttpDebugMode,
-		",\n\tGrpcFPCPPort=", cc.GrpcFPCPPort, ",\n\tGrpcFPCPSessCapacity=", cc.GrpcFPCPSessCapacity, ",\n\tDebugMode=",
-		cc.DebugMode, ",\n\tMysqlDatasource=", cc.MysqlDatasource, ",\n\tLbsDir=", cc.LbsDir, ",\n\tLbsMaxSize=", cc.LbsMaxSize,
-		"(", cc.GetLbsMaxSizeBytes(), "bytes)", ",\n\tImgsPrefix=", cc.ImgsPrefix, ",\n\tImgsTmpTTLSec=", cc.ImgsTmpTTLSec,
+		",\n\tGrpcFPCPPort=", cc.GrpcFPCPPort, ",\n\tGrpcFPCPSessCapacity=", cc.GrpcFPCPSessCapacity,
+		",\n\tCamOfflineToSec=", cc.CamOfflineToSec, ",\n\tGrpcFPCPTlsCert=", cc.GrpcFPCPTlsCert, ",\n\tGrpcFPCPTlsKey=", cc.GrpcFPCPTlsKey,
+		",\n\tGrpcFPCPClientCA=", cc.GrpcFPCPClientCA, ",\n\tGrpcFPCPClientAuth=", cc.GrpcFPCPClientAuth,
+		",\n\tGrpcFPCPTlsReloadSec=", cc.GrpcFPCPTlsReloadSec, ",\n\tDebugMode=",
+		cc.DebugMode, ",\n\tMysqlDatasource=", cc.MysqlDatasource, ",\n\tBlobStorage=", cc.BlobStorage, ",\n\tBlobKeysFile=", cc.BlobKeysFile, ",\n\tLbsDir=", cc.LbsDir, ",\n\tLbsMaxSize=", cc.LbsMaxSize,
+		"(", cc.GetLbsMaxSizeBytes(), "bytes)", ",\n\tLbsOrgMaxSize=", cc.LbsOrgMaxSize, ",\n\tLbsOrgMaxSizes=", cc.LbsOrgMaxSizes,
+		",\n\tS3Endpoint=", cc.S3Endpoint, ",\n\tS3Region=", cc.S3Region,
+		",\n\tS3Bucket=", cc.S3Bucket, ",\n\tS3KeyPrefix=", cc.S3KeyPrefix, ",\n\tImgsPrefix=", cc.ImgsPrefix, ",\n\tImgsTmpTTLSec=", cc.ImgsTmpTTLSec,
+		",\n\tImgsSignKey set=", cc.ImgsSignKey != "", ",\n\tImgsSignTTLSec=", cc.ImgsSignTTLSec,
+		",\n\tImgsMaxWidth=", cc.ImgsMaxWidth, ",\n\tImgsMaxHeight=", cc.ImgsMaxHeight,
+		",\n\tImgsCropOnDemand=", cc.ImgsCropOnDemand, ",\n\tImgsCropBorder=", cc.ImgsCropBorder,
 		",\n\tSweepFacesToSec=", cc.SweepFacesToSec, ",\n\tSweepImagesPackSize=", cc.SweepImagesPackSize,
 		",\n\tSweepImagesPackSize=", cc.SweepImagesPackSize, ",\n\tSweepImagesPackSizePauseMs=", cc.SweepImagesPackSizePauseMs,
-		",\n\tSweepOrphPersonsMins=", cc.SweepOrphPersonsMins,
+		",\n\tSweepOrphPersonsMins=", cc.SweepOrphPersonsMins, ",\n\tSweepScrubToSec=", cc.SweepScrubToSec,
+		",\n\tSweepReconcileToSec=", cc.SweepReconcileToSec, ",\n\tReconcileMode=", cc.ReconcileMode,
+		",\n\tSweepKeysToSec=", cc.SweepKeysToSec,
+		",\n\tScnFaceRetention=", &cc.ScnFaceRetention, ",\n\tScnCamFaceRetentions=", cc.ScnCamFaceRetentions,
+		",\n\tScnQueueSize=", cc.ScnQueueSize, ",\n\tScnWorkers=", cc.ScnWorkers, ",\n\tScnDedupWindowSec=", cc.ScnDedupWindowSec,
+		",\n\tScnSpoolDir=", cc.ScnSpoolDir, ",\n\tScnSpoolMaxSize=", cc.ScnSpoolMaxSize,
 		",\n\tMchrCacheSize=", cc.MchrCacheSize, "\n\tMchrCachePerOrgSize=", cc.MchrCachePerOrgSize,
 		",\n\tMchrPositiveTrshld=", cc.MchrPositiveTrshld, "\n\tMchrDistance=", cc.MchrDistance,
 		",\n\tPprofURL=", cc.PprofURL,
@@ -103,11 +240,19 @@ func NewConsoleConfig() *ConsoleConfig {
 	cc.HttpPort = 8080
 	cc.GrpcFPCPPort = 50051
 	cc.GrpcFPCPSessCapacity = 10000
+	cc.CamOfflineToSec = 60
+	cc.GrpcFPCPTlsReloadSec = 60
 	cc.MysqlDatasource = "pixty@/pixty?charset=utf8mb4"
+	cc.BlobStorage = BLOB_STORAGE_LFS
 	cc.LbsDir = "/opt/pixty/store"
 	cc.LbsMaxSize = "20G"
+	cc.S3Region = "us-east-1"
 	cc.ImgsPrefix = "http://127.0.0.1:8080/images/"
 	cc.ImgsTmpTTLSec = 60
+	cc.ImgsSignTTLSec = 3600
+	cc.ImgsMaxWidth = 1920
+	cc.ImgsMaxHeight = 1080
+	cc.ImgsCropBorder = 20
 	cc.AuthMaxSessions = 3               // same user can open up to 3 sessions (so far, then will reduce)
 	cc.AuthSessionTOSec = 300            // kick it out in 5 minutes
 	cc.EmailSmtpServer = "mail.name.com" // mail.name.com:465?
@@ -117,6 +262,16 @@ func NewConsoleConfig() *ConsoleConfig {
 	cc.SweepImagesPackSize = 1000
 	cc.SweepImagesPackSizePauseMs = 5
 	cc.SweepOrphPersonsMins = 10
+	cc.SweepScrubToSec = 86400
+	cc.SweepReconcileToSec = 86400
+	cc.SweepKeysToSec = 3600
+	cc.ReconcileMode = RECONCILE_DRY_RUN
+	cc.ScnFaceRetention = FaceRetention{Policy: FACE_RETENTION_DEFAULT, CacheTTLSec: 300, NewPersonFaces: 3,
+		MaxFaces: 5, MinIntervalSec: 30, MaxIntervalSec: 120, BestN: 3}
+	cc.ScnQueueSize = 20
+	cc.ScnWorkers = 4
+	cc.ScnDedupWindowSec = 300
+	cc.ScnSpoolMaxSize = "500M"
 	cc.MchrCacheSize = 1000000     // 1 million is max so far
 	cc.MchrCachePerOrgSize = 50000 // vectors per org looks reasonable
 	cc.MchrPositiveTrshld = 30     // 30% should be within required distance at least
@@ -135,24 +290,90 @@ func (cc *ConsoleConfig) apply(cc1 *ConsoleConfig) {
 	if cc1.GrpcFPCPSessCapacity > 0 {
 		cc.GrpcFPCPSessCapacity = cc1.GrpcFPCPSessCapacity
 	}
+	if cc1.CamOfflineToSec > 0 {
+		cc.CamOfflineToSec = cc1.CamOfflineToSec
+	}
+	if cc1.GrpcFPCPTlsCert != "" {
+		cc.GrpcFPCPTlsCert = cc1.GrpcFPCPTlsCert
+	}
+	if cc1.GrpcFPCPTlsKey != "" {
+		cc.GrpcFPCPTlsKey = cc1.GrpcFPCPTlsKey
+	}
+	if cc1.GrpcFPCPClientCA != "" {
+		cc.GrpcFPCPClientCA = cc1.GrpcFPCPClientCA
+	}
+	if cc1.GrpcFPCPClientAuth != "" {
+		cc.GrpcFPCPClientAuth = cc1.GrpcFPCPClientAuth
+	}
+	if cc1.GrpcFPCPTlsReloadSec > 0 {
+		cc.GrpcFPCPTlsReloadSec = cc1.GrpcFPCPTlsReloadSec
+	}
 	if cc1.MysqlDatasource != "" {
 		cc.MysqlDatasource = cc1.MysqlDatasource
 	}
 	if cc1.LbsDir != "" {
 		cc.LbsDir = cc1.LbsDir
 	}
+	if cc1.BlobStorage != "" {
+		cc.BlobStorage = cc1.BlobStorage
+	}
+	if cc1.BlobKeysFile != "" {
+		cc.BlobKeysFile = cc1.BlobKeysFile
+	}
+	if cc1.S3Endpoint != "" {
+		cc.S3Endpoint = cc1.S3Endpoint
+	}
+	if cc1.S3Region != "" {
+		cc.S3Region = cc1.S3Region
+	}
+	if cc1.S3Bucket != "" {
+		cc.S3Bucket = cc1.S3Bucket
+	}
+	if cc1.S3AccessKey != "" {
+		cc.S3AccessKey = cc1.S3AccessKey
+	}
+	if cc1.S3SecretKey != "" {
+		cc.S3SecretKey = cc1.S3SecretKey
+	}
+	if cc1.S3KeyPrefix != "" {
+		cc.S3KeyPrefix = cc1.S3KeyPrefix
+	}
 	if cc1.LogConfigFN != "" {
 		cc.LogConfigFN = cc1.LogConfigFN
 	}
 	if cc1.LbsMaxSize != "" {
 		cc.LbsMaxSize = cc1.LbsMaxSize
 	}
+	if cc1.LbsOrgMaxSize != "" {
+		cc.LbsOrgMaxSize = cc1.LbsOrgMaxSize
+	}
+	if len(cc1.LbsOrgMaxSizes) > 0 {
+		cc.LbsOrgMaxSizes = cc1.LbsOrgMaxSizes
+	}
 	if cc1.ImgsPrefix != "" {
 		cc.ImgsPrefix = cc1.ImgsPrefix
 	}
 	if cc1.ImgsTmpTTLSec != 0 {
 		cc.ImgsTmpTTLSec = cc1.ImgsTmpTTLSec
 	}
+	if cc1.ImgsSignKey != "" {
+		cc.ImgsSignKey = cc1.ImgsSignKey
+	}
+	if cc1.ImgsSignTTLSec > 0 {
+		cc.ImgsSignTTLSec = cc1.ImgsSignTTLSec
+	}
+	if cc1.ImgsMaxWidth > 0 {
+		cc.ImgsMaxWidth = cc1.ImgsMaxWidth
+	}
+	if cc1.ImgsMaxHeight > 0 {
+		cc.ImgsMaxHeight = cc1.ImgsMaxHeight
+	}
+	if cc1.ImgsCropOnDemand {
+		cc.ImgsCropOnDemand = true
+	}
+	if cc1.ImgsCropBorder > 0 {
+		cc.ImgsCropBorder = cc1.ImgsCropBorder
+	}
 	if cc1.logger != nil {
 		cc.logger = cc1.logger
 	}
@@ -183,6 +404,37 @@ func (cc *ConsoleConfig) apply(cc1 *ConsoleConfig) {
 	if cc1.SweepOrphPersonsMins > 0 {
 		cc.SweepOrphPersonsMins = cc1.SweepOrphPersonsMins
 	}
+	if cc1.SweepScrubToSec > 0 {
+		cc.SweepScrubToSec = cc1.SweepScrubToSec
+	}
+	if cc1.SweepReconcileToSec > 0 {
+		cc.SweepReconcileToSec = cc1.SweepReconcileToSec
+	}
+	if cc1.SweepKeysToSec > 0 {
+		cc.SweepKeysToSec = cc1.SweepKeysToSec
+	}
+	if cc1.ReconcileMode != "" {
+		cc.ReconcileMode = cc1.ReconcileMode
+	}
+	cc.ScnFaceRetention.apply(&cc1.ScnFaceRetention)
+	if len(cc1.ScnCamFaceRetentions) > 0 {
+		cc.ScnCamFaceRetentions = cc1.ScnCamFaceRetentions
+	}
+	if cc1.ScnQueueSize > 0 {
+		cc.ScnQueueSize = cc1.ScnQueueSize
+	}
+	if cc1.ScnWorkers > 0 {
+		cc.ScnWorkers = cc1.ScnWorkers
+	}
+	if cc1.ScnDedupWindowSec > 0 {
+		cc.ScnDedupWindowSec = cc1.ScnDedupWindowSec
+	}
+	if cc1.ScnSpoolDir != "" {
+		cc.ScnSpoolDir = cc1.ScnSpoolDir
+	}
+	if cc1.ScnSpoolMaxSize != "" {
+		cc.ScnSpoolMaxSize = cc1.ScnSpoolMaxSize
+	}
 	if cc1.MchrCacheSize > 0 {
 		cc.MchrCacheSize = cc1.MchrCacheSize
 	}
@@ -264,3 +516,70 @@ func (cc *ConsoleConfig) GetLbsMaxSizeBytes() int64 {
 	}
 	return res
 }
+
+// Returns the org quota in bytes, 0 means no quota
+func (cc *ConsoleConfig) GetLbsOrgMaxSizeBytes(orgId int64) int64 {
+	sz, ok := cc.LbsOrgMaxSizes[strconv.FormatInt(orgId, 10)]
+	if !ok {
+		sz = cc.LbsOrgMaxSize
+	}
+	if sz == "" {
+		return 0
+	}
+	res, err := gorivets.ParseInt64(sz, 0, math.MaxInt64, 0)
+	if err != nil {
+		cc.logger.Error("Could not parse the org quota=", sz, " for orgId=", orgId, ", no quota will be applied. err=", err)
+		return 0
+	}
+	return res
+}
+
+// Returns the scenes spool size limit in bytes
+func (cc *ConsoleConfig) GetScnSpoolMaxSizeBytes() int64 {
+	res, err := gorivets.ParseInt64(cc.ScnSpoolMaxSize, 1000000, math.MaxInt64, 500000000)
+	if err != nil {
+		cc.logger.Error("Could not parse the scenes spool size=", cc.ScnSpoolMaxSize, ", will use 500M. err=", err)
+		return 500000000
+	}
+	return res
+}
+
+// Returns the scene faces retention policy for the camera, the camera
+// specific settings are applied on top of the global ones
+func (cc *ConsoleConfig) GetFaceRetention(camId int64) *FaceRetention {
+	fr := cc.ScnFaceRetention
+	if cfr, ok := cc.ScnCamFaceRetentions[strconv.FormatInt(camId, 10)]; ok && cfr != nil {
+		fr.apply(cfr)
+	}
+	return &fr
+}
+
+func (fr *FaceRetention) apply(fr1 *FaceRetention) {
+	if fr1.Policy != "" {
+		fr.Policy = fr1.Policy
+	}
+	if fr1.CacheTTLSec > 0 {
+		fr.CacheTTLSec = fr1.CacheTTLSec
+	}
+	if fr1.NewPersonFaces > 0 {
+		fr.NewPersonFaces = fr1.NewPersonFaces
+	}
+	if fr1.MaxFaces > 0 {
+		fr.MaxFaces = fr1.MaxFaces
+	}
+	if fr1.MinIntervalSec > 0 {
+		fr.MinIntervalSec = fr1.MinIntervalSec
+	}
+	if fr1.MaxIntervalSec > 0 {
+		fr.MaxIntervalSec = fr1.MaxIntervalSec
+	}
+	if fr1.BestN > 0 {
+		fr.BestN = fr1.BestN
+	}
+}
+
+func (fr *FaceRetention) String() string {
+	return fmt.Sprint("{Policy=", fr.Policy, ", CacheTTLSec=", fr.CacheTTLSec, ", NewPersonFaces=", fr.NewPersonFaces,
+		", MaxFaces=", fr.MaxFaces, ", MinIntervalSec=", fr.MinIntervalSec, ", MaxIntervalSec=", fr.MaxIntervalSec,
+		", BestN=", fr.BestN, "}")
+}
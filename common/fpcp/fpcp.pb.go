@@ -16,6 +16,12 @@ It has these top-level messages:
 	Frame
 	Face
 	Picture
+	SceneReq
+	SceneAck
+	CamConfig
+	CamCommand
+	ControlMsg
+	ControlAck
 */
 package fpcp
 
@@ -63,6 +69,33 @@ func (x Picture_Format) String() string {
 }
 func (Picture_Format) EnumDescriptor() ([]byte, []int) { return fileDescriptor0, []int{7, 0} }
 
+type CamCommand_Type int32
+
+const (
+	// Send the current frame in a scene now
+	CamCommand_SNAPSHOT CamCommand_Type = 0
+	// Authenticate again and reopen the streams
+	CamCommand_REAUTHENTICATE CamCommand_Type = 1
+	// Restart the frame processor
+	CamCommand_RESTART CamCommand_Type = 2
+)
+
+var CamCommand_Type_name = map[int32]string{
+	0: "SNAPSHOT",
+	1: "REAUTHENTICATE",
+	2: "RESTART",
+}
+var CamCommand_Type_value = map[string]int32{
+	"SNAPSHOT":       0,
+	"REAUTHENTICATE": 1,
+	"RESTART":        2,
+}
+
+func (x CamCommand_Type) String() string {
+	return proto.EnumName(CamCommand_Type_name, int32(x))
+}
+func (CamCommand_Type) EnumDescriptor() ([]byte, []int) { return fileDescriptor0, []int{11, 0} }
+
 // Just use it like an empty message
 type Void struct {
 }
@@ -348,6 +381,215 @@ func (m *Picture) GetData() []byte {
 	return nil
 }
 
+// The scene sent to the scenes stream
+type SceneReq struct {
+	// The client sequence number, it is returned in the acknowledgement
+	Seq   uint64 `protobuf:"varint,1,opt,name=seq" json:"seq,omitempty"`
+	Scene *Scene `protobuf:"bytes,2,opt,name=scene" json:"scene,omitempty"`
+}
+
+func (m *SceneReq) Reset()                    { *m = SceneReq{} }
+func (m *SceneReq) String() string            { return proto.CompactTextString(m) }
+func (*SceneReq) ProtoMessage()               {}
+func (*SceneReq) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{8} }
+
+func (m *SceneReq) GetSeq() uint64 {
+	if m != nil {
+		return m.Seq
+	}
+	return 0
+}
+
+func (m *SceneReq) GetScene() *Scene {
+	if m != nil {
+		return m.Scene
+	}
+	return nil
+}
+
+// The acknowledgement of a scene received from the scenes stream
+type SceneAck struct {
+	// The acknowledged scene seq
+	Seq uint64 `protobuf:"varint,1,opt,name=seq" json:"seq,omitempty"`
+	// Empty if the scene is accepted, otherwise the error code, the same
+	// as the unary calls return in the "error" trailer
+	Error string `protobuf:"bytes,2,opt,name=error" json:"error,omitempty"`
+	// Number of scenes the client can send before it gets the next
+	// acknowledgement. The client should wait for an acknowledgement when
+	// it runs out of credits.
+	Credits int32 `protobuf:"varint,3,opt,name=credits" json:"credits,omitempty"`
+}
+
+func (m *SceneAck) Reset()                    { *m = SceneAck{} }
+func (m *SceneAck) String() string            { return proto.CompactTextString(m) }
+func (*SceneAck) ProtoMessage()               {}
+func (*SceneAck) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{9} }
+
+func (m *SceneAck) GetSeq() uint64 {
+	if m != nil {
+		return m.Seq
+	}
+	return 0
+}
+
+func (m *SceneAck) GetError() string {
+	if m != nil {
+		return m.Error
+	}
+	return ""
+}
+
+func (m *SceneAck) GetCredits() int32 {
+	if m != nil {
+		return m.Credits
+	}
+	return 0
+}
+
+// The camera settings managed by the console. Zero values mean the frame
+// processor defaults.
+type CamConfig struct {
+	// The config version, the console increases it on every change
+	Version uint64 `protobuf:"varint,1,opt,name=version" json:"version,omitempty"`
+	// Frames per second to process
+	FrameRate int32 `protobuf:"varint,2,opt,name=frameRate" json:"frameRate,omitempty"`
+	// The max size of the frame pictures sent in scenes
+	FrameSize *Size `protobuf:"bytes,3,opt,name=frameSize" json:"frameSize,omitempty"`
+	// The max size of the face pictures sent in scenes
+	FaceSize *Size `protobuf:"bytes,4,opt,name=faceSize" json:"faceSize,omitempty"`
+	// The face detection confidence threshold in 0..1
+	DetectThreshold float32 `protobuf:"fixed32,5,opt,name=detectThreshold" json:"detectThreshold,omitempty"`
+}
+
+func (m *CamConfig) Reset()                    { *m = CamConfig{} }
+func (m *CamConfig) String() string            { return proto.CompactTextString(m) }
+func (*CamConfig) ProtoMessage()               {}
+func (*CamConfig) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{10} }
+
+func (m *CamConfig) GetVersion() uint64 {
+	if m != nil {
+		return m.Version
+	}
+	return 0
+}
+
+func (m *CamConfig) GetFrameRate() int32 {
+	if m != nil {
+		return m.FrameRate
+	}
+	return 0
+}
+
+func (m *CamConfig) GetFrameSize() *Size {
+	if m != nil {
+		return m.FrameSize
+	}
+	return nil
+}
+
+func (m *CamConfig) GetFaceSize() *Size {
+	if m != nil {
+		return m.FaceSize
+	}
+	return nil
+}
+
+func (m *CamConfig) GetDetectThreshold() float32 {
+	if m != nil {
+		return m.DetectThreshold
+	}
+	return 0
+}
+
+type CamCommand struct {
+	// The console command id, it is returned in the acknowledgement
+	Id   string          `protobuf:"bytes,1,opt,name=id" json:"id,omitempty"`
+	Type CamCommand_Type `protobuf:"varint,2,opt,name=type,enum=fpcp.CamCommand_Type" json:"type,omitempty"`
+}
+
+func (m *CamCommand) Reset()                    { *m = CamCommand{} }
+func (m *CamCommand) String() string            { return proto.CompactTextString(m) }
+func (*CamCommand) ProtoMessage()               {}
+func (*CamCommand) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{11} }
+
+func (m *CamCommand) GetId() string {
+	if m != nil {
+		return m.Id
+	}
+	return ""
+}
+
+func (m *CamCommand) GetType() CamCommand_Type {
+	if m != nil {
+		return m.Type
+	}
+	return CamCommand_SNAPSHOT
+}
+
+// The message sent by the console to the control stream, one of the
+// fields is set
+type ControlMsg struct {
+	Config  *CamConfig  `protobuf:"bytes,1,opt,name=config" json:"config,omitempty"`
+	Command *CamCommand `protobuf:"bytes,2,opt,name=command" json:"command,omitempty"`
+}
+
+func (m *ControlMsg) Reset()                    { *m = ControlMsg{} }
+func (m *ControlMsg) String() string            { return proto.CompactTextString(m) }
+func (*ControlMsg) ProtoMessage()               {}
+func (*ControlMsg) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{12} }
+
+func (m *ControlMsg) GetConfig() *CamConfig {
+	if m != nil {
+		return m.Config
+	}
+	return nil
+}
+
+func (m *ControlMsg) GetCommand() *CamCommand {
+	if m != nil {
+		return m.Command
+	}
+	return nil
+}
+
+// The client report sent to the control stream, it acknowledges either
+// the config or the command
+type ControlAck struct {
+	// The applied config version
+	ConfigVersion uint64 `protobuf:"varint,1,opt,name=configVersion" json:"configVersion,omitempty"`
+	// The executed command id
+	CommandId string `protobuf:"bytes,2,opt,name=commandId" json:"commandId,omitempty"`
+	// Empty if the config is applied or the command is executed, otherwise
+	// the error description
+	Error string `protobuf:"bytes,3,opt,name=error" json:"error,omitempty"`
+}
+
+func (m *ControlAck) Reset()                    { *m = ControlAck{} }
+func (m *ControlAck) String() string            { return proto.CompactTextString(m) }
+func (*ControlAck) ProtoMessage()               {}
+func (*ControlAck) Descriptor() ([]byte, []int) { return fileDescriptor0, []int{13} }
+
+func (m *ControlAck) GetConfigVersion() uint64 {
+	if m != nil {
+		return m.ConfigVersion
+	}
+	return 0
+}
+
+func (m *ControlAck) GetCommandId() string {
+	if m != nil {
+		return m.CommandId
+	}
+	return ""
+}
+
+func (m *ControlAck) GetError() string {
+	if m != nil {
+		return m.Error
+	}
+	return ""
+}
+
 func init() {
 	proto.RegisterType((*Void)(nil), "fpcp.Void")
 	proto.RegisterType((*Size)(nil), "fpcp.Size")
@@ -357,7 +599,14 @@ func init() {
 	proto.RegisterType((*Frame)(nil), "fpcp.Frame")
 	proto.RegisterType((*Face)(nil), "fpcp.Face")
 	proto.RegisterType((*Picture)(nil), "fpcp.Picture")
+	proto.RegisterType((*SceneReq)(nil), "fpcp.SceneReq")
+	proto.RegisterType((*SceneAck)(nil), "fpcp.SceneAck")
+	proto.RegisterType((*CamConfig)(nil), "fpcp.CamConfig")
+	proto.RegisterType((*CamCommand)(nil), "fpcp.CamCommand")
+	proto.RegisterType((*ControlMsg)(nil), "fpcp.ControlMsg")
+	proto.RegisterType((*ControlAck)(nil), "fpcp.ControlAck")
 	proto.RegisterEnum("fpcp.Picture_Format", Picture_Format_name, Picture_Format_value)
+	proto.RegisterEnum("fpcp.CamCommand_Type", CamCommand_Type_name, CamCommand_Type_value)
 }
 
 // Reference imports to suppress errors if they are not otherwise used.
@@ -375,6 +624,19 @@ type SceneProcessorServiceClient interface {
 	Authenticate(ctx context.Context, in *AuthToken, opts ...grpc.CallOption) (*Void, error)
 	// Sends the client scene information.
 	OnScene(ctx context.Context, in *Scene, opts ...grpc.CallOption) (*Void, error)
+	// Opens the long-lived scenes stream. The client must be authenticated
+	// and pass the "session_id" in the stream metadata. Every scene sent to
+	// the stream is acknowledged, the first acknowledgement with seq 0 is
+	// sent when the stream is opened and gives the initial credits. The
+	// acknowledgement with seq 0 is sent also when the client runs out of
+	// credits and the queue has room again.
+	Scenes(ctx context.Context, opts ...grpc.CallOption) (SceneProcessorService_ScenesClient, error)
+	// Opens the long-lived control stream, the console sends the camera
+	// config and commands to. The client must be authenticated and pass the
+	// "session_id" in the stream metadata. The current config is sent when
+	// the stream is opened. The client reports the config it applies and
+	// the commands results.
+	Control(ctx context.Context, opts ...grpc.CallOption) (SceneProcessorService_ControlClient, error)
 }
 
 type sceneProcessorServiceClient struct {
@@ -403,6 +665,68 @@ func (c *sceneProcessorServiceClient) OnScene(ctx context.Context, in *Scene, op
 	return out, nil
 }
 
+func (c *sceneProcessorServiceClient) Scenes(ctx context.Context, opts ...grpc.CallOption) (SceneProcessorService_ScenesClient, error) {
+	stream, err := grpc.NewClientStream(ctx, &_SceneProcessorService_serviceDesc.Streams[0], c.cc, "/fpcp.SceneProcessorService/scenes", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &sceneProcessorServiceScenesClient{stream}
+	return x, nil
+}
+
+type SceneProcessorService_ScenesClient interface {
+	Send(*SceneReq) error
+	Recv() (*SceneAck, error)
+	grpc.ClientStream
+}
+
+type sceneProcessorServiceScenesClient struct {
+	grpc.ClientStream
+}
+
+func (x *sceneProcessorServiceScenesClient) Send(m *SceneReq) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *sceneProcessorServiceScenesClient) Recv() (*SceneAck, error) {
+	m := new(SceneAck)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func (c *sceneProcessorServiceClient) Control(ctx context.Context, opts ...grpc.CallOption) (SceneProcessorService_ControlClient, error) {
+	stream, err := grpc.NewClientStream(ctx, &_SceneProcessorService_serviceDesc.Streams[1], c.cc, "/fpcp.SceneProcessorService/control", opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &sceneProcessorServiceControlClient{stream}
+	return x, nil
+}
+
+type SceneProcessorService_ControlClient interface {
+	Send(*ControlAck) error
+	Recv() (*ControlMsg, error)
+	grpc.ClientStream
+}
+
+type sceneProcessorServiceControlClient struct {
+	grpc.ClientStream
+}
+
+func (x *sceneProcessorServiceControlClient) Send(m *ControlAck) error {
+	return x.ClientStream.SendMsg(m)
+}
+
+func (x *sceneProcessorServiceControlClient) Recv() (*ControlMsg, error) {
+	m := new(ControlMsg)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
 // Server API for SceneProcessorService service
 
 type SceneProcessorServiceServer interface {
@@ -410,6 +734,19 @@ type SceneProcessorServiceServer interface {
 	Authenticate(context.Context, *AuthToken) (*Void, error)
 	// Sends the client scene information.
 	OnScene(context.Context, *Scene) (*Void, error)
+	// Opens the long-lived scenes stream. The client must be authenticated
+	// and pass the "session_id" in the stream metadata. Every scene sent to
+	// the stream is acknowledged, the first acknowledgement with seq 0 is
+	// sent when the stream is opened and gives the initial credits. The
+	// acknowledgement with seq 0 is sent also when the client runs out of
+	// credits and the queue has room again.
+	Scenes(SceneProcessorService_ScenesServer) error
+	// Opens the long-lived control stream, the console sends the camera
+	// config and commands to. The client must be authenticated and pass the
+	// "session_id" in the stream metadata. The current config is sent when
+	// the stream is opened. The client reports the config it applies and
+	// the commands results.
+	Control(SceneProcessorService_ControlServer) error
 }
 
 func RegisterSceneProcessorServiceServer(s *grpc.Server, srv SceneProcessorServiceServer) {
@@ -452,6 +789,58 @@ func _SceneProcessorService_OnScene_Handler(srv interface{}, ctx context.Context
 	return interceptor(ctx, in, info, handler)
 }
 
+func _SceneProcessorService_Scenes_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(SceneProcessorServiceServer).Scenes(&sceneProcessorServiceScenesServer{stream})
+}
+
+type SceneProcessorService_ScenesServer interface {
+	Send(*SceneAck) error
+	Recv() (*SceneReq, error)
+	grpc.ServerStream
+}
+
+type sceneProcessorServiceScenesServer struct {
+	grpc.ServerStream
+}
+
+func (x *sceneProcessorServiceScenesServer) Send(m *SceneAck) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *sceneProcessorServiceScenesServer) Recv() (*SceneReq, error) {
+	m := new(SceneReq)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+func _SceneProcessorService_Control_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(SceneProcessorServiceServer).Control(&sceneProcessorServiceControlServer{stream})
+}
+
+type SceneProcessorService_ControlServer interface {
+	Send(*ControlMsg) error
+	Recv() (*ControlAck, error)
+	grpc.ServerStream
+}
+
+type sceneProcessorServiceControlServer struct {
+	grpc.ServerStream
+}
+
+func (x *sceneProcessorServiceControlServer) Send(m *ControlMsg) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+func (x *sceneProcessorServiceControlServer) Recv() (*ControlAck, error) {
+	m := new(ControlAck)
+	if err := x.ServerStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
 var _SceneProcessorService_serviceDesc = grpc.ServiceDesc{
 	ServiceName: "fpcp.SceneProcessorService",
 	HandlerType: (*SceneProcessorServiceServer)(nil),
@@ -465,44 +854,77 @@ var _SceneProcessorService_serviceDesc = grpc.ServiceDesc{
 			Handler:    _SceneProcessorService_OnScene_Handler,
 		},
 	},
-	Streams:  []grpc.StreamDesc{},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "scenes",
+			Handler:       _SceneProcessorService_Scenes_Handler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
+		{
+			StreamName:    "control",
+			Handler:       _SceneProcessorService_Control_Handler,
+			ServerStreams: true,
+			ClientStreams: true,
+		},
+	},
 	Metadata: "fpcp.proto",
 }
 
 func init() { proto.RegisterFile("fpcp.proto", fileDescriptor0) }
 
 var fileDescriptor0 = []byte{
-	// 503 bytes of a gzipped FileDescriptorProto
-	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x94, 0x53, 0x41, 0x6f, 0x13, 0x3d,
-	0x10, 0xfd, 0x36, 0xeb, 0x4d, 0x9a, 0x49, 0xdb, 0x2f, 0xb2, 0x0a, 0x5a, 0x55, 0x08, 0x85, 0xed,
-	0x25, 0x08, 0x94, 0x43, 0xe0, 0xc6, 0xa9, 0x42, 0x6a, 0x25, 0x0e, 0x28, 0x72, 0x10, 0x1c, 0x91,
-	0xeb, 0x9d, 0x74, 0x2d, 0xba, 0xeb, 0x95, 0xed, 0x14, 0xa9, 0x07, 0xc4, 0x0f, 0xe0, 0xa7, 0xf0,
-	0x23, 0x91, 0xc7, 0x66, 0x01, 0x55, 0x1c, 0x38, 0xed, 0xbc, 0x37, 0x33, 0x3b, 0xcf, 0xe3, 0x67,
-	0x80, 0x5d, 0xaf, 0xfa, 0x55, 0x6f, 0x8d, 0x37, 0x9c, 0x85, 0xb8, 0x1a, 0x03, 0x7b, 0x6f, 0x74,
-	0x5d, 0xbd, 0x04, 0xb6, 0xd5, 0x77, 0xc8, 0x4f, 0xa0, 0xf8, 0xac, 0x6b, 0xdf, 0x94, 0xd9, 0x22,
-	0x5b, 0x1e, 0x89, 0x08, 0xf8, 0x43, 0x18, 0x37, 0xa8, 0xaf, 0x1b, 0x5f, 0x8e, 0x88, 0x4e, 0xa8,
-	0xfa, 0x08, 0x53, 0x81, 0xca, 0xcb, 0xee, 0xfa, 0x06, 0x39, 0x07, 0x76, 0x83, 0x3b, 0x4f, 0x9d,
-	0x85, 0xa0, 0x98, 0xcf, 0x21, 0xf7, 0xa6, 0xa7, 0xae, 0x42, 0x84, 0x30, 0x0c, 0xb0, 0xf4, 0xa7,
-	0x9c, 0xb8, 0x08, 0xc2, 0x80, 0x2b, 0xe3, 0xbd, 0x69, 0x4b, 0x46, 0x74, 0x42, 0xd5, 0x2b, 0x98,
-	0x9e, 0xef, 0x7d, 0xf3, 0xce, 0x7c, 0xc2, 0x2e, 0x14, 0x49, 0xa5, 0xd0, 0x39, 0x1a, 0x31, 0x15,
-	0x09, 0x05, 0xde, 0xa1, 0xb2, 0x18, 0xd5, 0x4d, 0x45, 0x42, 0xd5, 0xb7, 0x0c, 0x8a, 0xad, 0xc2,
-	0x0e, 0xf9, 0x31, 0x8c, 0x74, 0x9d, 0xba, 0x46, 0xba, 0x0e, 0x22, 0x9c, 0xee, 0x14, 0x52, 0x03,
-	0x13, 0x11, 0xf0, 0x12, 0x26, 0x3d, 0x5a, 0x67, 0x3a, 0x97, 0xc4, 0xfd, 0x84, 0xfc, 0x09, 0x14,
-	0x3b, 0x2b, 0x5b, 0x24, 0x75, 0xb3, 0xf5, 0x6c, 0x45, 0x7b, 0xbc, 0x08, 0x94, 0x88, 0x19, 0xbe,
-	0x80, 0x62, 0x27, 0x15, 0xba, 0xb2, 0x58, 0xe4, 0xcb, 0xd9, 0x1a, 0x52, 0x89, 0x54, 0xa1, 0x22,
-	0x24, 0xaa, 0xaf, 0x19, 0x14, 0xd4, 0x72, 0x4f, 0xce, 0x23, 0x98, 0x7a, 0xdd, 0xa2, 0xf3, 0xb2,
-	0xed, 0x93, 0xa4, 0x5f, 0x04, 0x7f, 0x0c, 0xcc, 0xe9, 0x3b, 0x24, 0x4d, 0xc3, 0x8f, 0xc3, 0x65,
-	0x09, 0xe2, 0xf9, 0x53, 0x38, 0xe8, 0xb5, 0xf2, 0x7b, 0x8b, 0xae, 0x64, 0x34, 0xfc, 0x28, 0xd6,
-	0x6c, 0x22, 0x2b, 0x86, 0x74, 0xf5, 0x05, 0x58, 0x50, 0x74, 0x4f, 0xc0, 0x19, 0x30, 0x8b, 0x2a,
-	0xee, 0x6f, 0xb6, 0xfe, 0x3f, 0xb6, 0x0f, 0x37, 0x2b, 0x28, 0x19, 0xd6, 0x7c, 0x8b, 0xca, 0x1b,
-	0x5b, 0xe6, 0x8b, 0x7c, 0x39, 0x12, 0x09, 0xfd, 0xcb, 0xfc, 0xef, 0x19, 0x4c, 0x12, 0x3b, 0x1c,
-	0x2b, 0xfb, 0xcb, 0xb1, 0x4e, 0xe1, 0x20, 0x7c, 0x5f, 0x9b, 0x1a, 0x93, 0x7f, 0x06, 0xcc, 0x9f,
-	0xc3, 0x78, 0x67, 0x6c, 0x2b, 0xa3, 0x8b, 0x8e, 0xd7, 0x27, 0x7f, 0x0c, 0x5c, 0x5d, 0x50, 0x4e,
-	0xa4, 0x9a, 0x60, 0xcc, 0x5a, 0x7a, 0x49, 0x97, 0x77, 0x28, 0x28, 0xae, 0xce, 0x60, 0x1c, 0xab,
-	0xf8, 0x04, 0x72, 0x71, 0xfe, 0x61, 0xfe, 0x5f, 0x08, 0x36, 0x6f, 0x2f, 0xe7, 0x59, 0x08, 0xde,
-	0x6c, 0x2e, 0xe7, 0xa3, 0x75, 0x03, 0x0f, 0xc8, 0x3f, 0x1b, 0x6b, 0x82, 0xd1, 0x8c, 0xdd, 0xa2,
-	0xbd, 0xd5, 0x0a, 0xf9, 0x33, 0x38, 0x94, 0x7b, 0xdf, 0x60, 0xe7, 0xb5, 0x92, 0x1e, 0x79, 0xda,
-	0xd8, 0x60, 0xd5, 0xd3, 0x74, 0x9c, 0xf0, 0xb4, 0x78, 0x05, 0x13, 0xd3, 0x45, 0x1f, 0x26, 0xe3,
-	0x10, 0xf8, 0xbd, 0xe6, 0x6a, 0x4c, 0x6f, 0xf2, 0xc5, 0x8f, 0x00, 0x00, 0x00, 0xff, 0xff, 0xef,
-	0xef, 0x15, 0x2e, 0xa1, 0x03, 0x00, 0x00,
+	// 821 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x94, 0x55, 0xdd, 0x8e, 0xdb, 0x44,
+	0x14, 0xae, 0x13, 0x3b, 0x3f, 0x27, 0xbb, 0xa9, 0x35, 0xda, 0x22, 0x6b, 0x85, 0x50, 0x98, 0x22,
+	0x48, 0xa1, 0x5a, 0x41, 0x80, 0x2b, 0x2e, 0x90, 0x15, 0x6d, 0x7f, 0x10, 0x5d, 0xa2, 0x89, 0x29,
+	0x97, 0xc8, 0x1d, 0x9f, 0x24, 0xd6, 0xae, 0x3d, 0xde, 0x99, 0xd9, 0x45, 0xed, 0x45, 0xc5, 0x03,
+	0xf0, 0x28, 0xbc, 0x03, 0xd7, 0xbc, 0x15, 0x9a, 0x9f, 0x38, 0x49, 0xb7, 0x5c, 0xf4, 0x2a, 0xf3,
+	0x7d, 0xe7, 0x9c, 0x39, 0x9f, 0xcf, 0xf9, 0x34, 0x01, 0x58, 0x35, 0xbc, 0x39, 0x6b, 0xa4, 0xd0,
+	0x82, 0x84, 0xe6, 0x4c, 0x7b, 0x10, 0xbe, 0x14, 0x65, 0x41, 0xbf, 0x83, 0x70, 0x59, 0xbe, 0x41,
+	0x72, 0x02, 0xd1, 0x1f, 0x65, 0xa1, 0x37, 0x49, 0x30, 0x09, 0xa6, 0xc7, 0xcc, 0x01, 0xf2, 0x11,
+	0xf4, 0x36, 0x58, 0xae, 0x37, 0x3a, 0xe9, 0x58, 0xda, 0x23, 0xfa, 0x3b, 0x0c, 0x19, 0x72, 0x9d,
+	0xd7, 0xeb, 0x2b, 0x24, 0x04, 0xc2, 0x2b, 0x5c, 0x69, 0x5b, 0x19, 0x31, 0x7b, 0x26, 0x31, 0x74,
+	0xb5, 0x68, 0x6c, 0x55, 0xc4, 0xcc, 0xd1, 0x34, 0x90, 0xf6, 0xa6, 0xae, 0xe5, 0x1c, 0x30, 0x0d,
+	0x5e, 0x09, 0xad, 0x45, 0x95, 0x84, 0x96, 0xf6, 0x88, 0xfe, 0x00, 0xc3, 0xf4, 0x46, 0x6f, 0x32,
+	0x71, 0x89, 0xb5, 0x49, 0xca, 0x39, 0x47, 0xa5, 0x6c, 0x8b, 0x21, 0xf3, 0xc8, 0xf0, 0x0a, 0xb9,
+	0x44, 0xa7, 0x6e, 0xc8, 0x3c, 0xa2, 0x7f, 0x05, 0x10, 0x2d, 0x39, 0xd6, 0x48, 0xc6, 0xd0, 0x29,
+	0x0b, 0x5f, 0xd5, 0x29, 0x0b, 0x23, 0x42, 0x95, 0x35, 0x47, 0x5b, 0x10, 0x32, 0x07, 0x48, 0x02,
+	0xfd, 0x06, 0xa5, 0x12, 0xb5, 0xf2, 0xe2, 0xb6, 0x90, 0x7c, 0x0a, 0xd1, 0x4a, 0xe6, 0x15, 0x5a,
+	0x75, 0xa3, 0xd9, 0xe8, 0xcc, 0xce, 0xf1, 0x89, 0xa1, 0x98, 0x8b, 0x90, 0x09, 0x44, 0xab, 0x9c,
+	0xa3, 0x4a, 0xa2, 0x49, 0x77, 0x3a, 0x9a, 0x81, 0x4f, 0xc9, 0x39, 0x32, 0x17, 0xa0, 0x7f, 0x06,
+	0x10, 0xd9, 0x92, 0x3b, 0x72, 0x3e, 0x86, 0xa1, 0x2e, 0x2b, 0x54, 0x3a, 0xaf, 0x1a, 0x2f, 0x69,
+	0x47, 0x90, 0x4f, 0x20, 0x54, 0xe5, 0x1b, 0xb4, 0x9a, 0xda, 0x8b, 0xcd, 0xb2, 0x98, 0xe5, 0xc9,
+	0x23, 0x18, 0x34, 0x25, 0xd7, 0x37, 0x12, 0x55, 0x12, 0xda, 0xe6, 0xc7, 0x2e, 0x67, 0xe1, 0x58,
+	0xd6, 0x86, 0xe9, 0x5b, 0x08, 0x8d, 0xa2, 0x3b, 0x02, 0x1e, 0x42, 0x28, 0x91, 0xbb, 0xf9, 0x8d,
+	0x66, 0xf7, 0x5d, 0x79, 0xbb, 0x59, 0x66, 0x83, 0x66, 0xcc, 0xb7, 0xc8, 0xb5, 0x90, 0x49, 0x77,
+	0xd2, 0x9d, 0x76, 0x98, 0x47, 0x1f, 0xd2, 0xff, 0xef, 0x00, 0xfa, 0x9e, 0x6d, 0x3f, 0x2b, 0xf8,
+	0x9f, 0xcf, 0x3a, 0x85, 0x81, 0xf9, 0x9d, 0x8b, 0x02, 0xbd, 0x7f, 0x5a, 0x4c, 0x1e, 0x43, 0x6f,
+	0x25, 0x64, 0x95, 0x3b, 0x17, 0x8d, 0x67, 0x27, 0x07, 0x0d, 0xcf, 0x9e, 0xd8, 0x18, 0xf3, 0x39,
+	0xc6, 0x98, 0x45, 0xae, 0x73, 0xbb, 0xbc, 0x23, 0x66, 0xcf, 0xf4, 0x21, 0xf4, 0x5c, 0x16, 0xe9,
+	0x43, 0x97, 0xa5, 0xbf, 0xc5, 0xf7, 0xcc, 0x61, 0x71, 0xf1, 0x34, 0x0e, 0xcc, 0xe1, 0xa7, 0xc5,
+	0xd3, 0xb8, 0x43, 0x7f, 0x84, 0x81, 0xf5, 0x0f, 0xc3, 0x6b, 0xe3, 0x64, 0x85, 0xd7, 0x56, 0x6d,
+	0xc8, 0xcc, 0xd1, 0x98, 0x42, 0x99, 0x68, 0xd2, 0xd9, 0x37, 0x85, 0x2b, 0x70, 0x11, 0xfa, 0xb3,
+	0xbf, 0x20, 0xe5, 0x97, 0xef, 0xb9, 0xe0, 0x04, 0x22, 0x94, 0x52, 0x48, 0x6f, 0x5b, 0x07, 0x8c,
+	0x0b, 0xb9, 0xc4, 0xa2, 0xd4, 0xad, 0x0b, 0x3d, 0xa4, 0xff, 0x04, 0x30, 0x9c, 0xe7, 0xd5, 0x5c,
+	0xd4, 0xab, 0x72, 0x6d, 0xf2, 0x6e, 0x51, 0xaa, 0x52, 0xd4, 0xfe, 0xce, 0x2d, 0x34, 0x76, 0xb2,
+	0x9e, 0x64, 0xb9, 0xde, 0x8e, 0x6e, 0x47, 0x90, 0xa9, 0x8f, 0x2e, 0xdf, 0xef, 0xa9, 0x5d, 0x90,
+	0x7c, 0x0e, 0x03, 0xe3, 0x5c, 0x9b, 0x18, 0xde, 0x49, 0x6c, 0x63, 0x64, 0x0a, 0xf7, 0x0b, 0xd4,
+	0xc8, 0x75, 0xb6, 0x91, 0xa8, 0x36, 0xe2, 0xaa, 0x48, 0xa2, 0x49, 0x30, 0xed, 0xb0, 0x77, 0x69,
+	0xfa, 0x16, 0xc0, 0x7e, 0x40, 0x55, 0xe5, 0x75, 0x71, 0xc7, 0x85, 0x8f, 0x20, 0xd4, 0xaf, 0x1b,
+	0x27, 0x79, 0x3c, 0x7b, 0xe0, 0x7a, 0xed, 0xf2, 0xcf, 0xb2, 0xd7, 0x0d, 0x32, 0x9b, 0x42, 0xbf,
+	0x87, 0xd0, 0x20, 0x72, 0x04, 0x83, 0xe5, 0x45, 0xba, 0x58, 0x3e, 0xfb, 0x25, 0x8b, 0xef, 0x11,
+	0x02, 0x63, 0x76, 0x9e, 0xfe, 0x9a, 0x3d, 0x3b, 0xbf, 0xc8, 0x9e, 0xcf, 0xd3, 0xec, 0x3c, 0x0e,
+	0xc8, 0x08, 0xfa, 0xec, 0x7c, 0x99, 0xa5, 0x2c, 0x8b, 0x3b, 0x34, 0x07, 0x98, 0x8b, 0x5a, 0x4b,
+	0x71, 0xf5, 0x42, 0xad, 0xc9, 0x17, 0xd0, 0xe3, 0x76, 0x96, 0x49, 0xb0, 0xef, 0xfb, 0x76, 0xc4,
+	0xcc, 0x87, 0xc9, 0x97, 0xd0, 0xe7, 0x4e, 0x83, 0xdf, 0x75, 0xfc, 0xae, 0x36, 0xb6, 0x4d, 0xa0,
+	0xab, 0xb6, 0x85, 0x59, 0xfa, 0x67, 0x70, 0xec, 0xee, 0x78, 0x79, 0xb0, 0xaa, 0x43, 0xd2, 0x2c,
+	0xcc, 0x97, 0x3f, 0x2f, 0xbc, 0x19, 0x76, 0xc4, 0xce, 0x26, 0xdd, 0x3d, 0x9b, 0xcc, 0xfe, 0x0d,
+	0xe0, 0x81, 0xf5, 0xd6, 0x42, 0x0a, 0xf3, 0x0a, 0x0a, 0xb9, 0x44, 0x79, 0x5b, 0x72, 0x24, 0x5f,
+	0xc1, 0x51, 0x7e, 0xa3, 0x37, 0x58, 0xeb, 0x92, 0x9b, 0x85, 0xfb, 0xcf, 0x6a, 0xdf, 0xd1, 0x53,
+	0xbf, 0x45, 0xf3, 0xee, 0x13, 0x0a, 0x7d, 0x51, 0xbb, 0x47, 0x72, 0xdf, 0xc0, 0x07, 0x39, 0x8f,
+	0xa1, 0x67, 0xed, 0xac, 0xc8, 0x78, 0xdf, 0xe3, 0x78, 0x7d, 0xba, 0x8f, 0x53, 0x7e, 0x39, 0x0d,
+	0xbe, 0x0e, 0xc8, 0x37, 0x66, 0x58, 0x76, 0x00, 0x64, 0x3b, 0xa6, 0x76, 0x1e, 0xa7, 0x87, 0xcc,
+	0x0b, 0xb5, 0x36, 0x25, 0xaf, 0x7a, 0xf6, 0x1f, 0xe9, 0xdb, 0xff, 0x06, 0x00, 0x02, 0x0c, 0x41,
+	0x2b, 0x9f, 0x06, 0x00, 0x00,
 }
@@ -2,6 +2,8 @@ package model
 
 import (
 	"fmt"
+	"strconv"
+	"time"
 
 	"github.com/pixty/console/common"
 )
@@ -11,6 +13,8 @@ type (
 	Organization struct {
 		Id   int64
 		Name string
+		// Privacy policy, one of ORG_PRIVACY_ constants below
+		Privacy string
 	}
 
 	// FieldInfo - describes a field of profile metadata
@@ -45,6 +49,65 @@ type (
 		Name      string // display name (unique per org)
 		OrgId     int64
 		SecretKey string // this is not the key actually, but its hash
+		// Zones of interest, faces out of them are not stored
+		Zones CamZones //composite, dao has transformations
+		// Ingest settings, nil means all scenes and faces are accepted
+		Ingest *CamIngest //composite, dao has transformations
+		// Frame processor settings pushed to the camera, nil means defaults
+		Config *CamConfig //composite, dao has transformations
+	}
+
+	// A camera zone is a polygon in the frame coordinates. Faces with centers
+	// out of the include zones, or in an exclude zone, are ignored. No include
+	// zones means the whole frame.
+	CamZone struct {
+		Exclude bool    `json:"exclude,omitempty"`
+		Points  []Point `json:"points"`
+	}
+
+	CamZones []*CamZone
+
+	// Camera ingest settings. Scenes captured out of the schedule are
+	// ignored as well as faces smaller than MinFaceSize.
+	CamIngest struct {
+		// faces with the rectangle width or height less than the value
+		// are ignored, 0 means no limit
+		MinFaceSize int `json:"minFaceSize,omitempty"`
+		// the IANA time zone name like "America/Los_Angeles", the schedule
+		// is defined in. UTC if empty
+		TimeZone string `json:"timeZone,omitempty"`
+		// active hours during the week, empty schedule means always active
+		Schedule []*CamActiveHours `json:"schedule,omitempty"`
+		loc      *time.Location
+	}
+
+	// Active hours on the week days. From and To are "HH:MM", To could be
+	// "24:00". If To is earlier than From, the interval goes over midnight:
+	// it starts on the Days and ends on the next days.
+	CamActiveHours struct {
+		// 0 is Sunday, 6 is Saturday
+		Days []int  `json:"days"`
+		From string `json:"from"`
+		To   string `json:"to"`
+		// minutes of the day
+		from int
+		to   int
+	}
+
+	// Frame processor settings managed by the console. Zero values mean the
+	// frame processor defaults. The version is increased on every change,
+	// so the frame processor reports which settings it applied.
+	CamConfig struct {
+		Version uint64 `json:"version"`
+		// frames per second to process
+		FrameRate int `json:"frameRate,omitempty"`
+		// the max size of the frame and face pictures sent in scenes
+		FrameWidth  int `json:"frameWidth,omitempty"`
+		FrameHeight int `json:"frameHeight,omitempty"`
+		FaceWidth   int `json:"faceWidth,omitempty"`
+		FaceHeight  int `json:"faceHeight,omitempty"`
+		// the face detection confidence threshold in 0..1
+		DetectThreshold float32 `json:"detectThreshold,omitempty"`
 	}
 
 	// A person DO
@@ -86,7 +149,9 @@ type (
 		ImageId     string
 		Rect        Rectangle //composite, dao has transformations
 		FaceImageId string
-		V128D       common.V128D //composite, dao has transformations
+		// The face image quality in [0..1], 0 means unknown
+		Quality float32
+		V128D   common.V128D //composite, dao has transformations
 	}
 
 	// An organization profile's information
@@ -133,6 +198,8 @@ type (
 		GetMainTx() (MainTx, error)
 		// Returns an TX object for accessing to Pratitioned DB
 		GetPartitionTx(partId string) (PartTx, error)
+		// Checks whether the DB is reachable
+		Ping() error
 	}
 
 	// The Tx object allows to control general DB operations. It also supports
@@ -170,6 +237,7 @@ type (
 		InsertOrg(org *Organization) (int64, error)
 		GetOrgById(orgId int64) (*Organization, error)
 		FindOrgs(q *OrgQuery) ([]*Organization, error)
+		UpdateOrgPrivacy(orgId int64, privacy string) error
 
 		// users
 		InsertUser(user *User) error
@@ -187,6 +255,10 @@ type (
 		// ==== Cams ====
 		InsertCamera(cam *Camera) (int64, error)
 		GetCameraById(camId int64) (*Camera, error)
+		// Reads the camera and locks its record till the transaction end, so
+		// concurrent read-modify-write updates don't overwrite each other.
+		// Must be called in a transaction.
+		GetCameraByIdForUpdate(camId int64) (*Camera, error)
 		UpdateCamera(cam *Camera) error
 		DeleteCamera(camId int64) error
 		FindCameras(q *CameraQuery) ([]*Camera, error)
@@ -202,6 +274,11 @@ type (
 
 		// ==== Pictures ====
 		FindZeroRefPics(limit int) ([]*Picture, error)
+		// returns pictures ordered by id, which ids are greater than afterId
+		FindPics(afterId string, limit int) ([]*Picture, error)
+		FindPicsByIds(ids []string) ([]*Picture, error)
+		// returns pictures which ids start with the prefix
+		FindPicsByPrefix(prefix string) ([]*Picture, error)
 		DeletePics(pics []*Picture) error
 
 		// ==== Persons ====
@@ -260,6 +337,8 @@ type (
 	FacesQuery struct {
 		// Request faces for the list of persons
 		PersonIds []string
+		// Request faces found on the frame image, if not empty
+		ImageId string
 		// Indicates to read short version (no V128D vectors) of faces
 		Short bool
 		Limit int
@@ -311,10 +390,149 @@ const (
 	PQO_LAST_SEEN_DESC = 1
 	PQO_CREATED_AT_ASC = 2
 	PQO_ID_ASC         = 3
+
+	// Organization privacy policies
+	ORG_PRIVACY_NONE = ""
+	// faces of persons without profiles are blurred on frames, their face
+	// images are not shown
+	ORG_PRIVACY_BLUR = "blur"
+
+	cCamMaxFrameRate = 60
 )
 
 func (c *Camera) String() string {
-	return fmt.Sprintf("{Id=%s, OrgId=%d, SecretKey=%s}", c.Id, c.OrgId, c.SecretKey)
+	return fmt.Sprintf("{Id=%d, OrgId=%d, SecretKey=%s}", c.Id, c.OrgId, c.SecretKey)
+}
+
+// Returns true if the point is inside the zone polygon
+func (cz *CamZone) Contains(p Point) bool {
+	res := false
+	n := len(cz.Points)
+	for i, j := 0, n-1; i < n; j, i = i, i+1 {
+		pi, pj := cz.Points[i], cz.Points[j]
+		if (pi.Y > p.Y) != (pj.Y > p.Y) && p.X < (pj.X-pi.X)*(p.Y-pi.Y)/(pj.Y-pi.Y)+pi.X {
+			res = !res
+		}
+	}
+	return res
+}
+
+// Returns true if the rectangle center is in the zones of interest
+func (czs CamZones) Accept(r Rectangle) bool {
+	p := Point{X: (r.LeftTop.X + r.RightBottom.X) / 2, Y: (r.LeftTop.Y + r.RightBottom.Y) / 2}
+	included, hasIncl := false, false
+	for _, z := range czs {
+		if z.Exclude {
+			if z.Contains(p) {
+				return false
+			}
+			continue
+		}
+		hasIncl = true
+		included = included || z.Contains(p)
+	}
+	return included || !hasIncl
+}
+
+// Checks the settings and prepares them for IsActive calls. Returns
+// ERR_INVALID_VAL if the settings are wrong.
+func (ci *CamIngest) Prepare() error {
+	if ci.MinFaceSize < 0 {
+		return common.NewError(common.ERR_INVALID_VAL, "minFaceSize cannot be negative.")
+	}
+	loc, err := time.LoadLocation(ci.TimeZone)
+	if err != nil {
+		return common.NewError(common.ERR_INVALID_VAL, "Unknown time zone \""+ci.TimeZone+"\"")
+	}
+	ci.loc = loc
+
+	for i, ah := range ci.Schedule {
+		if ah == nil || len(ah.Days) == 0 {
+			return common.NewError(common.ERR_INVALID_VAL, "Active hours #"+strconv.Itoa(i)+" should have week days.")
+		}
+		for _, d := range ah.Days {
+			if d < 0 || d > 6 {
+				return common.NewError(common.ERR_INVALID_VAL, "Active hours #"+strconv.Itoa(i)+" has wrong week day "+strconv.Itoa(d))
+			}
+		}
+		ah.from, err = parseDayMinutes(ah.From)
+		if err == nil {
+			ah.to, err = parseDayMinutes(ah.To)
+		}
+		if err != nil || ah.from == ah.to || ah.from == 24*60 {
+			return common.NewError(common.ERR_INVALID_VAL, "Active hours #"+strconv.Itoa(i)+" should be from \"HH:MM\" to another \"HH:MM\".")
+		}
+	}
+	return nil
+}
+
+// Returns whether the time is in the schedule. Prepare() must be called
+// first. Nil settings accept any time.
+func (ci *CamIngest) IsActive(t time.Time) bool {
+	if ci == nil || len(ci.Schedule) == 0 || ci.loc == nil {
+		return true
+	}
+	lt := t.In(ci.loc)
+	wd := int(lt.Weekday())
+	m := lt.Hour()*60 + lt.Minute()
+	for _, ah := range ci.Schedule {
+		if ah.contains(wd, m) {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns whether the minute of the week day is in the active hours
+func (ah *CamActiveHours) contains(wd, m int) bool {
+	if ah.from < ah.to {
+		return m >= ah.from && m < ah.to && ah.hasDay(wd)
+	}
+	// over midnight, the tail belongs to the previous day interval
+	return (m >= ah.from && ah.hasDay(wd)) || (m < ah.to && ah.hasDay((wd+6)%7))
+}
+
+func (ah *CamActiveHours) hasDay(wd int) bool {
+	for _, d := range ah.Days {
+		if d == wd {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns whether the face rectangle is big enough, nil settings accept any
+func (ci *CamIngest) IsBigEnough(r Rectangle) bool {
+	if ci == nil {
+		return true
+	}
+	return r.RightBottom.X-r.LeftTop.X >= ci.MinFaceSize && r.RightBottom.Y-r.LeftTop.Y >= ci.MinFaceSize
+}
+
+// Checks the config values, returns ERR_INVALID_VAL if they are wrong
+func (cc *CamConfig) Check() error {
+	if cc.FrameRate < 0 || cc.FrameRate > cCamMaxFrameRate {
+		return common.NewError(common.ERR_INVALID_VAL, "frameRate should be in 0.."+strconv.Itoa(cCamMaxFrameRate))
+	}
+	if cc.FrameWidth < 0 || cc.FrameHeight < 0 || cc.FaceWidth < 0 || cc.FaceHeight < 0 {
+		return common.NewError(common.ERR_INVALID_VAL, "Picture sizes cannot be negative.")
+	}
+	if cc.DetectThreshold < 0 || cc.DetectThreshold > 1 {
+		return common.NewError(common.ERR_INVALID_VAL, "detectThreshold should be in 0..1")
+	}
+	return nil
+}
+
+// parses "HH:MM" to minutes of the day
+func parseDayMinutes(hm string) (int, error) {
+	t, err := time.Parse("15:04", hm)
+	if err != nil {
+		if hm == "24:00" {
+			return 24 * 60, nil
+		}
+		return 0, err
+	}
+	return t.Hour()*60 + t.Minute(), nil
 }
 
 func (q *PersonsQuery) String() string {
@@ -322,7 +540,7 @@ func (q *PersonsQuery) String() string {
 }
 
 func (q *FacesQuery) String() string {
-	return fmt.Sprintf("{PersonsIds=%v, Limit=%d}", q.PersonIds, q.Limit)
+	return fmt.Sprintf("{PersonsIds=%v, ImageId=%s, Limit=%d}", q.PersonIds, q.ImageId, q.Limit)
 }
 
 func (q *ProfileQuery) String() string {
@@ -0,0 +1,153 @@
+package model
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCamZoneContains(t *testing.T) {
+	// the square 10..20 with the notch from the top 14..16 down to 18
+	cz := &CamZone{Points: []Point{{10, 10}, {14, 10}, {15, 18}, {16, 10}, {20, 10}, {20, 20}, {10, 20}}}
+	tests := []struct {
+		p   Point
+		res bool
+	}{
+		{Point{15, 15}, false},
+		{Point{15, 19}, true},
+		{Point{12, 12}, true},
+		{Point{18, 12}, true},
+		{Point{5, 15}, false},
+		{Point{25, 15}, false},
+		{Point{15, 5}, false},
+		{Point{15, 25}, false},
+	}
+	for _, tst := range tests {
+		if cz.Contains(tst.p) != tst.res {
+			t.Fatal("Contains(", tst.p, ") should be ", tst.res)
+		}
+	}
+
+	if (&CamZone{}).Contains(Point{0, 0}) {
+		t.Fatal("Empty zone contains nothing")
+	}
+}
+
+func TestCamZonesAccept(t *testing.T) {
+	incl := &CamZone{Points: []Point{{0, 0}, {100, 0}, {100, 100}, {0, 100}}}
+	excl := &CamZone{Points: []Point{{40, 40}, {60, 40}, {60, 60}, {40, 60}}, Exclude: true}
+	tests := []struct {
+		zones CamZones
+		r     Rectangle
+		res   bool
+	}{
+		{nil, Rectangle{Point{200, 200}, Point{210, 210}}, true},
+		{CamZones{incl}, Rectangle{Point{10, 10}, Point{20, 20}}, true},
+		{CamZones{incl}, Rectangle{Point{200, 200}, Point{210, 210}}, false},
+		{CamZones{excl}, Rectangle{Point{200, 200}, Point{210, 210}}, true},
+		{CamZones{excl}, Rectangle{Point{45, 45}, Point{55, 55}}, false},
+		{CamZones{incl, excl}, Rectangle{Point{45, 45}, Point{55, 55}}, false},
+		{CamZones{incl, excl}, Rectangle{Point{10, 10}, Point{20, 20}}, true},
+	}
+	for i, tst := range tests {
+		if tst.zones.Accept(tst.r) != tst.res {
+			t.Fatal("Test #", i, ": Accept(", tst.r, ") should be ", tst.res)
+		}
+	}
+}
+
+func TestParseDayMinutes(t *testing.T) {
+	tests := []struct {
+		hm  string
+		m   int
+		err bool
+	}{
+		{"00:00", 0, false},
+		{"08:30", 8*60 + 30, false},
+		{"23:59", 23*60 + 59, false},
+		{"24:00", 24 * 60, false},
+		{"24:01", 0, true},
+		{"8:30", 8*60 + 30, false},
+		{"25:00", 0, true},
+		{"08-30", 0, true},
+		{"", 0, true},
+	}
+	for _, tst := range tests {
+		m, err := parseDayMinutes(tst.hm)
+		if (err != nil) != tst.err || (err == nil && m != tst.m) {
+			t.Fatal("parseDayMinutes(\"", tst.hm, "\") returned ", m, ", err=", err)
+		}
+	}
+}
+
+func TestCamIngestPrepare(t *testing.T) {
+	tests := []struct {
+		ci  *CamIngest
+		err bool
+	}{
+		{&CamIngest{}, false},
+		{&CamIngest{MinFaceSize: -1}, true},
+		{&CamIngest{TimeZone: "Unknown/Zone"}, true},
+		{&CamIngest{Schedule: []*CamActiveHours{{Days: []int{1}, From: "08:00", To: "20:00"}}}, false},
+		{&CamIngest{Schedule: []*CamActiveHours{{Days: []int{1}, From: "20:00", To: "06:00"}}}, false},
+		{&CamIngest{Schedule: []*CamActiveHours{{Days: []int{1}, From: "08:00", To: "08:00"}}}, true},
+		{&CamIngest{Schedule: []*CamActiveHours{{Days: []int{1}, From: "24:00", To: "08:00"}}}, true},
+		{&CamIngest{Schedule: []*CamActiveHours{{Days: []int{7}, From: "08:00", To: "20:00"}}}, true},
+		{&CamIngest{Schedule: []*CamActiveHours{{From: "08:00", To: "20:00"}}}, true},
+		{&CamIngest{Schedule: []*CamActiveHours{nil}}, true},
+	}
+	for i, tst := range tests {
+		if err := tst.ci.Prepare(); (err != nil) != tst.err {
+			t.Fatal("Test #", i, ": unexpected Prepare() result, err=", err)
+		}
+	}
+}
+
+func TestCamIngestIsActive(t *testing.T) {
+	ci := &CamIngest{TimeZone: "Europe/Berlin", Schedule: []*CamActiveHours{
+		// Mon-Fri, the day hours
+		{Days: []int{1, 2, 3, 4, 5}, From: "08:00", To: "20:00"},
+		// Saturday night till Sunday morning
+		{Days: []int{6}, From: "22:00", To: "04:00"},
+	}}
+	if err := ci.Prepare(); err != nil {
+		t.Fatal("Prepare() err=", err)
+	}
+
+	loc, _ := time.LoadLocation("Europe/Berlin")
+	// 2017-07-03 is Monday
+	at := func(day, h, m int) time.Time {
+		return time.Date(2017, 7, 3+day, h, m, 0, 0, loc)
+	}
+	tests := []struct {
+		t   time.Time
+		res bool
+	}{
+		{at(0, 7, 59), false},
+		{at(0, 8, 0), true},
+		{at(2, 19, 59), true},
+		{at(2, 20, 0), false},
+		{at(5, 21, 59), false},
+		{at(5, 22, 0), true},
+		{at(5, 23, 59), true},
+		{at(6, 0, 0), true},
+		{at(6, 3, 59), true},
+		{at(6, 4, 0), false},
+		// Sunday night doesn't go to Monday
+		{at(6, 23, 0), false},
+		{at(7, 1, 0), false},
+		// the time zone is applied
+		{at(0, 8, 30).UTC(), true},
+		{time.Date(2017, 7, 3, 7, 30, 0, 0, time.UTC), true},
+		{time.Date(2017, 7, 3, 18, 30, 0, 0, time.UTC), false},
+	}
+	for i, tst := range tests {
+		if ci.IsActive(tst.t) != tst.res {
+			t.Fatal("Test #", i, ": IsActive(", tst.t, ") should be ", tst.res)
+		}
+	}
+
+	var nilCi *CamIngest
+	if !nilCi.IsActive(time.Now()) || !(&CamIngest{}).IsActive(time.Now()) {
+		t.Fatal("No schedule means always active")
+	}
+}
@@ -3,6 +3,7 @@ package model
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
 	"io/ioutil"
 	"math"
 	"strconv"
@@ -99,6 +100,14 @@ func (mp *MysqlPersister) GetPartitionTx(partId string) (PartTx, error) {
 	return &msql_part_tx{msql_tx: tx}, nil
 }
 
+func (mp *MysqlPersister) Ping() error {
+	db, err := mp.mainConn.getDb()
+	if err != nil {
+		return err
+	}
+	return db.Ping()
+}
+
 // -------------------------------- Misc -------------------------------------
 func (mp *MysqlPersister) makeTx(mc *msql_connection) (*msql_tx, error) {
 	db, err := mc.getDb()
@@ -237,7 +246,7 @@ func (mtx *msql_tx) ExecScript(sqlScript string) error {
 
 // ========================= msql_main_persister =============================
 func (mmp *msql_main_tx) InsertOrg(org *Organization) (int64, error) {
-	res, err := mmp.executor().Exec("INSERT INTO organization(name) VALUES (?)", org.Name)
+	res, err := mmp.executor().Exec("INSERT INTO organization(name, privacy) VALUES (?,?)", org.Name, org.Privacy)
 	if err != nil {
 		mmp.logger.Warn("InsertOrg(): Could not insert new organization ", org, ", got the err=", err)
 		return -1, err
@@ -247,7 +256,7 @@ func (mmp *msql_main_tx) InsertOrg(org *Organization) (int64, error) {
 }
 
 func (mmp *msql_main_tx) GetOrgById(orgId int64) (*Organization, error) {
-	rows, err := mmp.executor().Query("SELECT name FROM organization WHERE id=?", orgId)
+	rows, err := mmp.executor().Query("SELECT name, privacy FROM organization WHERE id=?", orgId)
 	if err != nil {
 		mmp.logger.Warn("GetOrgById(): Could not get organization by orgId=", orgId, ", got the err=", err)
 		return nil, err
@@ -256,7 +265,7 @@ func (mmp *msql_main_tx) GetOrgById(orgId int64) (*Organization, error) {
 	if rows.Next() {
 		org := new(Organization)
 		org.Id = orgId
-		rows.Scan(&org.Name)
+		rows.Scan(&org.Name, &org.Privacy)
 		return org, nil
 	}
 
@@ -268,7 +277,7 @@ func (mmp *msql_main_tx) FindOrgs(q *OrgQuery) ([]*Organization, error) {
 	if q.OrgIds == nil || len(q.OrgIds) == 0 {
 		return []*Organization{}, nil
 	}
-	query := "SELECT id, name FROM organization WHERE id IN ("
+	query := "SELECT id, name, privacy FROM organization WHERE id IN ("
 	params := []interface{}{}
 	for i, oid := range q.OrgIds {
 		if i > 0 {
@@ -290,13 +299,21 @@ func (mmp *msql_main_tx) FindOrgs(q *OrgQuery) ([]*Organization, error) {
 	res := make([]*Organization, 0, 1)
 	for rows.Next() {
 		org := new(Organization)
-		rows.Scan(&org.Id, &org.Name)
+		rows.Scan(&org.Id, &org.Name, &org.Privacy)
 		res = append(res, org)
 	}
 
 	return res, nil
 }
 
+func (mmp *msql_main_tx) UpdateOrgPrivacy(orgId int64, privacy string) error {
+	_, err := mmp.executor().Exec("UPDATE organization SET privacy=? WHERE id=?", privacy, orgId)
+	if err != nil {
+		mmp.logger.Warn("UpdateOrgPrivacy(): Could not update organization orgId=", orgId, ", got the err=", err)
+	}
+	return err
+}
+
 func (mmp *msql_main_tx) InsertUser(user *User) error {
 	_, err := mmp.executor().Exec("INSERT INTO user(login, email, salt, hash) VALUES (?,?,?,?)",
 		user.Login, user.Email, user.Salt, user.Hash)
@@ -412,8 +429,8 @@ func (mmp *msql_main_tx) FindUserRoles(q *UserRoleQuery) ([]*UserRole, error) {
 // ========================= msql_part_persister =============================
 
 func (mpp *msql_part_tx) InsertCamera(cam *Camera) (int64, error) {
-	res, err := mpp.executor().Exec("INSERT INTO camera(name, org_id, secret_key) VALUES (?,?,?)",
-		cam.Name, cam.OrgId, cam.SecretKey)
+	res, err := mpp.executor().Exec("INSERT INTO camera(name, org_id, secret_key, zones, ingest, config) VALUES (?,?,?,?,?,?)",
+		cam.Name, cam.OrgId, cam.SecretKey, zonesToJson(cam.Zones), ingestToJson(cam.Ingest), configToJson(cam.Config))
 	if err != nil {
 		mpp.logger.Warn("InsertCamera(): Could not insert new camera ", cam, ", got the err=", err)
 		return -1, err
@@ -423,24 +440,37 @@ func (mpp *msql_part_tx) InsertCamera(cam *Camera) (int64, error) {
 
 func (mpp *msql_part_tx) GetCameraById(camId int64) (*Camera, error) {
 	mpp.logger.Debug("GetCameraById(): Getting camera by id=", camId)
-	rows, err := mpp.executor().Query("SELECT name, org_id, secret_key FROM camera WHERE id=?", camId)
+	return mpp.getCamera("SELECT name, org_id, secret_key, zones, ingest, config FROM camera WHERE id=?", camId)
+}
+
+func (mpp *msql_part_tx) GetCameraByIdForUpdate(camId int64) (*Camera, error) {
+	mpp.logger.Debug("GetCameraByIdForUpdate(): Getting camera by id=", camId)
+	return mpp.getCamera("SELECT name, org_id, secret_key, zones, ingest, config FROM camera WHERE id=? FOR UPDATE", camId)
+}
+
+func (mpp *msql_part_tx) getCamera(q string, camId int64) (*Camera, error) {
+	rows, err := mpp.executor().Query(q, camId)
 	if err != nil {
-		mpp.logger.Warn("GetCameraById(): Getting camera by id=", camId, ", got the err=", err)
+		mpp.logger.Warn("getCamera(): Getting camera by id=", camId, ", got the err=", err)
 		return nil, err
 	}
 	defer rows.Close()
 	if rows.Next() {
 		c := new(Camera)
-		rows.Scan(&c.Name, &c.OrgId, &c.SecretKey)
+		var zones, ingest, config sql.NullString
+		rows.Scan(&c.Name, &c.OrgId, &c.SecretKey, &zones, &ingest, &config)
 		c.Id = camId
+		c.Zones = mpp.jsonToZones(zones)
+		c.Ingest = mpp.jsonToIngest(ingest)
+		c.Config = mpp.jsonToConfig(config)
 		return c, nil
 	}
 	return nil, common.NewError(common.ERR_NOT_FOUND, "Could not find camera with id="+strconv.FormatInt(camId, 10))
 }
 
 func (mpp *msql_part_tx) UpdateCamera(cam *Camera) error {
-	_, err := mpp.executor().Exec("UPDATE camera SET secret_key=?, name=? WHERE id=?",
-		cam.SecretKey, cam.Name, cam.Id)
+	_, err := mpp.executor().Exec("UPDATE camera SET secret_key=?, name=?, zones=?, ingest=?, config=? WHERE id=?",
+		cam.SecretKey, cam.Name, zonesToJson(cam.Zones), ingestToJson(cam.Ingest), configToJson(cam.Config), cam.Id)
 	if err != nil {
 		mpp.logger.Warn("UpdateCamera(): Could not update camera ", cam, ", got the err=", err)
 		return err
@@ -458,7 +488,7 @@ func (mpp *msql_part_tx) DeleteCamera(camId int64) error {
 }
 
 func (mpp *msql_part_tx) FindCameras(q *CameraQuery) ([]*Camera, error) {
-	rows, err := mpp.executor().Query("SELECT id, name, org_id, secret_key FROM camera WHERE org_id=?", q.OrgId)
+	rows, err := mpp.executor().Query("SELECT id, name, org_id, secret_key, zones, ingest, config FROM camera WHERE org_id=?", q.OrgId)
 	if err != nil {
 		mpp.logger.Warn("FindCameras(): Getting cameras by query=", q, ", got the err=", err)
 		return nil, err
@@ -467,15 +497,84 @@ func (mpp *msql_part_tx) FindCameras(q *CameraQuery) ([]*Camera, error) {
 	res := []*Camera{}
 	for rows.Next() {
 		c := new(Camera)
-		rows.Scan(&c.Id, &c.Name, &c.OrgId, &c.SecretKey)
+		var zones, ingest, config sql.NullString
+		rows.Scan(&c.Id, &c.Name, &c.OrgId, &c.SecretKey, &zones, &ingest, &config)
+		c.Zones = mpp.jsonToZones(zones)
+		c.Ingest = mpp.jsonToIngest(ingest)
+		c.Config = mpp.jsonToConfig(config)
 		res = append(res, c)
 	}
 	return res, nil
 }
 
+// the camera zones are kept as JSON, NULL means no zones
+func zonesToJson(zones CamZones) interface{} {
+	if len(zones) == 0 {
+		return nil
+	}
+	data, _ := json.Marshal(zones)
+	return string(data)
+}
+
+func (mpp *msql_part_tx) jsonToZones(zones sql.NullString) CamZones {
+	if !zones.Valid || zones.String == "" {
+		return nil
+	}
+	var res CamZones
+	if err := json.Unmarshal([]byte(zones.String), &res); err != nil {
+		mpp.logger.Error("Could not unmarshal camera zones ", zones.String, ", ignoring them. err=", err)
+		return nil
+	}
+	return res
+}
+
+func ingestToJson(ci *CamIngest) interface{} {
+	if ci == nil {
+		return nil
+	}
+	data, _ := json.Marshal(ci)
+	return string(data)
+}
+
+func (mpp *msql_part_tx) jsonToIngest(ingest sql.NullString) *CamIngest {
+	if !ingest.Valid || ingest.String == "" {
+		return nil
+	}
+	res := new(CamIngest)
+	err := json.Unmarshal([]byte(ingest.String), res)
+	if err == nil {
+		err = res.Prepare()
+	}
+	if err != nil {
+		mpp.logger.Error("Could not read camera ingest settings ", ingest.String, ", ignoring them. err=", err)
+		return nil
+	}
+	return res
+}
+
+func configToJson(cc *CamConfig) interface{} {
+	if cc == nil {
+		return nil
+	}
+	data, _ := json.Marshal(cc)
+	return string(data)
+}
+
+func (mpp *msql_part_tx) jsonToConfig(config sql.NullString) *CamConfig {
+	if !config.Valid || config.String == "" {
+		return nil
+	}
+	res := new(CamConfig)
+	if err := json.Unmarshal([]byte(config.String), res); err != nil {
+		mpp.logger.Error("Could not unmarshal camera config ", config.String, ", ignoring it. err=", err)
+		return nil
+	}
+	return res
+}
+
 func (mpp *msql_part_tx) InsertFace(f *Face) (int64, error) {
-	res, err := mpp.executor().Exec("INSERT INTO face(scene_id, person_id, captured_at, image_id, img_top, img_left, img_bottom, img_right, face_image_id, v128d) VALUES (?,?,?,?,?,?,?,?,?,?)",
-		f.SceneId, f.PersonId, f.CapturedAt, f.ImageId, f.Rect.LeftTop.Y, f.Rect.LeftTop.X, f.Rect.RightBottom.Y, f.Rect.RightBottom.X, f.FaceImageId, f.V128D.ToByteSlice())
+	res, err := mpp.executor().Exec("INSERT INTO face(scene_id, person_id, captured_at, image_id, img_top, img_left, img_bottom, img_right, face_image_id, quality, v128d) VALUES (?,?,?,?,?,?,?,?,?,?,?)",
+		f.SceneId, f.PersonId, f.CapturedAt, f.ImageId, f.Rect.LeftTop.Y, f.Rect.LeftTop.X, f.Rect.RightBottom.Y, f.Rect.RightBottom.X, f.FaceImageId, f.Quality, f.V128D.ToByteSlice())
 	if err != nil {
 		mpp.logger.Warn("InsertFace(): Could not insert new face ", f, ", got the err=", err)
 		return -1, err
@@ -487,14 +586,14 @@ func (mpp *msql_part_tx) InsertFace(f *Face) (int64, error) {
 func (mpp *msql_part_tx) InsertFaces(faces []*Face) error {
 	mpp.logger.Debug("InsertFaces() ", len(faces), " faces to DB: ", faces)
 	if len(faces) > 0 {
-		q := "INSERT INTO face(scene_id, person_id, captured_at, image_id, img_top, img_left, img_bottom, img_right, face_image_id, v128d) VALUES "
+		q := "INSERT INTO face(scene_id, person_id, captured_at, image_id, img_top, img_left, img_bottom, img_right, face_image_id, quality, v128d) VALUES "
 		vals := []interface{}{}
 		for i, f := range faces {
 			if i > 0 {
 				q = q + ", "
 			}
-			q = q + "(?,?,?,?,?,?,?,?,?,?)"
-			vals = append(vals, f.SceneId, f.PersonId, f.CapturedAt, f.ImageId, f.Rect.LeftTop.Y, f.Rect.LeftTop.X, f.Rect.RightBottom.Y, f.Rect.RightBottom.X, f.FaceImageId, f.V128D.ToByteSlice())
+			q = q + "(?,?,?,?,?,?,?,?,?,?,?)"
+			vals = append(vals, f.SceneId, f.PersonId, f.CapturedAt, f.ImageId, f.Rect.LeftTop.Y, f.Rect.LeftTop.X, f.Rect.RightBottom.Y, f.Rect.RightBottom.X, f.FaceImageId, f.Quality, f.V128D.ToByteSlice())
 		}
 
 		_, err := mpp.executor().Exec(q, vals...)
@@ -507,7 +606,7 @@ func (mpp *msql_part_tx) InsertFaces(faces []*Face) error {
 }
 
 func (mpp *msql_part_tx) GetFaceById(fId int64) (*Face, error) {
-	rows, err := mpp.executor().Query("SELECT scene_id, person_id, captured_at, image_id, img_top, img_left, img_bottom, img_right, face_image_id, v128d FROM face WHERE id=?", fId)
+	rows, err := mpp.executor().Query("SELECT scene_id, person_id, captured_at, image_id, img_top, img_left, img_bottom, img_right, face_image_id, quality, v128d FROM face WHERE id=?", fId)
 	if err != nil {
 		mpp.logger.Warn("GetFaceById(): could read face by id=", fId, ", err=", err)
 		return nil, err
@@ -519,7 +618,7 @@ func (mpp *msql_part_tx) GetFaceById(fId int64) (*Face, error) {
 		f.Id = fId
 		f.V128D = common.NewV128D()
 		vec := make([]byte, common.V128D_SIZE)
-		err := rows.Scan(&f.SceneId, &f.PersonId, &f.CapturedAt, &f.ImageId, &f.Rect.LeftTop.Y, &f.Rect.LeftTop.X, &f.Rect.RightBottom.Y, &f.Rect.RightBottom.X, &f.FaceImageId, &vec)
+		err := rows.Scan(&f.SceneId, &f.PersonId, &f.CapturedAt, &f.ImageId, &f.Rect.LeftTop.Y, &f.Rect.LeftTop.X, &f.Rect.RightBottom.Y, &f.Rect.RightBottom.X, &f.FaceImageId, &f.Quality, &vec)
 		if err != nil {
 			mpp.logger.Warn("GetFaceById(): could not scan result err=", err)
 			return nil, err
@@ -534,9 +633,9 @@ func (mpp *msql_part_tx) FindFaces(fQuery *FacesQuery) ([]*Face, error) {
 	mpp.logger.Debug("FindFaces: Requesting faces by ", fQuery)
 	var q string
 	if fQuery.Short {
-		q = "SELECT id, scene_id, person_id, captured_at, image_id, img_top, img_left, img_bottom, img_right, face_image_id FROM face "
+		q = "SELECT id, scene_id, person_id, captured_at, image_id, img_top, img_left, img_bottom, img_right, face_image_id, quality FROM face "
 	} else {
-		q = "SELECT id, scene_id, person_id, captured_at, image_id, img_top, img_left, img_bottom, img_right, face_image_id, v128d FROM face "
+		q = "SELECT id, scene_id, person_id, captured_at, image_id, img_top, img_left, img_bottom, img_right, face_image_id, quality, v128d FROM face "
 	}
 
 	whereParams := []interface{}{}
@@ -557,6 +656,15 @@ func (mpp *msql_part_tx) FindFaces(fQuery *FacesQuery) ([]*Face, error) {
 		q += ")"
 	}
 
+	if fQuery.ImageId != "" {
+		if len(whereParams) > 0 {
+			q += " AND image_id=?"
+		} else {
+			q += "WHERE image_id=?"
+		}
+		whereParams = append(whereParams, fQuery.ImageId)
+	}
+
 	q = q + " ORDER BY captured_at DESC"
 	if fQuery.Limit > 0 {
 		q = q + " LIMIT " + strconv.Itoa(fQuery.Limit)
@@ -574,7 +682,7 @@ func (mpp *msql_part_tx) FindFaces(fQuery *FacesQuery) ([]*Face, error) {
 	for rows.Next() {
 		f := new(Face)
 		if fQuery.Short {
-			err := rows.Scan(&f.Id, &f.SceneId, &f.PersonId, &f.CapturedAt, &f.ImageId, &f.Rect.LeftTop.Y, &f.Rect.LeftTop.X, &f.Rect.RightBottom.Y, &f.Rect.RightBottom.X, &f.FaceImageId)
+			err := rows.Scan(&f.Id, &f.SceneId, &f.PersonId, &f.CapturedAt, &f.ImageId, &f.Rect.LeftTop.Y, &f.Rect.LeftTop.X, &f.Rect.RightBottom.Y, &f.Rect.RightBottom.X, &f.FaceImageId, &f.Quality)
 			if err != nil {
 				mpp.logger.Warn("FindFaces(): could not scan short result err=", err)
 				return nil, err
@@ -582,7 +690,7 @@ func (mpp *msql_part_tx) FindFaces(fQuery *FacesQuery) ([]*Face, error) {
 		} else {
 			f.V128D = common.NewV128D()
 			vec := make([]byte, common.V128D_SIZE)
-			err := rows.Scan(&f.Id, &f.SceneId, &f.PersonId, &f.CapturedAt, &f.ImageId, &f.Rect.LeftTop.Y, &f.Rect.LeftTop.X, &f.Rect.RightBottom.Y, &f.Rect.RightBottom.X, &f.FaceImageId, &vec)
+			err := rows.Scan(&f.Id, &f.SceneId, &f.PersonId, &f.CapturedAt, &f.ImageId, &f.Rect.LeftTop.Y, &f.Rect.LeftTop.X, &f.Rect.RightBottom.Y, &f.Rect.RightBottom.X, &f.FaceImageId, &f.Quality, &vec)
 			if err != nil {
 				mpp.logger.Warn("FindFaces(): could not scan full result err=", err)
 				return nil, err
@@ -636,6 +744,68 @@ func (mpp *msql_part_tx) FindZeroRefPics(limit int) ([]*Picture, error) {
 	return res, nil
 }
 
+func (mpp *msql_part_tx) FindPics(afterId string, limit int) ([]*Picture, error) {
+	rows, err := mpp.executor().Query("SELECT id, refs FROM picture WHERE id>? ORDER BY id LIMIT ?", afterId, limit)
+	if err != nil {
+		mpp.logger.Warn("FindPics(): coule not run the query, err=", err, ", afterId=", afterId, ", limit=", limit)
+		return nil, err
+	}
+	defer rows.Close()
+	return mpp.scanPics(rows)
+}
+
+func (mpp *msql_part_tx) FindPicsByIds(ids []string) ([]*Picture, error) {
+	if len(ids) == 0 {
+		return []*Picture{}, nil
+	}
+
+	q := "SELECT id, refs FROM picture WHERE id IN("
+	whereParams := []interface{}{}
+	for i, id := range ids {
+		if i > 0 {
+			q += ", ?"
+		} else {
+			q += "?"
+		}
+		whereParams = append(whereParams, id)
+	}
+	q += ")"
+	mpp.logger.Debug("FindPicsByIds(): q=", q, " params=", whereParams)
+	rows, err := mpp.executor().Query(q, whereParams...)
+	if err != nil {
+		mpp.logger.Warn("FindPicsByIds(): coule not run the query, err=", err)
+		return nil, err
+	}
+	defer rows.Close()
+	return mpp.scanPics(rows)
+}
+
+func (mpp *msql_part_tx) FindPicsByPrefix(prefix string) ([]*Picture, error) {
+	// '_' matches any character in LIKE, so escaping it
+	ptrn := strings.Replace(prefix, "_", "\\_", -1) + "%"
+	rows, err := mpp.executor().Query("SELECT id, refs FROM picture WHERE id LIKE ?", ptrn)
+	if err != nil {
+		mpp.logger.Warn("FindPicsByPrefix(): coule not run the query, err=", err, ", prefix=", prefix)
+		return nil, err
+	}
+	defer rows.Close()
+	return mpp.scanPics(rows)
+}
+
+func (mpp *msql_part_tx) scanPics(rows *sql.Rows) ([]*Picture, error) {
+	res := []*Picture{}
+	for rows.Next() {
+		p := new(Picture)
+		err := rows.Scan(&p.Id, &p.Refs)
+		if err != nil {
+			mpp.logger.Warn("scanPics(): coule not scan picture, err=", err)
+			return nil, err
+		}
+		res = append(res, p)
+	}
+	return res, nil
+}
+
 func (mpp *msql_part_tx) DeletePics(pics []*Picture) error {
 	if len(pics) == 0 {
 		mpp.logger.Debug("DeletePics(): nothing to delete.")
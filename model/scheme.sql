@@ -16,10 +16,13 @@ USE pixty_test;
 CREATE TABLE IF NOT EXISTS `organization` (
 	`id`                     BIGINT(20)       NOT NULL AUTO_INCREMENT,
 	`name`                   VARCHAR(255)     DEFAULT NULL,
+	`privacy`                VARCHAR(32)      NOT NULL DEFAULT '',
 	PRIMARY KEY (`id`),
 	UNIQUE `id_idx` USING BTREE (id),
 	UNIQUE `name_idx` USING BTREE (name)
 ) ENGINE=`InnoDB` AUTO_INCREMENT=1 DEFAULT CHARACTER SET utf8 COLLATE utf8_unicode_ci ROW_FORMAT=COMPACT CHECKSUM=0 DELAY_KEY_WRITE=0;
+# for the databases created before the privacy policy was introduced:
+#ALTER TABLE `organization` ADD COLUMN `privacy` VARCHAR(32) NOT NULL DEFAULT '';
 
 CREATE TABLE IF NOT EXISTS `user` (
 	`id`                     BIGINT(20)     NOT NULL AUTO_INCREMENT,
@@ -47,10 +50,19 @@ CREATE TABLE IF NOT EXISTS `camera` (
 	`name`                  VARCHAR(255) NOT NULL,
 	`org_id`                BIGINT(20) NOT NULL,
 	`secret_key`            VARCHAR(50),
+	`zones`                 TEXT,
+	`ingest`                TEXT,
+	`config`                TEXT,
 	PRIMARY KEY (`id`),
 	UNIQUE `name_org_idx` USING BTREE (name, org_id),
 	INDEX `org_id_idx` USING BTREE (org_id)
 ) ENGINE=`InnoDB` DEFAULT CHARACTER SET utf8 COLLATE utf8_bin ROW_FORMAT=COMPACT CHECKSUM=0 DELAY_KEY_WRITE=0;
+# for the databases created before the camera zones were introduced:
+#ALTER TABLE `camera` ADD COLUMN `zones` TEXT AFTER `secret_key`;
+# for the databases created before the camera ingest settings were introduced:
+#ALTER TABLE `camera` ADD COLUMN `ingest` TEXT AFTER `zones`;
+# for the databases created before the camera config was introduced:
+#ALTER TABLE `camera` ADD COLUMN `config` TEXT AFTER `ingest`;
 
 #Field Info. Please pay attention that display_name is case INSENSITIVE 'aaa' == 'AaA'
 CREATE TABLE IF NOT EXISTS `field_info` (
@@ -100,13 +112,19 @@ CREATE TABLE IF NOT EXISTS `face` (
 	`img_right`             INT,
 	`img_bottom`            INT,
 	`face_image_id`         VARCHAR(255)    NOT NULL,
+	`quality`               FLOAT           NOT NULL DEFAULT 0,
 	`v128d`	                BLOB,
 	PRIMARY KEY (`id`),
 	UNIQUE `id_idx` USING BTREE (id),
 	INDEX `person_id_idx` USING BTREE (person_id),
 	INDEX `captured_at_idx` USING BTREE (captured_at),
+	INDEX `image_id_idx` USING BTREE (image_id),
 	FOREIGN KEY (`person_id`) REFERENCES person(id) ON DELETE RESTRICT
 ) ENGINE=`InnoDB` AUTO_INCREMENT=1 DEFAULT CHARACTER SET utf8 COLLATE utf8_bin ROW_FORMAT=COMPACT CHECKSUM=0 DELAY_KEY_WRITE=0;
+# for the databases created before image_id_idx was introduced:
+#ALTER TABLE `face` ADD INDEX `image_id_idx` USING BTREE (image_id);
+# for the databases created before the face quality was introduced:
+#ALTER TABLE `face` ADD COLUMN `quality` FLOAT NOT NULL DEFAULT 0 AFTER `face_image_id`;
 
 CREATE TABLE IF NOT EXISTS `profile` (
 	`id`                     BIGINT(20)      NOT NULL AUTO_INCREMENT,
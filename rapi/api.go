@@ -2,12 +2,14 @@ package rapi
 
 import (
 	"fmt"
+	goimage "image"
 	"io"
 	"math"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
 	"path"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -20,8 +22,11 @@ import (
 	"github.com/pixty/console/service"
 	"github.com/pixty/console/service/auth"
 	"github.com/pixty/console/service/email"
+	"github.com/pixty/console/service/fpcp_serv"
 	"github.com/pixty/console/service/image"
 	"github.com/pixty/console/service/scene"
+	"github.com/pixty/console/service/storage"
+	"github.com/pixty/console/service/sweeper"
 	"golang.org/x/net/context"
 	"gopkg.in/tylerb/graceful.v1"
 )
@@ -38,6 +43,11 @@ type (
 		SessService  auth.SessionService    `inject:""`
 		AuthService  auth.AuthService       `inject:""`
 		EmSender     email.Sender           `inject:""`
+		Scrubber     sweeper.BlobScrubber   `inject:""`
+		Reconciler   sweeper.PicsReconciler `inject:""`
+		CropsMgrtr   sweeper.CropsMigrator  `inject:""`
+		CamMonitor   fpcp_serv.CamMonitor   `inject:""`
+		CamControl   fpcp_serv.CamControl   `inject:""`
 		authMW       *auth_middleware
 		logger       log4g.Logger
 	}
@@ -106,10 +116,30 @@ func (a *api) DiPostConstruct() {
 	// Example: curl https://api.pixty.io/cameras/12/timeline?limit=20&maxTime=12341234
 	a.ge.GET("/cameras/:camId/timeline", a.h_GET_cameras_timeline)
 
-	// Get an known image by its file name
+	// Get an known image by its file name. The image is returned for inline
+	// display, download=true query param makes it an attachment. ETag,
+	// Last-Modified (conditional GETs) and Range requests are supported.
+	// width and height query params scale the image to fit them, format
+	// (jpeg, png or webp) converts it. WebP is returned to clients which
+	// accept it, if no format is requested. Only the renditions of the
+	// standard sizes (160x120, 320x240, 640x480 and 800x600) are cached,
+	// others are rendered on every request.
+	// The image URLs returned by the API could be signed (ImgsSignKey config),
+	// the signed URLs don't need authentication till they are expired.
+	// If the org privacy policy is "blur", faces of persons without profiles
+	// are blurred on frames and their face images are forbidden (403), so
+	// the images are returned with "Cache-Control: private, no-cache".
 	// Example: curl https://api.pixty.io/images/cm-1-504241500992.png
+	//          curl 'https://api.pixty.io/images/cm_1_504241500992.jpeg?org=1&exp=1510000000&sig=3f2a...'
 	a.ge.GET("/images/:imgName", a.h_GET_images_png_download)
 
+	// Get the frame image with faces found on it: their rectangles and
+	// person labels (profile name, if assigned) are drawn on the frame.
+	// Takes the same query params as the images request above. The image URL
+	// signature is not accepted here, it is made for the image only.
+	// Example: curl https://api.pixty.io/images/cm_1_504241500992.jpeg/annotated?width=640
+	a.ge.GET("/images/:imgName/annotated", a.h_GET_images_imgName_annotated)
+
 	// Create new org - will be used by superadmin only
 	a.ge.POST("/orgs", a.h_POST_orgs)
 
@@ -119,6 +149,13 @@ func (a *api) DiPostConstruct() {
 	// Gets organization JSON object
 	a.ge.GET("/orgs/:orgId", a.h_GET_orgs_orgId)
 
+	// Sets the organization privacy policy. With "blur" policy faces of
+	// persons without profiles are blurred on frames and their face images
+	// are not returned. Org admin only
+	//
+	// Example: curl -v -H "Content-Type: application/json" -X PUT -d '{"privacy": "blur"}' http://api.pixty.io/orgs/1/privacy
+	a.ge.PUT("/orgs/:orgId/privacy", a.h_PUT_orgs_orgId_privacy)
+
 	// Creates list of fields for specified organization. Every fields should
 	// have display name and type (only 'text' is allowed) for now. Not for often use
 	// when the field is deleted all profiles lost its values
@@ -169,6 +206,12 @@ func (a *api) DiPostConstruct() {
 	// Gets profile persons by its id. Persons will not contain profile or matches references
 	a.ge.GET("/profiles/:prfId/persons", a.h_GET_profiles_prfId_persons)
 
+	// Gets the profile persons face images composed into one image with the
+	// capture times. Takes width, height and format query params like the
+	// images request
+	// Example: curl https://api.pixty.io/profiles/12/contactsheet?format=png
+	a.ge.GET("/profiles/:prfId/contactsheet", a.h_GET_profiles_prfId_contactsheet)
+
 	// Updates profile AvatarUrl and list values. All fieds will be updated like
 	// provided. It is not a PATCH, if a field is not set, it is considered as
 	// removed. It is SNAPSHOT UPDATE
@@ -187,6 +230,11 @@ func (a *api) DiPostConstruct() {
 	// - meta=true: includes fields in profiles
 	a.ge.GET("/persons/:persId", a.h_GET_persons_persId)
 
+	// Gets the person face images composed into one image with the capture
+	// times. Takes width, height and format query params like the images request
+	// Example: curl https://api.pixty.io/persons/f1d2c3e4/contactsheet?width=640
+	a.ge.GET("/persons/:persId/contactsheet", a.h_GET_persons_persId_contactsheet)
+
 	// Updates either avatar or profile assigned. Only this 2 fields will be updated.
 	// Both values must be relevant in the request, it is not a PATCH! Ommitting
 	// considered like an empty value, but not ignored!
@@ -203,19 +251,92 @@ func (a *api) DiPostConstruct() {
 	a.ge.DELETE("/persons/:persId/faces", a.h_DELETE_persons_persId_faces)
 
 	// Gets list of cameras for the orgId (right now orgId=1), which comes from
-	// the authorization of the call
+	// the authorization of the call. Every camera has its online status
 	a.ge.GET("/orgs/:orgId/cameras", a.h_GET_orgs_orgId_cameras)
 
 	// Creates new camera
 	a.ge.POST("/orgs/:orgId/cameras", a.h_POST_orgs_orgId_cameras)
 
-	// Gets information about a camera
+	// Returns the organization storage usage: quota, bytes and number of
+	// images taken by every camera of the org. Org admins only
+	a.ge.GET("/orgs/:orgId/storage", a.h_GET_orgs_orgId_storage)
+
+	// Gets information about a camera and its status: whether it is online,
+	// last authentication and scene times, scenes and faces per minute, error
+	// counters and scenes and faces rejected by the camera settings since the
+	// console start
 	a.ge.GET("/cameras/:camId", a.h_GET_cameras_camId)
 
 	// Generates new secret key for the camera. We don't keep the secret key, but its
 	// hash, so it is user responsibility to get the key from the response and keeps
 	// it safely. If they lost, they have to regenerate.
 	a.ge.POST("/cameras/:camId/newkey", a.h_POST_cameras_camId_newkey)
+
+	// Sets the camera zones of interest. The body is array of polygons in the
+	// frame coordinates like [{"exclude": true, "points": [{"x": 0, "y": 0}, ...]}].
+	// Faces with centers out of include zones, or in an exclude zone, are not
+	// stored. Empty array removes the zones. Org admins only
+	a.ge.PUT("/cameras/:camId/zones", a.h_PUT_cameras_camId_zones)
+
+	// Sets the camera ingest settings like {"minFaceSize": 40, "timeZone":
+	// "Europe/Berlin", "schedule": [{"days": [1,2,3,4,5], "from": "08:00", "to": "20:00"}]}.
+	// Faces smaller than minFaceSize and scenes out of the schedule are not
+	// stored. The interval with "to" earlier than "from" goes over midnight.
+	// Empty object removes the limits. Org admins only
+	a.ge.PUT("/cameras/:camId/ingest", a.h_PUT_cameras_camId_ingest)
+
+	// Returns the camera frame processor config like {"config": {"version": 3,
+	// "frameRate": 10, "frameWidth": 1280, "frameHeight": 720, "faceWidth": 200,
+	// "faceHeight": 200, "detectThreshold": 0.7}, "connected": true,
+	// "appliedVersion": 3}. appliedVersion is the config version the camera
+	// reported as applied
+	a.ge.GET("/cameras/:camId/config", a.h_GET_cameras_camId_config)
+
+	// Sets the camera frame processor config. Zero values mean the frame
+	// processor defaults. The config version is increased and the config is
+	// sent to the camera, if it is connected, or when it connects. Org admins only
+	a.ge.PUT("/cameras/:camId/config", a.h_PUT_cameras_camId_config)
+
+	// Sends the command like {"command": "snapshot"} to the camera. The
+	// commands are "snapshot", "reauthenticate" and "restart". If the camera
+	// is not connected the command waits for it a minute. Returns the command
+	// with its id and status. Org admins only
+	a.ge.POST("/cameras/:camId/commands", a.h_POST_cameras_camId_commands)
+
+	// Returns the last commands sent to the camera with their statuses, the
+	// newest first
+	a.ge.GET("/cameras/:camId/commands", a.h_GET_cameras_camId_commands)
+
+	// Returns the last blob storage integrity check report: how many objects
+	// were scanned and which ones are missing or corrupted. Superadmin only
+	a.ge.GET("/admin/storage/scrubReport", a.h_GET_admin_storage_scrubReport)
+
+	// Starts the reconciliation between the picture table and the blob storage.
+	// Only reports the drift if dryRun=true is provided, deletes the images
+	// without picture records and their renditions otherwise. The picture
+	// records without images are always only reported. Superadmin only
+	a.ge.POST("/admin/storage/reconcile", a.h_POST_admin_storage_reconcile)
+
+	// Returns the last reconciliation report. Superadmin only
+	a.ge.GET("/admin/storage/reconcileReport", a.h_GET_admin_storage_reconcileReport)
+
+	// Deletes the stored face images, which could be cut from their frames,
+	// when ImgsCropOnDemand is on. Images used by persons and profiles are
+	// kept. Superadmin only
+	a.ge.POST("/admin/storage/migrateCrops", a.h_POST_admin_storage_migrateCrops)
+
+	// Returns the last face images migration report. Superadmin only
+	a.ge.GET("/admin/storage/migrateCropsReport", a.h_GET_admin_storage_migrateCropsReport)
+
+	// Returns the scenes ingest counters: how many scenes are pending, queued,
+	// dropped because the camera queue was full, ignored as already received,
+	// processed and failed. The totals and the counters per camera. Superadmin only
+	a.ge.GET("/admin/scenes/ingest", a.h_GET_admin_scenes_ingest)
+
+	// Returns the scenes spool backlog: how many scenes are waiting to be
+	// persisted after the DB outage, their size and the oldest one time, and
+	// the spool counters. Superadmin only
+	a.ge.GET("/admin/scenes/spool", a.h_GET_admin_scenes_spool)
 }
 
 // =========================== CamId2OrgIdCache ==============================
@@ -428,6 +549,30 @@ func (a *api) h_GET_orgs_orgId(c *gin.Context) {
 	c.JSON(http.StatusOK, a.morg2org(orgDesc))
 }
 
+// PUT /orgs/:orgId/privacy - owner (o), sa
+func (a *api) h_PUT_orgs_orgId_privacy(c *gin.Context) {
+	orgId, err := parseInt64Param(c, "orgId")
+	a.logger.Info("PUT /orgs/", orgId, "/privacy")
+	if a.errorResponse(c, err) {
+		return
+	}
+
+	aCtx := a.getAuthContext(c)
+	if a.errorResponse(c, aCtx.AuthZOrgAdmin(orgId)) {
+		return
+	}
+
+	var op OrgPrivacy
+	if a.errorResponse(c, bindAppJson(c, &op)) {
+		return
+	}
+
+	if a.errorResponse(c, a.Dc.SetOrgPrivacy(orgId, op.Privacy)) {
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
 // Create new field. the number of fields is limited
 // POST /orgs/:orgId/fields - owner (o), sa
 func (a *api) h_POST_orgs_orgId_fields(c *gin.Context) {
@@ -775,6 +920,26 @@ func (a *api) h_GET_profiles_prfId_persons(c *gin.Context) {
 	c.JSON(http.StatusOK, a.prsnDescs2Persons(pds))
 }
 
+// GET /profiles/:prfId/contactsheet - o, u, sa
+func (a *api) h_GET_profiles_prfId_contactsheet(c *gin.Context) {
+	prfId, err := parseInt64Param(c, "prfId")
+	if a.errorResponse(c, err) {
+		return
+	}
+
+	a.logger.Debug("GET /profiles/", prfId, "/contactsheet")
+	pds, err := a.Dc.DescribePersonsByProfile(a.getAuthContext(c), prfId)
+	if a.errorResponse(c, err) {
+		return
+	}
+
+	faces := make([]*model.Face, 0, len(pds)*common.MAX_FACES_PER_PERSON)
+	for _, pd := range pds {
+		faces = append(faces, pd.Faces...)
+	}
+	a.serveContactSheet(c, "contactsheet_"+strconv.FormatInt(prfId, 10), faces)
+}
+
 // Updates profile. All provided values will be replaced, all other ones will be lost
 // PUT /profiles/:profileId - o, u, sa
 func (a *api) h_PUT_profiles_prfId(c *gin.Context) {
@@ -841,6 +1006,29 @@ func (a *api) h_GET_persons_persId(c *gin.Context) {
 	c.JSON(http.StatusOK, a.prsnDesc2Person(desc))
 }
 
+// GET /persons/:persId/contactsheet
+func (a *api) h_GET_persons_persId_contactsheet(c *gin.Context) {
+	persId := c.Param("persId")
+	a.logger.Debug("GET /persons/", persId, "/contactsheet")
+
+	desc, err := a.Dc.DescribePerson(a.getAuthContext(c), persId, true, false)
+	if a.errorResponse(c, err) {
+		return
+	}
+
+	if desc.Person.ProfileId <= 0 {
+		prv, err := a.isCamPrivate(desc.Person.CamId)
+		if a.errorResponse(c, err) {
+			return
+		}
+		if prv {
+			a.errorResponse(c, common.NewError(common.ERR_UNAUTHORIZED, "The person faces are not available due to the organization privacy policy."))
+			return
+		}
+	}
+	a.serveContactSheet(c, "contactsheet_"+persId, desc.Faces)
+}
+
 // Only the following fields must be both provided and will be updated:
 // - AvatarUrl
 // - ProfileId
@@ -944,11 +1132,15 @@ func (a *api) h_GET_orgs_orgId_cameras(c *gin.Context) {
 		return
 	}
 
-	cams, err := a.Dc.GetAllCameras(orgId)
+	mcams, err := a.Dc.GetAllCameras(orgId)
 	if a.errorResponse(c, err) {
 		return
 	}
-	c.JSON(http.StatusOK, a.mcams2cams(cams))
+	cams := a.mcams2cams(mcams)
+	for _, cam := range cams {
+		cam.Status = a.getCameraStatus(cam.Id)
+	}
+	c.JSON(http.StatusOK, cams)
 }
 
 // POST /orgs/:orgId/cameras
@@ -981,6 +1173,30 @@ func (a *api) h_POST_orgs_orgId_cameras(c *gin.Context) {
 	c.Status(http.StatusCreated)
 }
 
+// GET /orgs/:orgId/storage
+func (a *api) h_GET_orgs_orgId_storage(c *gin.Context) {
+	orgId, err := parseInt64Param(c, "orgId")
+	a.logger.Debug("GET /orgs/", orgId, "/storage")
+	if a.errorResponse(c, err) {
+		return
+	}
+	aCtx := a.getAuthContext(c)
+	if a.errorResponse(c, aCtx.AuthZOrgAdmin(orgId)) {
+		return
+	}
+
+	cams, err := a.Dc.GetAllCameras(orgId)
+	if a.errorResponse(c, err) {
+		return
+	}
+
+	usage, err := a.ImageService.GetOrgStorageUsage(orgId)
+	if a.errorResponse(c, err) {
+		return
+	}
+	c.JSON(http.StatusOK, a.usage2orgStorage(usage, cams))
+}
+
 // GET /cameras/:camId
 func (a *api) h_GET_cameras_camId(c *gin.Context) {
 	camId, err := parseInt64Param(c, "camId")
@@ -997,7 +1213,9 @@ func (a *api) h_GET_cameras_camId(c *gin.Context) {
 	if a.errorResponse(c, err) {
 		return
 	}
-	c.JSON(http.StatusOK, a.mcam2cam(mcam))
+	cam := a.mcam2cam(mcam)
+	cam.Status = a.getCameraStatus(camId)
+	c.JSON(http.StatusOK, cam)
 }
 
 // POST /cameras/:camId/newkey
@@ -1022,8 +1240,250 @@ func (a *api) h_POST_cameras_camId_newkey(c *gin.Context) {
 	c.JSON(http.StatusOK, cam)
 }
 
+// PUT /cameras/:camId/zones
+func (a *api) h_PUT_cameras_camId_zones(c *gin.Context) {
+	camId, err := parseInt64Param(c, "camId")
+	if a.errorResponse(c, err) {
+		return
+	}
+
+	aCtx := a.getAuthContext(c)
+	if a.errorResponse(c, aCtx.AuthZCamAccess(camId, auth.AUTHZ_LEVEL_OA)) {
+		return
+	}
+
+	var zones model.CamZones
+	if a.errorResponse(c, bindAppJson(c, &zones)) {
+		return
+	}
+
+	if a.errorResponse(c, a.Dc.SetCameraZones(camId, zones)) {
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// PUT /cameras/:camId/ingest
+func (a *api) h_PUT_cameras_camId_ingest(c *gin.Context) {
+	camId, err := parseInt64Param(c, "camId")
+	if a.errorResponse(c, err) {
+		return
+	}
+
+	aCtx := a.getAuthContext(c)
+	if a.errorResponse(c, aCtx.AuthZCamAccess(camId, auth.AUTHZ_LEVEL_OA)) {
+		return
+	}
+
+	var ci model.CamIngest
+	if a.errorResponse(c, bindAppJson(c, &ci)) {
+		return
+	}
+
+	if a.errorResponse(c, a.Dc.SetCameraIngest(camId, &ci)) {
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// GET /cameras/:camId/config
+func (a *api) h_GET_cameras_camId_config(c *gin.Context) {
+	camId, err := parseInt64Param(c, "camId")
+	if a.errorResponse(c, err) {
+		return
+	}
+
+	aCtx := a.getAuthContext(c)
+	if a.errorResponse(c, aCtx.AuthZCamAccess(camId, auth.AUTHZ_LEVEL_OU)) {
+		return
+	}
+
+	mcam, err := a.Dc.GetCameraById(camId)
+	if a.errorResponse(c, err) {
+		return
+	}
+	c.JSON(http.StatusOK, a.getCameraConfig(camId, mcam.Config))
+}
+
+// PUT /cameras/:camId/config
+func (a *api) h_PUT_cameras_camId_config(c *gin.Context) {
+	camId, err := parseInt64Param(c, "camId")
+	if a.errorResponse(c, err) {
+		return
+	}
+
+	aCtx := a.getAuthContext(c)
+	if a.errorResponse(c, aCtx.AuthZCamAccess(camId, auth.AUTHZ_LEVEL_OA)) {
+		return
+	}
+
+	var cfg model.CamConfig
+	if a.errorResponse(c, bindAppJson(c, &cfg)) {
+		return
+	}
+
+	if a.errorResponse(c, a.Dc.SetCameraConfig(camId, &cfg)) {
+		return
+	}
+	a.CamControl.PushConfig(camId, &cfg)
+	c.JSON(http.StatusOK, a.getCameraConfig(camId, &cfg))
+}
+
+// POST /cameras/:camId/commands
+func (a *api) h_POST_cameras_camId_commands(c *gin.Context) {
+	camId, err := parseInt64Param(c, "camId")
+	if a.errorResponse(c, err) {
+		return
+	}
+
+	aCtx := a.getAuthContext(c)
+	if a.errorResponse(c, aCtx.AuthZCamAccess(camId, auth.AUTHZ_LEVEL_OA)) {
+		return
+	}
+
+	var cmd CameraCommand
+	if a.errorResponse(c, bindAppJson(c, &cmd)) {
+		return
+	}
+
+	cmnd, err := a.CamControl.SendCommand(camId, cmd.Command)
+	if a.errorResponse(c, err) {
+		return
+	}
+	c.JSON(http.StatusCreated, camCommand2camCommand(cmnd))
+}
+
+// GET /cameras/:camId/commands
+func (a *api) h_GET_cameras_camId_commands(c *gin.Context) {
+	camId, err := parseInt64Param(c, "camId")
+	if a.errorResponse(c, err) {
+		return
+	}
+
+	aCtx := a.getAuthContext(c)
+	if a.errorResponse(c, aCtx.AuthZCamAccess(camId, auth.AUTHZ_LEVEL_OU)) {
+		return
+	}
+
+	cmnds := a.CamControl.GetCommands(camId)
+	res := make([]*CameraCommand, len(cmnds))
+	for i, cmnd := range cmnds {
+		res[i] = camCommand2camCommand(cmnd)
+	}
+	c.JSON(http.StatusOK, res)
+}
+
+// GET /admin/storage/scrubReport
+func (a *api) h_GET_admin_storage_scrubReport(c *gin.Context) {
+	a.logger.Debug("GET /admin/storage/scrubReport")
+	aCtx := a.getAuthContext(c)
+	if a.errorResponse(c, aCtx.AuthZSuperadmin()) {
+		return
+	}
+
+	rep := a.Scrubber.GetLastReport()
+	if rep == nil {
+		a.errorResponse(c, common.NewError(common.ERR_NOT_FOUND, "No scrub report yet, the storage was not checked since the start."))
+		return
+	}
+	c.JSON(http.StatusOK, a.scrubReport2scrubReport(rep))
+}
+
+// POST /admin/storage/reconcile?dryRun=true
+func (a *api) h_POST_admin_storage_reconcile(c *gin.Context) {
+	dryRun := c.Query("dryRun") == "true"
+	a.logger.Debug("POST /admin/storage/reconcile?dryRun=", dryRun)
+	aCtx := a.getAuthContext(c)
+	if a.errorResponse(c, aCtx.AuthZSuperadmin()) {
+		return
+	}
+
+	if a.errorResponse(c, a.Reconciler.Reconcile(dryRun)) {
+		return
+	}
+	c.Status(http.StatusAccepted)
+}
+
+// GET /admin/storage/reconcileReport
+func (a *api) h_GET_admin_storage_reconcileReport(c *gin.Context) {
+	a.logger.Debug("GET /admin/storage/reconcileReport")
+	aCtx := a.getAuthContext(c)
+	if a.errorResponse(c, aCtx.AuthZSuperadmin()) {
+		return
+	}
+
+	rep := a.Reconciler.GetLastReport()
+	if rep == nil {
+		a.errorResponse(c, common.NewError(common.ERR_NOT_FOUND, "No reconciliation report yet."))
+		return
+	}
+	c.JSON(http.StatusOK, a.reconcileReport2reconcileReport(rep))
+}
+
+// POST /admin/storage/migrateCrops
+func (a *api) h_POST_admin_storage_migrateCrops(c *gin.Context) {
+	a.logger.Debug("POST /admin/storage/migrateCrops")
+	aCtx := a.getAuthContext(c)
+	if a.errorResponse(c, aCtx.AuthZSuperadmin()) {
+		return
+	}
+
+	if a.errorResponse(c, a.CropsMgrtr.Migrate()) {
+		return
+	}
+	c.Status(http.StatusAccepted)
+}
+
+// GET /admin/storage/migrateCropsReport
+func (a *api) h_GET_admin_storage_migrateCropsReport(c *gin.Context) {
+	a.logger.Debug("GET /admin/storage/migrateCropsReport")
+	aCtx := a.getAuthContext(c)
+	if a.errorResponse(c, aCtx.AuthZSuperadmin()) {
+		return
+	}
+
+	rep := a.CropsMgrtr.GetLastReport()
+	if rep == nil {
+		a.errorResponse(c, common.NewError(common.ERR_NOT_FOUND, "No face images migration report yet."))
+		return
+	}
+	c.JSON(http.StatusOK, a.cropsReport2cropsReport(rep))
+}
+
+// GET /admin/scenes/ingest
+func (a *api) h_GET_admin_scenes_ingest(c *gin.Context) {
+	a.logger.Debug("GET /admin/scenes/ingest")
+	aCtx := a.getAuthContext(c)
+	if a.errorResponse(c, aCtx.AuthZSuperadmin()) {
+		return
+	}
+
+	total, cams := a.ScnProcessor.GetIngestStats()
+	res := &SceneIngest{Total: ingestStats2ingestStats(total), Cameras: make([]*IngestStats, len(cams))}
+	for i, st := range cams {
+		res.Cameras[i] = ingestStats2ingestStats(st)
+	}
+	c.JSON(http.StatusOK, res)
+}
+
+// GET /admin/scenes/spool
+func (a *api) h_GET_admin_scenes_spool(c *gin.Context) {
+	a.logger.Debug("GET /admin/scenes/spool")
+	aCtx := a.getAuthContext(c)
+	if a.errorResponse(c, aCtx.AuthZSuperadmin()) {
+		return
+	}
+
+	st := a.ScnProcessor.GetSpoolStats()
+	if st == nil {
+		a.errorResponse(c, common.NewError(common.ERR_NOT_FOUND, "The scenes spool is off."))
+		return
+	}
+	c.JSON(http.StatusOK, spoolStats2spoolStats(st))
+}
+
 // GET /images/:imgName
-// the image name is encoded like <id>[_l_t_r_b].jpeg
+// the image name is encoded like <id>[_l_t_r_b].jpeg or .png
 //
 // so the size part can be missed. Valid names are:
 // asbasdfasdf-234.png	 - no region
@@ -1033,30 +1493,205 @@ func (a *api) h_GET_images_png_download(c *gin.Context) {
 	imgName := c.Param("imgName")
 	a.logger.Debug("GET /images/", imgName)
 
-	imd := &image.ImgDesc{}
-	err := imd.ParseFileName(imgName)
+	imd, rnd, ok := a.parseImageRequest(c, imgName, image.IMG_VARIANT_IMAGE)
+	if !ok {
+		return
+	}
+
+	prv, err := a.isCamPrivate(imd.CamId)
 	if a.errorResponse(c, err) {
 		return
 	}
+	if prv {
+		fd, err := a.Dc.DescribeFrame(imd.GetFrameFileName())
+		if a.errorResponse(c, err) {
+			return
+		}
+		if imd.Rect == nil {
+			a.errorResponse(c, a.serveRenderedFrame(c, imgName, imd, rnd, privacyBlurs(fd), nil))
+			return
+		}
+		if a.errorResponse(c, checkFacePrivacy(fd, imgName)) {
+			return
+		}
+	}
 
-	aCtx := a.getAuthContext(c)
-	if a.errorResponse(c, aCtx.AuthZCamAccess(imd.CamId, auth.AUTHZ_LEVEL_OA)) {
+	rdr, bm, err := a.ImageService.GetImage(imd, rnd)
+	if a.errorResponse(c, err) {
+		return
+	}
+	defer rdr.Close()
+
+	w := c.Writer
+	w.Header().Set("ETag", imageETag(bm))
+	// the image could be blurred or forbidden when the org privacy policy
+	// changes, so browsers revalidate it every time, it is cheap with ETag
+	w.Header().Set("Cache-Control", "private, no-cache")
+	a.serveImage(c, imgName, rnd.Format, bm.Timestamp, rdr)
+}
+
+// Returns the frame image with the faces rectangles and person labels drawn.
+// Accepts the same query params as GET /images/:imgName. If the org privacy
+// policy is "blur", faces of persons without profiles are blurred and not
+// labeled
+// GET /images/:imgName/annotated
+func (a *api) h_GET_images_imgName_annotated(c *gin.Context) {
+	imgName := c.Param("imgName")
+	a.logger.Debug("GET /images/", imgName, "/annotated")
+
+	imd, rnd, ok := a.parseImageRequest(c, imgName, image.IMG_VARIANT_ANNOTATED)
+	if !ok {
 		return
 	}
+	if imd.Rect != nil {
+		a.errorResponse(c, common.NewError(common.ERR_INVALID_VAL, "Only frame images (without a region) can be annotated, but "+imgName))
+		return
+	}
+
+	fd, err := a.Dc.DescribeFrame(imgName)
+	if a.errorResponse(c, err) {
+		return
+	}
+
+	prv, err := a.isCamPrivate(imd.CamId)
+	if a.errorResponse(c, err) {
+		return
+	}
+
+	anns := a.frameDesc2annotations(fd)
+	var blurs []goimage.Rectangle
+	if prv {
+		blurs = privacyBlurs(fd)
+		for i, f := range fd.Faces {
+			if !isEnrolled(fd, f.PersonId) {
+				anns[i].Label = ""
+			}
+		}
+	}
+	a.errorResponse(c, a.serveRenderedFrame(c, imgName, imd, rnd, blurs, anns))
+}
+
+// Returns true if the camera organization privacy policy requires faces of
+// persons without profiles to be hidden
+func (a *api) isCamPrivate(camId int64) (bool, error) {
+	prv, err := a.Dc.GetOrgPrivacy(a.GetOrgId(camId))
+	return prv == model.ORG_PRIVACY_BLUR, err
+}
+
+// Returns ERR_UNAUTHORIZED if the face image belongs to a person without
+// profile, or the face is not known.
+func checkFacePrivacy(fd *service.FrameDesc, imgName string) error {
+	for _, f := range fd.Faces {
+		if f.FaceImageId == imgName {
+			if isEnrolled(fd, f.PersonId) {
+				return nil
+			}
+			break
+		}
+	}
+	return common.NewError(common.ERR_UNAUTHORIZED, "The face image "+imgName+" is not available due to the organization privacy policy.")
+}
+
+// Renders the frame with the regions blurred and the annotations drawn. The
+// result is not cached, labels could be changed and persons could be
+// assigned to profiles any time.
+func (a *api) serveRenderedFrame(c *gin.Context, imgName string, imd *image.ImgDesc, rnd *image.ImgRendition,
+	blurs []goimage.Rectangle, anns []*image.ImgAnnotation) error {
+	rdr, _, err := a.ImageService.RenderFrame(imd, rnd, blurs, anns)
+	if err != nil {
+		return err
+	}
+	defer rdr.Close()
+
+	c.Writer.Header().Set("Cache-Control", "private, no-cache")
+	a.serveImage(c, imgName, rnd.Format, time.Time{}, rdr)
+	return nil
+}
 
+// Composes the faces images, the latest first, into one image and sends it.
+// The caller is responsible for the faces access check.
+func (a *api) serveContactSheet(c *gin.Context, name string, faces []*model.Face) {
 	q := c.Request.URL.Query()
 	wdth, _ := parseInt64QueryParam2("width", q, 0)
 	hght, _ := parseInt64QueryParam2("height", q, 0)
+	frmt, err := imgFormat(c.Request, q)
+	if a.errorResponse(c, err) {
+		return
+	}
+	if frmt == 0 {
+		frmt = image.IMG_FRMT_JPEG
+	}
+
+	sort.Slice(faces, func(i, j int) bool { return faces[i].CapturedAt > faces[j].CapturedAt })
+	items := make([]*image.ContactSheetItem, 0, len(faces))
+	for _, f := range faces {
+		if f.FaceImageId == "" {
+			continue
+		}
+		capt := common.Timestamp(f.CapturedAt).ToTime().UTC().Format("2006-01-02 15:04:05")
+		items = append(items, &image.ContactSheetItem{FileName: f.FaceImageId, Caption: capt})
+	}
 
-	rdr, err := a.ImageService.GetImageByFileName(imd, int(wdth), int(hght))
+	rnd := &image.ImgRendition{Width: int(wdth), Height: int(hght), Format: frmt}
+	rdr, _, err := a.ImageService.GetContactSheet(items, rnd)
 	if a.errorResponse(c, err) {
 		return
 	}
+	defer rdr.Close()
+
+	// new faces come any time
+	c.Writer.Header().Set("Cache-Control", "private, no-cache")
+	a.serveImage(c, name, frmt, time.Time{}, rdr)
+}
+
+// Parses the image name and the rendition params, checks the access to the
+// image. A signed URL is accepted, if it is signed for the image variant.
+// Returns false, if the request is already responded with an error.
+func (a *api) parseImageRequest(c *gin.Context, imgName, variant string) (*image.ImgDesc, *image.ImgRendition, bool) {
+	imd := &image.ImgDesc{}
+	err := imd.ParseFileName(imgName)
+	if a.errorResponse(c, err) {
+		return nil, nil, false
+	}
 
+	q := c.Request.URL.Query()
+	if q.Get("sig") != "" {
+		// signed URL, no session is needed
+		if a.errorResponse(c, a.checkImgSignature(imgName, variant, q)) {
+			return nil, nil, false
+		}
+	} else {
+		aCtx := a.getAuthContext(c)
+		if a.errorResponse(c, aCtx.AuthZCamAccess(imd.CamId, auth.AUTHZ_LEVEL_OA)) {
+			return nil, nil, false
+		}
+	}
+
+	wdth, _ := parseInt64QueryParam2("width", q, 0)
+	hght, _ := parseInt64QueryParam2("height", q, 0)
+
+	frmt, err := imgFormat(c.Request, q)
+	if a.errorResponse(c, err) {
+		return nil, nil, false
+	}
+	if frmt == 0 {
+		frmt = imd.Format
+	}
+	return imd, &image.ImgRendition{Width: int(wdth), Height: int(hght), Format: frmt}, true
+}
+
+func (a *api) serveImage(c *gin.Context, imgName string, frmt byte, modTime time.Time, rdr io.ReadCloser) {
 	w := c.Writer
-	r := c.Request
-	w.Header().Set("Content-Disposition", "attachment; filename=\""+imgName+"\"")
-	http.ServeContent(w, r, imgName, time.Now(), rdr.(io.ReadSeeker))
+	disp := "inline"
+	if c.Query("download") == "true" {
+		disp = "attachment"
+	}
+	fileName := strings.TrimSuffix(imgName, path.Ext(imgName)) + image.GetImageFileExt(frmt)
+	w.Header().Set("Content-Disposition", disp+"; filename=\""+fileName+"\"")
+	w.Header().Set("Content-Type", image.GetImageContentType(frmt))
+	w.Header().Set("Vary", "Accept")
+	// ServeContent takes care about If-None-Match, If-Modified-Since and Range
+	http.ServeContent(w, c.Request, imgName, modTime, rdr.(io.ReadSeeker))
 }
 
 // ================================ Helpers ==================================
@@ -1068,6 +1703,49 @@ func (a *api) String() string {
 	return "api: {}"
 }
 
+// Returns the image format requested by the format query param, or the
+// best one accepted by the client. 0 means the image could be returned as is.
+func imgFormat(r *http.Request, q url.Values) (byte, error) {
+	switch q.Get("format") {
+	case "":
+	case "jpeg", "jpg":
+		return image.IMG_FRMT_JPEG, nil
+	case "png":
+		return image.IMG_FRMT_PNG, nil
+	case "webp":
+		return image.IMG_FRMT_WEBP, nil
+	default:
+		return 0, common.NewError(common.ERR_INVALID_VAL, "Unsupported image format "+q.Get("format")+", expecting jpeg, png or webp")
+	}
+
+	if strings.Contains(r.Header.Get("Accept"), "image/webp") {
+		return image.IMG_FRMT_WEBP, nil
+	}
+	return 0, nil
+}
+
+func (a *api) checkImgSignature(imgName, variant string, q url.Values) error {
+	orgId, err := parseInt64QueryParam2("org", q, 0)
+	if err != nil {
+		return err
+	}
+	exp, err := parseInt64QueryParam2("exp", q, 0)
+	if err != nil {
+		return err
+	}
+	is := &image.ImgSignature{OrgId: orgId, Expires: exp, Sig: q.Get("sig")}
+	return a.ImageService.CheckFileNameSignature(imgName, variant, is)
+}
+
+// Strong ETag of the image content. Objects stored before checksums were
+// introduced get it from their timestamp and size.
+func imageETag(bm *storage.BlobMeta) string {
+	if bm.Checksum != "" {
+		return "\"" + bm.Checksum + "\""
+	}
+	return fmt.Sprintf("\"%x-%x\"", bm.Timestamp.UnixNano(), bm.Size)
+}
+
 func ptr2int64(i *int64, defVal int64) int64 {
 	if i == nil {
 		return defVal
@@ -1093,6 +1771,14 @@ func toPtrString(v string) *string {
 	return &v
 }
 
+func toPtrISO8601Time(t time.Time) *common.ISO8601Time {
+	if t.IsZero() {
+		return nil
+	}
+	res := common.ISO8601Time(t)
+	return &res
+}
+
 // Will block invoker until an error happens
 // If the application is interrupted by SIGINT, it will complete gracefully and return
 func (a *api) Run() {
@@ -1256,7 +1942,157 @@ func (a *api) imgURL(imgId string) string {
 	if imgId == "" {
 		return ""
 	}
-	return a.Config.ImgsPrefix + imgId
+	is := a.ImageService.SignFileName(imgId, image.IMG_VARIANT_IMAGE)
+	if is == nil {
+		return a.Config.ImgsPrefix + imgId
+	}
+	return fmt.Sprint(a.Config.ImgsPrefix, imgId, "?org=", is.OrgId, "&exp=", is.Expires, "&sig=", is.Sig)
+}
+
+func (a *api) usage2orgStorage(usage *storage.BlobOwnerUsage, mcams []*model.Camera) *OrgStorage {
+	res := new(OrgStorage)
+	res.OrgId = usage.Owner
+	res.Quota = usage.Quota
+	res.Size = usage.Size
+	res.Objects = usage.Objects
+	res.Cameras = make([]*CameraStorage, 0, len(mcams))
+	for _, mc := range mcams {
+		cs := &CameraStorage{CamId: mc.Id}
+		if u, ok := usage.SubOwners[mc.Id]; ok {
+			cs.Size = u.Size
+			cs.Objects = u.Objects
+		}
+		res.Cameras = append(res.Cameras, cs)
+	}
+	return res
+}
+
+func (a *api) scrubReport2scrubReport(rep *sweeper.ScrubReport) *ScrubReport {
+	res := new(ScrubReport)
+	res.StartedAt = common.ToTimestamp(rep.StartedAt).ToISO8601Time()
+	res.FinishedAt = common.ToTimestamp(rep.FinishedAt).ToISO8601Time()
+	res.Scanned = rep.Scanned
+	res.Missing = rep.Missing
+	res.Corrupted = rep.Corrupted
+	res.MissingIds = rep.MissingIds
+	res.CorruptedIds = rep.CorruptedIds
+	if rep.Err != nil {
+		res.Error = rep.Err.Error()
+	}
+	return res
+}
+
+func (a *api) reconcileReport2reconcileReport(rep *sweeper.ReconcileReport) *ReconcileReport {
+	res := new(ReconcileReport)
+	res.StartedAt = common.ToTimestamp(rep.StartedAt).ToISO8601Time()
+	res.FinishedAt = common.ToTimestamp(rep.FinishedAt).ToISO8601Time()
+	res.DryRun = rep.DryRun
+	res.BlobsScanned = rep.BlobsScanned
+	res.PicsScanned = rep.PicsScanned
+	res.OrphanImages = rep.OrphanImages
+	res.MissingImages = rep.MissingImages
+	res.ImagesDeleted = rep.ImagesDeleted
+	res.OrphanImageIds = rep.OrphanImageIds
+	res.MissingImageIds = rep.MissingImageIds
+	if rep.Err != nil {
+		res.Error = rep.Err.Error()
+	}
+	return res
+}
+
+func (a *api) cropsReport2cropsReport(rep *sweeper.CropsMigrationReport) *CropsMigrationReport {
+	res := new(CropsMigrationReport)
+	res.StartedAt = common.ToTimestamp(rep.StartedAt).ToISO8601Time()
+	res.FinishedAt = common.ToTimestamp(rep.FinishedAt).ToISO8601Time()
+	res.PicsScanned = rep.PicsScanned
+	res.ImagesDropped = rep.ImagesDropped
+	if rep.Err != nil {
+		res.Error = rep.Err.Error()
+	}
+	return res
+}
+
+func ingestStats2ingestStats(st *scene.IngestStats) *IngestStats {
+	return &IngestStats{CamId: st.CamId, Pending: st.Pending, Queued: st.Queued, Dropped: st.Dropped,
+		Duplicates: st.Duplicates, Processed: st.Processed, Failed: st.Failed, InactiveScenes: st.InactiveScenes,
+		SmallFaces: st.SmallFaces, OutOfZoneFaces: st.OutOfZoneFaces}
+}
+
+func spoolStats2spoolStats(st *scene.SpoolStats) *SceneSpool {
+	res := &SceneSpool{Entries: st.Entries, Size: st.Size, Spooled: st.Spooled, Replayed: st.Replayed,
+		Dropped: st.Dropped, Failed: st.Failed}
+	res.OldestAt = toPtrISO8601Time(st.OldestAt)
+	if st.Err != nil {
+		res.Error = st.Err.Error()
+	}
+	return res
+}
+
+func (a *api) frameDesc2annotations(fd *service.FrameDesc) []*image.ImgAnnotation {
+	res := make([]*image.ImgAnnotation, len(fd.Faces))
+	for i, f := range fd.Faces {
+		res[i] = &image.ImgAnnotation{Rect: face2rect(f), Label: a.personLabel(fd, f.PersonId)}
+	}
+	return res
+}
+
+func face2rect(f *model.Face) goimage.Rectangle {
+	r := f.Rect
+	return goimage.Rect(r.LeftTop.X, r.LeftTop.Y, r.RightBottom.X, r.RightBottom.Y)
+}
+
+// Returns the rectangles of the faces of persons without profiles
+func privacyBlurs(fd *service.FrameDesc) []goimage.Rectangle {
+	res := make([]goimage.Rectangle, 0, len(fd.Faces))
+	for _, f := range fd.Faces {
+		if !isEnrolled(fd, f.PersonId) {
+			res = append(res, face2rect(f))
+		}
+	}
+	return res
+}
+
+// A person is enrolled if it is assigned to a profile
+func isEnrolled(fd *service.FrameDesc, persId string) bool {
+	p, ok := fd.Persons[persId]
+	return ok && p.ProfileId > 0
+}
+
+// The person label is the profile name (a field which display name contains
+// "name", or the first filled field), the profile id, or the person id
+func (a *api) personLabel(fd *service.FrameDesc, persId string) string {
+	p, ok := fd.Persons[persId]
+	if !ok || p.ProfileId <= 0 {
+		return shortPersonId(persId)
+	}
+	prf, ok := fd.Profiles[p.ProfileId]
+	if !ok {
+		return "#" + strconv.FormatInt(p.ProfileId, 10)
+	}
+
+	lbl := ""
+	for _, pm := range prf.Meta {
+		if pm.Value == "" {
+			continue
+		}
+		if strings.Contains(strings.ToLower(pm.DisplayName), "name") {
+			return pm.Value
+		}
+		if lbl == "" {
+			lbl = pm.Value
+		}
+	}
+	if lbl == "" {
+		lbl = "#" + strconv.FormatInt(prf.Id, 10)
+	}
+	return lbl
+}
+
+func shortPersonId(persId string) string {
+	if len(persId) > 8 {
+		return persId[:8]
+	}
+	return persId
 }
 
 func (a *api) mcams2cams(mcams []*model.Camera) []*Camera {
@@ -1276,14 +2112,50 @@ func (a *api) mcam2cam(mcam *model.Camera) *Camera {
 	cam.DisplayName = mcam.Name
 	cam.OrgId = mcam.OrgId
 	cam.HasSecretKey = mcam.SecretKey != ""
+	cam.Zones = mcam.Zones
+	cam.Ingest = mcam.Ingest
 	return cam
 }
 
+func (a *api) getCameraStatus(camId int64) *CameraStatus {
+	res := camStatus2camStatus(a.CamMonitor.GetStatus(camId))
+	ist := a.ScnProcessor.GetCamIngestStats(camId)
+	res.InactiveScenes = ist.InactiveScenes
+	res.SmallFaces = ist.SmallFaces
+	res.OutOfZoneFaces = ist.OutOfZoneFaces
+	return res
+}
+
+func (a *api) getCameraConfig(camId int64, cfg *model.CamConfig) *CameraConfig {
+	st := a.CamControl.GetState(camId)
+	return &CameraConfig{Config: cfg, Connected: st.Connected, AppliedVersion: st.AppliedVersion, ApplyError: st.ApplyError}
+}
+
+func camCommand2camCommand(cmnd *fpcp_serv.CamCommand) *CameraCommand {
+	return &CameraCommand{Id: cmnd.Id, Command: cmnd.Cmd, Status: cmnd.Status, Error: cmnd.Error,
+		CreatedAt: toPtrISO8601Time(cmnd.CreatedAt), UpdatedAt: toPtrISO8601Time(cmnd.UpdatedAt)}
+}
+
+func camStatus2camStatus(st *fpcp_serv.CamStatus) *CameraStatus {
+	res := new(CameraStatus)
+	res.Online = st.Online
+	res.Since = toPtrISO8601Time(st.Since)
+	res.LastAuthAt = toPtrISO8601Time(st.LastAuthAt)
+	res.LastSceneAt = toPtrISO8601Time(st.LastSceneAt)
+	res.ScenesPerMin = st.ScenesPerMin
+	res.FacesPerMin = st.FacesPerMin
+	res.AuthErrors = st.AuthErrors
+	res.RejectedScenes = st.RejectedScenes
+	return res
+}
+
 func (a *api) cam2mcam(cam *Camera) *model.Camera {
 	mcam := new(model.Camera)
 	mcam.Id = cam.Id
 	mcam.Name = cam.DisplayName
 	mcam.OrgId = cam.OrgId
+	mcam.Zones = cam.Zones
+	mcam.Ingest = cam.Ingest
 	return mcam
 }
 
@@ -1461,6 +2333,7 @@ func (a *api) faceToPictureInfo(face *model.Face) *PictureInfo {
 	pi.FaceURL = &fUrl
 	pi.PicURL = a.imgURL(face.ImageId)
 	pi.Rect = &face.Rect
+	pi.Quality = face.Quality
 	ts := common.Timestamp(face.CapturedAt)
 	tss := ts.ToISO8601Time()
 	pi.Timestamp = &tss
@@ -1492,6 +2365,7 @@ func (a *api) morg2org(od *service.OrgDesc) *Organization {
 	org := new(Organization)
 	org.Id = od.Org.Id
 	org.Name = od.Org.Name
+	org.Privacy = od.Org.Privacy
 	org.Meta = a.fieldInfos2MetaInfos(od.Fields)
 	org.Cameras = a.mcams2cams(od.Cams)
 	org.Users = a.muserRoles2userRoles(od.Users)
@@ -9,9 +9,10 @@ type (
 	PersonStatus string
 
 	Organization struct {
-		Id   int64          `json:"id"`
-		Name string         `json:"name"`
-		Meta OrgMetaInfoArr `json:"metaInfo"`
+		Id      int64          `json:"id"`
+		Name    string         `json:"name"`
+		Meta    OrgMetaInfoArr `json:"metaInfo"`
+		Privacy string         `json:"privacy"`
 
 		// optional
 		Cameras []*Camera   `json:"cameras,omitempty"`
@@ -20,6 +21,13 @@ type (
 
 	OrgMetaInfoArr []*OrgMetaInfo
 
+	// The organization privacy policy: "" - no restrictions, "blur" - faces
+	// of persons without profiles are blurred on frames, their face images
+	// are not available
+	OrgPrivacy struct {
+		Privacy string `json:"privacy"`
+	}
+
 	OrgMetaInfo struct {
 		Id        int64  `json:"id"`
 		FieldName string `json:"fieldName"`
@@ -46,6 +54,126 @@ type (
 		OrgId        int64   `json:"orgId"`
 		HasSecretKey bool    `json:"hasSecretKey"`
 		SecretKey    *string `json:"secretKey,omitempty"`
+		// zones of interest, faces out of them are ignored
+		Zones model.CamZones `json:"zones,omitempty"`
+		// ingest settings: minimum face size and active hours
+		Ingest *model.CamIngest `json:"ingest,omitempty"`
+		// the camera status is returned by GET only
+		Status *CameraStatus `json:"status,omitempty"`
+	}
+
+	// The camera frame processor config and whether the camera applied it
+	CameraConfig struct {
+		Config *model.CamConfig `json:"config,omitempty"`
+		// the camera control stream is open
+		Connected bool `json:"connected"`
+		// the config version applied by the camera and the camera error
+		// description, if it could not apply the config
+		AppliedVersion uint64 `json:"appliedVersion"`
+		ApplyError     string `json:"applyError,omitempty"`
+	}
+
+	CameraCommand struct {
+		Id string `json:"id"`
+		// "snapshot", "reauthenticate" or "restart"
+		Command string `json:"command"`
+		// "pending", "sent", "done", "failed" or "expired"
+		Status    string              `json:"status"`
+		Error     string              `json:"error,omitempty"`
+		CreatedAt *common.ISO8601Time `json:"createdAt,omitempty"`
+		UpdatedAt *common.ISO8601Time `json:"updatedAt,omitempty"`
+	}
+
+	CameraStatus struct {
+		Online bool `json:"online"`
+		// when the camera went online or offline last time
+		Since          *common.ISO8601Time `json:"since,omitempty"`
+		LastAuthAt     *common.ISO8601Time `json:"lastAuthAt,omitempty"`
+		LastSceneAt    *common.ISO8601Time `json:"lastSceneAt,omitempty"`
+		ScenesPerMin   int                 `json:"scenesPerMin"`
+		FacesPerMin    int                 `json:"facesPerMin"`
+		AuthErrors     uint64              `json:"authErrors"`
+		RejectedScenes uint64              `json:"rejectedScenes"`
+		// scenes and faces rejected by the camera ingest settings and zones
+		InactiveScenes uint64 `json:"inactiveScenes"`
+		SmallFaces     uint64 `json:"smallFaces"`
+		OutOfZoneFaces uint64 `json:"outOfZoneFaces"`
+	}
+
+	OrgStorage struct {
+		OrgId   int64            `json:"orgId"`
+		Quota   int64            `json:"quota"`
+		Size    int64            `json:"size"`
+		Objects int              `json:"objects"`
+		Cameras []*CameraStorage `json:"cameras"`
+	}
+
+	CameraStorage struct {
+		CamId   int64 `json:"camId"`
+		Size    int64 `json:"size"`
+		Objects int   `json:"objects"`
+	}
+
+	ScrubReport struct {
+		StartedAt    common.ISO8601Time `json:"startedAt"`
+		FinishedAt   common.ISO8601Time `json:"finishedAt"`
+		Scanned      int                `json:"scanned"`
+		Missing      int                `json:"missing"`
+		Corrupted    int                `json:"corrupted"`
+		MissingIds   []string           `json:"missingIds,omitempty"`
+		CorruptedIds []string           `json:"corruptedIds,omitempty"`
+		Error        string             `json:"error,omitempty"`
+	}
+
+	ReconcileReport struct {
+		StartedAt       common.ISO8601Time `json:"startedAt"`
+		FinishedAt      common.ISO8601Time `json:"finishedAt"`
+		DryRun          bool               `json:"dryRun"`
+		BlobsScanned    int                `json:"blobsScanned"`
+		PicsScanned     int                `json:"picsScanned"`
+		OrphanImages    int                `json:"orphanImages"`
+		MissingImages   int                `json:"missingImages"`
+		ImagesDeleted   int                `json:"imagesDeleted"`
+		OrphanImageIds  []string           `json:"orphanImageIds,omitempty"`
+		MissingImageIds []string           `json:"missingImageIds,omitempty"`
+		Error           string             `json:"error,omitempty"`
+	}
+
+	CropsMigrationReport struct {
+		StartedAt     common.ISO8601Time `json:"startedAt"`
+		FinishedAt    common.ISO8601Time `json:"finishedAt"`
+		PicsScanned   int                `json:"picsScanned"`
+		ImagesDropped int                `json:"imagesDropped"`
+		Error         string             `json:"error,omitempty"`
+	}
+
+	SceneIngest struct {
+		Total   *IngestStats   `json:"total"`
+		Cameras []*IngestStats `json:"cameras"`
+	}
+
+	IngestStats struct {
+		CamId          int64  `json:"camId,omitempty"`
+		Pending        int    `json:"pending"`
+		Queued         uint64 `json:"queued"`
+		Dropped        uint64 `json:"dropped"`
+		Duplicates     uint64 `json:"duplicates"`
+		Processed      uint64 `json:"processed"`
+		Failed         uint64 `json:"failed"`
+		InactiveScenes uint64 `json:"inactiveScenes"`
+		SmallFaces     uint64 `json:"smallFaces"`
+		OutOfZoneFaces uint64 `json:"outOfZoneFaces"`
+	}
+
+	SceneSpool struct {
+		Entries  int                 `json:"entries"`
+		Size     int64               `json:"size"`
+		OldestAt *common.ISO8601Time `json:"oldestAt,omitempty"`
+		Spooled  uint64              `json:"spooled"`
+		Replayed uint64              `json:"replayed"`
+		Dropped  uint64              `json:"dropped"`
+		Failed   uint64              `json:"failed"`
+		Error    string              `json:"error,omitempty"`
 	}
 
 	Profile struct {
@@ -90,6 +218,8 @@ type (
 		Rect    *model.Rectangle `json:"rect,omitempty"`
 		PicURL  string           `json:"picURL"`
 		FaceURL *string          `json:"url,omitempty"`
+		// The face image quality in (0..1], higher is better
+		Quality float32 `json:"quality,omitempty"`
 	}
 
 	SceneTimeline struct {
@@ -4,7 +4,10 @@ import (
 	"errors"
 	"regexp"
 	"strconv"
+	"sync"
+	"time"
 
+	"github.com/jrivets/gorivets"
 	"github.com/jrivets/log4g"
 	"github.com/pixty/console/common"
 	"github.com/pixty/console/model"
@@ -20,6 +23,11 @@ type (
 		GetOrgDesc(aCtx auth.Context, orgId int64) (*OrgDesc, error)
 		// Get organization descriptors for the authenticated user
 		GetOrgDescs(aCtx auth.Context) ([]*OrgDesc, error)
+		// Sets the organization privacy policy, one of model.ORG_PRIVACY_ values
+		SetOrgPrivacy(orgId int64, privacy string) error
+		// Returns the organization privacy policy. The value is cached for
+		// a short time, so it is cheap to call it for every image request
+		GetOrgPrivacy(orgId int64) (string, error)
 		InsertNewFields(orgId int64, fis []*model.FieldInfo) error
 		GetFieldInfos(orgId int64) ([]*model.FieldInfo, error)
 		UpdateFieldInfo(fi *model.FieldInfo) error
@@ -43,6 +51,16 @@ type (
 		GetAllCameras(orgId int64) ([]*model.Camera, error)
 		NewCamera(mcam *model.Camera) (int64, error)
 		NewCameraKey(camId int64) (*model.Camera, string, error)
+		// Sets the camera zones of interest, nil or empty zones mean the whole frame
+		SetCameraZones(camId int64, zones model.CamZones) error
+		// Sets the camera ingest settings, nil removes them
+		SetCameraIngest(camId int64, ingest *model.CamIngest) error
+		// Sets the camera frame processor config. The config version is
+		// assigned, it is increased on every change.
+		SetCameraConfig(camId int64, cfg *model.CamConfig) error
+		// Returns the camera with its zones and ingest settings. The camera
+		// is cached for a while and it must not be changed.
+		GetCachedCamera(camId int64) (*model.Camera, error)
 
 		// Profiles
 		InsertProfile(prf *model.Profile) (int64, error)
@@ -57,6 +75,11 @@ type (
 		UpdatePerson(mp *model.Person) error
 		DeletePerson(aCtx auth.Context, personId string) error
 		DeletePersonFaces(aCtx auth.Context, personId string, faceIds []string) error
+
+		// Frames
+		// Returns faces found on the frame image with their persons and
+		// profiles. The caller is responsible for the frame access check
+		DescribeFrame(imgId string) (*FrameDesc, error)
 	}
 
 	OrgDesc struct {
@@ -74,15 +97,34 @@ type (
 		Profiles map[int64]*model.Profile
 	}
 
+	FrameDesc struct {
+		ImageId string
+		Faces   []*model.Face
+		// personId -> Person
+		Persons map[string]*model.Person
+		// Profiles assigned to the persons
+		Profiles map[int64]*model.Profile
+	}
+
 	dta_controller struct {
 		Persister    model.Persister     `inject:"persister"`
 		ImageService *image.ImageService `inject:""`
 		logger       log4g.Logger
+		// orgId -> privacy policy
+		prvCache gorivets.LRU
+		prvLock  sync.Mutex
+		// camId -> camera with zones and ingest settings
+		camCache gorivets.LRU
+		camLock  sync.Mutex
 	}
 )
 
 const (
 	cOrgMaxFieldsCount = 20
+	cOrgPrivacyTTL     = time.Minute
+	cCameraTTL         = time.Minute
+	cCamMaxZones       = 16
+	cCamZoneMaxPoints  = 64
 )
 
 var camIdRegexp = regexp.MustCompile(`^[a-zA-Z]{1}([0-9a-zA-Z-_]+){2,39}$`)
@@ -91,6 +133,8 @@ var loginRegexp = regexp.MustCompile(`^[a-zA-Z]{1}([0-9a-zA-Z-_]+){2,39}$`)
 func NewDataController() DataController {
 	dc := new(dta_controller)
 	dc.logger = log4g.GetLogger("pixty.DataController")
+	dc.prvCache = gorivets.NewTtlLRU(1000, cOrgPrivacyTTL, nil)
+	dc.camCache = gorivets.NewTtlLRU(10000, cCameraTTL, nil)
 	return dc
 }
 
@@ -109,6 +153,60 @@ func (dc *dta_controller) InsertOrg(org *model.Organization) (int64, error) {
 	return mmp.InsertOrg(org)
 }
 
+func (dc *dta_controller) SetOrgPrivacy(orgId int64, privacy string) error {
+	if privacy != model.ORG_PRIVACY_NONE && privacy != model.ORG_PRIVACY_BLUR {
+		return common.NewError(common.ERR_INVALID_VAL, "Unknown privacy policy \""+privacy+"\", expected \"\" or \""+model.ORG_PRIVACY_BLUR+"\"")
+	}
+	mmp, err := dc.Persister.GetMainTx()
+	if err != nil {
+		return err
+	}
+	err = mmp.Begin()
+	if err != nil {
+		return err
+	}
+	defer mmp.Commit()
+
+	if _, err = mmp.GetOrgById(orgId); err != nil {
+		return err
+	}
+	err = mmp.UpdateOrgPrivacy(orgId, privacy)
+	if err != nil {
+		mmp.Rollback()
+		return err
+	}
+	dc.logger.Info("Privacy policy for orgId=", orgId, " is set to \"", privacy, "\"")
+
+	dc.prvLock.Lock()
+	dc.prvCache.Delete(orgId)
+	dc.prvLock.Unlock()
+	return nil
+}
+
+func (dc *dta_controller) GetOrgPrivacy(orgId int64) (string, error) {
+	dc.prvLock.Lock()
+	dc.prvCache.Sweep()
+	prv, ok := dc.prvCache.Get(orgId)
+	dc.prvLock.Unlock()
+	if ok {
+		return prv.(string), nil
+	}
+
+	mmp, err := dc.Persister.GetMainTx()
+	if err != nil {
+		return model.ORG_PRIVACY_NONE, err
+	}
+	org, err := mmp.GetOrgById(orgId)
+	if err != nil {
+		return model.ORG_PRIVACY_NONE, err
+	}
+
+	dc.prvLock.Lock()
+	dc.prvCache.Add(orgId, org.Privacy, 1)
+	dc.prvLock.Unlock()
+	return org.Privacy, nil
+}
+
 func (dc *dta_controller) GetOrgDesc(aCtx auth.Context, orgId int64) (*OrgDesc, error) {
 	mmp, err := dc.Persister.GetMainTx()
 	if err != nil {
@@ -304,6 +402,14 @@ func (dc *dta_controller) GetAllCameras(orgId int64) ([]*model.Camera, error) {
 }
 
 func (dc *dta_controller) NewCamera(cam *model.Camera) (int64, error) {
+	if err := checkCamZones(cam.Zones); err != nil {
+		return -1, err
+	}
+	if cam.Ingest != nil {
+		if err := cam.Ingest.Prepare(); err != nil {
+			return -1, err
+		}
+	}
 	mpp, err := dc.Persister.GetPartitionTx("FAKE")
 	if err != nil {
 		return -1, err
@@ -334,6 +440,120 @@ func (dc *dta_controller) NewCameraKey(camId int64) (*model.Camera, string, erro
 	return cam, sk, nil
 }
 
+func (dc *dta_controller) SetCameraZones(camId int64, zones model.CamZones) error {
+	if err := checkCamZones(zones); err != nil {
+		return err
+	}
+	err := dc.updateCamera(camId, func(cam *model.Camera) { cam.Zones = zones })
+	if err == nil {
+		dc.logger.Info("Set ", len(zones), " zones for camId=", camId)
+	}
+	return err
+}
+
+func (dc *dta_controller) SetCameraIngest(camId int64, ingest *model.CamIngest) error {
+	if ingest != nil {
+		if err := ingest.Prepare(); err != nil {
+			return err
+		}
+	}
+	err := dc.updateCamera(camId, func(cam *model.Camera) { cam.Ingest = ingest })
+	if err == nil {
+		dc.logger.Info("Set ingest settings for camId=", camId)
+	}
+	return err
+}
+
+func (dc *dta_controller) SetCameraConfig(camId int64, cfg *model.CamConfig) error {
+	if err := cfg.Check(); err != nil {
+		return err
+	}
+	err := dc.updateCamera(camId, func(cam *model.Camera) {
+		cfg.Version = 1
+		if cam.Config != nil {
+			cfg.Version = cam.Config.Version + 1
+		}
+		cam.Config = cfg
+	})
+	if err == nil {
+		dc.logger.Info("Set config version=", cfg.Version, " for camId=", camId)
+	}
+	return err
+}
+
+func (dc *dta_controller) GetCachedCamera(camId int64) (*model.Camera, error) {
+	dc.camLock.Lock()
+	dc.camCache.Sweep()
+	cam, ok := dc.camCache.Get(camId)
+	dc.camLock.Unlock()
+	if ok {
+		return cam.(*model.Camera), nil
+	}
+
+	mpp, err := dc.Persister.GetPartitionTx("FAKE")
+	if err != nil {
+		return nil, err
+	}
+	mcam, err := mpp.GetCameraById(camId)
+	if err != nil {
+		return nil, err
+	}
+
+	dc.camLock.Lock()
+	dc.camCache.Add(camId, mcam, 1)
+	dc.camLock.Unlock()
+	return mcam, nil
+}
+
+// reads the camera, applies the change and stores it back. The camera record
+// is locked till the commit, so concurrent changes (like the config version
+// increment) are applied one after another.
+func (dc *dta_controller) updateCamera(camId int64, change func(cam *model.Camera)) error {
+	mpp, err := dc.Persister.GetPartitionTx("FAKE")
+	if err != nil {
+		return err
+	}
+	err = mpp.Begin()
+	if err != nil {
+		return err
+	}
+	defer mpp.Commit()
+
+	cam, err := mpp.GetCameraByIdForUpdate(camId)
+	if err != nil {
+		return err
+	}
+	change(cam)
+	err = mpp.UpdateCamera(cam)
+	if err != nil {
+		mpp.Rollback()
+		return err
+	}
+
+	dc.camLock.Lock()
+	dc.camCache.Delete(camId)
+	dc.camLock.Unlock()
+	return nil
+}
+
+func checkCamZones(zones model.CamZones) error {
+	if len(zones) > cCamMaxZones {
+		return common.NewError(common.ERR_INVALID_VAL, "Too many zones, no more than "+strconv.Itoa(cCamMaxZones)+" are allowed.")
+	}
+	for i, z := range zones {
+		if z == nil || len(z.Points) < 3 || len(z.Points) > cCamZoneMaxPoints {
+			return common.NewError(common.ERR_INVALID_VAL, "Zone #"+strconv.Itoa(i)+" should be a polygon with 3 to "+
+				strconv.Itoa(cCamZoneMaxPoints)+" points.")
+		}
+		for _, p := range z.Points {
+			if p.X < 0 || p.Y < 0 {
+				return common.NewError(common.ERR_INVALID_VAL, "Zone #"+strconv.Itoa(i)+" has negative coordinates.")
+			}
+		}
+	}
+	return nil
+}
+
 func (dc *dta_controller) InsertProfile(prf *model.Profile) (int64, error) {
 	mpp, err := dc.Persister.GetPartitionTx("FAKE")
 	if err != nil {
@@ -345,6 +565,12 @@ func (dc *dta_controller) InsertProfile(prf *model.Profile) (int64, error) {
 		dc.logger.Warn("Inserting profile with unknown pictureId=", prf.PictureId)
 		return -1, err
 	}
+	if prf.PictureId != "" {
+		err = dc.ImageService.KeepFaceImage(prf.PictureId)
+		if err != nil {
+			return -1, err
+		}
+	}
 
 	err = mpp.Begin()
 	if err != nil {
@@ -456,6 +682,13 @@ func (dc *dta_controller) UpdateProfile(prf *model.Profile) error {
 		return common.NewError(common.ERR_NOT_FOUND, "Could not find profile by id="+strconv.FormatInt(prf.Id, 10))
 	}
 
+	if prf.PictureId != "" && prf.PictureId != p.PictureId {
+		err = dc.ImageService.KeepFaceImage(prf.PictureId)
+		if err != nil {
+			return err
+		}
+	}
+
 	// Delete all profile metas and keep eye on rollbacking the transaction in case of error
 	err = mpp.DeleteAllProfileMetas(prf.Id)
 	if err != nil {
@@ -646,6 +879,54 @@ func (dc *dta_controller) DescribePersonsByProfile(aCtx auth.Context, prfId int6
 	return res, nil
 }
 
+func (dc *dta_controller) DescribeFrame(imgId string) (*FrameDesc, error) {
+	pp, err := dc.Persister.GetPartitionTx("FAKE")
+	if err != nil {
+		return nil, err
+	}
+	//transaction
+	err = pp.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer pp.Commit()
+
+	res := &FrameDesc{ImageId: imgId, Persons: make(map[string]*model.Person), Profiles: make(map[int64]*model.Profile)}
+	res.Faces, err = pp.FindFaces(&model.FacesQuery{ImageId: imgId, Short: true})
+	if err != nil || len(res.Faces) == 0 {
+		return res, err
+	}
+
+	prsIds := make([]string, len(res.Faces))
+	for i, f := range res.Faces {
+		prsIds[i] = f.PersonId
+	}
+	pers, err := pp.FindPersons(&model.PersonsQuery{PersonIds: prsIds})
+	if err != nil {
+		return res, err
+	}
+
+	prfIds := make([]int64, 0, len(pers))
+	for _, p := range pers {
+		res.Persons[p.Id] = p
+		if p.ProfileId > 0 {
+			prfIds = append(prfIds, p.ProfileId)
+		}
+	}
+	if len(prfIds) == 0 {
+		return res, nil
+	}
+
+	profs, err := pp.GetProfiles(&model.ProfileQuery{ProfileIds: prfIds})
+	if err != nil {
+		return res, err
+	}
+	for _, p := range profs {
+		res.Profiles[p.Id] = p
+	}
+	return res, nil
+}
+
 func (dc *dta_controller) UpdatePerson(mp *model.Person) error {
 	dc.logger.Debug("UpdatePerson(): person=", mp)
 	pp, err := dc.Persister.GetPartitionTx("FAKE")
@@ -676,6 +957,12 @@ func (dc *dta_controller) UpdatePerson(mp *model.Person) error {
 		dc.logger.Warn("UpdatePerson(): Unknown pictureId=", mp.PictureId)
 		return err
 	}
+	if mp.PictureId != "" {
+		err = dc.ImageService.KeepFaceImage(mp.PictureId)
+		if err != nil {
+			return err
+		}
+	}
 
 	p, err := pp.GetPersonById(mp.Id)
 	if err != nil {
@@ -0,0 +1,334 @@
+package fpcp_serv
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/jrivets/log4g"
+	"github.com/pixty/console/common"
+	"github.com/pixty/console/common/fpcp"
+	"github.com/pixty/console/model"
+)
+
+const (
+	// Camera commands
+	CAM_CMD_SNAPSHOT       = "snapshot"
+	CAM_CMD_REAUTHENTICATE = "reauthenticate"
+	CAM_CMD_RESTART        = "restart"
+
+	// Camera command statuses
+	// the command waits for the camera control stream
+	CAM_CMD_ST_PENDING = "pending"
+	CAM_CMD_ST_SENT    = "sent"
+	CAM_CMD_ST_DONE    = "done"
+	CAM_CMD_ST_FAILED  = "failed"
+	// the camera didn't open the control stream in cCamCmdTTL
+	CAM_CMD_ST_EXPIRED = "expired"
+
+	cCamCmdTTL = time.Minute
+	// the sent command, which is not acknowledged in the time, is failed
+	cCamCmdAckTo = time.Minute
+	// the last commands kept per camera
+	cCamMaxCmds = 20
+	// messages waiting for sending to the control stream
+	cCtrlQueueSize = 32
+)
+
+var camCmdTypes = map[string]fpcp.CamCommand_Type{
+	CAM_CMD_SNAPSHOT:       fpcp.CamCommand_SNAPSHOT,
+	CAM_CMD_REAUTHENTICATE: fpcp.CamCommand_REAUTHENTICATE,
+	CAM_CMD_RESTART:        fpcp.CamCommand_RESTART,
+}
+
+type (
+	// CamControl keeps the cameras control streams and sends the config and
+	// the commands to the cameras
+	CamControl interface {
+		// Sends the config to the camera if its control stream is open. The
+		// camera gets the stored config anyway when it opens the stream.
+		PushConfig(camId int64, cfg *model.CamConfig)
+		// Sends the command to the camera. If the camera control stream is
+		// not open, the command waits for it cCamCmdTTL. The sent command
+		// is failed if the camera doesn't acknowledge it in cCamCmdAckTo.
+		// Returns ERR_INVALID_VAL if the command is unknown.
+		SendCommand(camId int64, cmd string) (*CamCommand, error)
+		// Returns the last commands sent to the camera, the newest first
+		GetCommands(camId int64) []*CamCommand
+		// Returns the camera control stream state
+		GetState(camId int64) *CamCtrlState
+		// Called by FPCP server, serves the camera control stream until it
+		// is closed or replaced by a new one. cfg is the stored camera config.
+		Serve(camId int64, cfg *model.CamConfig, stream fpcp.SceneProcessorService_ControlServer) error
+	}
+
+	CamCommand struct {
+		Id     string
+		CamId  int64
+		Cmd    string
+		Status string
+		// the camera error description, when the command is failed
+		Error     string
+		CreatedAt time.Time
+		UpdatedAt time.Time
+	}
+
+	CamCtrlState struct {
+		CamId int64
+		// the control stream is open
+		Connected bool
+		// the config version applied by the camera and the camera error
+		// description, when it could not apply the config
+		AppliedVersion uint64
+		ApplyError     string
+	}
+
+	cam_control struct {
+		lock   sync.Mutex
+		cams   map[int64]*cam_ctrl
+		logger log4g.Logger
+	}
+
+	cam_ctrl struct {
+		st CamCtrlState
+		// the open control stream, nil if no one
+		strm *ctrl_stream
+		// the newest last
+		cmds []*CamCommand
+	}
+
+	ctrl_stream struct {
+		out chan *fpcp.ControlMsg
+		// closed when the stream is replaced by a new one
+		done chan struct{}
+	}
+)
+
+func NewCamControl() CamControl {
+	cc := new(cam_control)
+	cc.cams = make(map[int64]*cam_ctrl)
+	cc.logger = log4g.GetLogger("pixty.CamControl")
+	return cc
+}
+
+// ============================== CamControl =================================
+func (cc *cam_control) PushConfig(camId int64, cfg *model.CamConfig) {
+	cc.lock.Lock()
+	defer cc.lock.Unlock()
+
+	ctrl := cc.getCamCtrl(camId)
+	if ctrl.strm == nil {
+		cc.logger.Info("camId=", camId, " has no control stream, it gets config version=", cfg.Version, " when connects")
+		return
+	}
+	if !ctrl.strm.send(toControlConfig(cfg)) {
+		cc.logger.Warn("Could not send config version=", cfg.Version, " to camId=", camId, ", the control stream is overloaded")
+	}
+}
+
+func (cc *cam_control) SendCommand(camId int64, cmd string) (*CamCommand, error) {
+	if _, ok := camCmdTypes[cmd]; !ok {
+		return nil, common.NewError(common.ERR_INVALID_VAL, "Unknown camera command \""+cmd+"\"")
+	}
+
+	now := time.Now()
+	cmnd := &CamCommand{Id: common.NewUUID(), CamId: camId, Cmd: cmd, Status: CAM_CMD_ST_PENDING, CreatedAt: now, UpdatedAt: now}
+	cc.lock.Lock()
+	defer cc.lock.Unlock()
+
+	ctrl := cc.getCamCtrl(camId)
+	ctrl.cmds = append(ctrl.cmds, cmnd)
+	if len(ctrl.cmds) > cCamMaxCmds {
+		ctrl.cmds[0] = nil
+		ctrl.cmds = ctrl.cmds[1:]
+	}
+	if ctrl.strm != nil {
+		cc.sendCommand(ctrl.strm, cmnd, now)
+	}
+	cc.logger.Info("New command ", cmnd)
+	res := *cmnd
+	return &res, nil
+}
+
+func (cc *cam_control) GetCommands(camId int64) []*CamCommand {
+	cc.lock.Lock()
+	defer cc.lock.Unlock()
+
+	ctrl := cc.getCamCtrl(camId)
+	ctrl.expireCommands(time.Now())
+	res := make([]*CamCommand, len(ctrl.cmds))
+	for i, cmnd := range ctrl.cmds {
+		c := *cmnd
+		res[len(res)-i-1] = &c
+	}
+	return res
+}
+
+func (cc *cam_control) GetState(camId int64) *CamCtrlState {
+	cc.lock.Lock()
+	defer cc.lock.Unlock()
+	st := cc.getCamCtrl(camId).st
+	return &st
+}
+
+func (cc *cam_control) Serve(camId int64, cfg *model.CamConfig, stream fpcp.SceneProcessorService_ControlServer) error {
+	cs := &ctrl_stream{out: make(chan *fpcp.ControlMsg, cCtrlQueueSize), done: make(chan struct{})}
+	cc.openStream(camId, cfg, cs)
+	defer cc.closeStream(camId, cs)
+
+	acks := make(chan error, 1)
+	go func() {
+		for {
+			ack, err := stream.Recv()
+			if err != nil {
+				acks <- err
+				return
+			}
+			cc.onAck(camId, ack)
+		}
+	}()
+
+	for {
+		select {
+		case msg := <-cs.out:
+			if err := stream.Send(msg); err != nil {
+				return err
+			}
+		case err := <-acks:
+			if err == io.EOF {
+				return nil
+			}
+			return err
+		case <-cs.done:
+			cc.logger.Info("The control stream of camId=", camId, " is replaced by new one")
+			return nil
+		}
+	}
+}
+
+// ------------------------------- Private -----------------------------------
+func (cc *cam_control) getCamCtrl(camId int64) *cam_ctrl {
+	ctrl, ok := cc.cams[camId]
+	if !ok {
+		ctrl = &cam_ctrl{st: CamCtrlState{CamId: camId}}
+		cc.cams[camId] = ctrl
+	}
+	return ctrl
+}
+
+// registers the stream and puts the config and the pending commands to it
+func (cc *cam_control) openStream(camId int64, cfg *model.CamConfig, cs *ctrl_stream) {
+	cc.lock.Lock()
+	defer cc.lock.Unlock()
+
+	ctrl := cc.getCamCtrl(camId)
+	if ctrl.strm != nil {
+		close(ctrl.strm.done)
+	}
+	ctrl.strm = cs
+	ctrl.st.Connected = true
+	if cfg != nil {
+		cs.send(toControlConfig(cfg))
+	}
+
+	now := time.Now()
+	ctrl.expireCommands(now)
+	for _, cmnd := range ctrl.cmds {
+		if cmnd.Status == CAM_CMD_ST_PENDING {
+			cc.sendCommand(cs, cmnd, now)
+		}
+	}
+}
+
+func (cc *cam_control) closeStream(camId int64, cs *ctrl_stream) {
+	cc.lock.Lock()
+	defer cc.lock.Unlock()
+
+	ctrl := cc.getCamCtrl(camId)
+	if ctrl.strm == cs {
+		ctrl.strm = nil
+		ctrl.st.Connected = false
+	}
+}
+
+func (cc *cam_control) sendCommand(cs *ctrl_stream, cmnd *CamCommand, now time.Time) {
+	msg := &fpcp.ControlMsg{Command: &fpcp.CamCommand{Id: cmnd.Id, Type: camCmdTypes[cmnd.Cmd]}}
+	cmnd.UpdatedAt = now
+	if cs.send(msg) {
+		cmnd.Status = CAM_CMD_ST_SENT
+		return
+	}
+	cmnd.Status = CAM_CMD_ST_FAILED
+	cmnd.Error = "The control stream is overloaded"
+}
+
+func (cc *cam_control) onAck(camId int64, ack *fpcp.ControlAck) {
+	cc.lock.Lock()
+	defer cc.lock.Unlock()
+
+	ctrl := cc.getCamCtrl(camId)
+	if ack.CommandId == "" {
+		ctrl.st.AppliedVersion = ack.ConfigVersion
+		ctrl.st.ApplyError = ack.Error
+		if ack.Error != "" {
+			cc.logger.Warn("camId=", camId, " could not apply config version=", ack.ConfigVersion, ", err=", ack.Error)
+		} else {
+			cc.logger.Info("camId=", camId, " applied config version=", ack.ConfigVersion)
+		}
+		return
+	}
+
+	for _, cmnd := range ctrl.cmds {
+		if cmnd.Id != ack.CommandId {
+			continue
+		}
+		cmnd.Status = CAM_CMD_ST_DONE
+		if ack.Error != "" {
+			cmnd.Status = CAM_CMD_ST_FAILED
+			cmnd.Error = ack.Error
+		}
+		cmnd.UpdatedAt = time.Now()
+		cc.logger.Info("camId=", camId, " acknowledged command ", cmnd)
+		return
+	}
+	cc.logger.Warn("camId=", camId, " acknowledged unknown command id=", ack.CommandId)
+}
+
+func (ctrl *cam_ctrl) expireCommands(now time.Time) {
+	for _, cmnd := range ctrl.cmds {
+		switch {
+		case cmnd.Status == CAM_CMD_ST_PENDING && now.Sub(cmnd.CreatedAt) > cCamCmdTTL:
+			cmnd.Status = CAM_CMD_ST_EXPIRED
+			cmnd.UpdatedAt = now
+		case cmnd.Status == CAM_CMD_ST_SENT && now.Sub(cmnd.UpdatedAt) > cCamCmdAckTo:
+			cmnd.Status = CAM_CMD_ST_FAILED
+			cmnd.Error = "The camera didn't acknowledge the command"
+			cmnd.UpdatedAt = now
+		}
+	}
+}
+
+// puts the message to the stream queue, returns false if the queue is full
+func (cs *ctrl_stream) send(msg *fpcp.ControlMsg) bool {
+	select {
+	case cs.out <- msg:
+		return true
+	default:
+		return false
+	}
+}
+
+func toControlConfig(cfg *model.CamConfig) *fpcp.ControlMsg {
+	return &fpcp.ControlMsg{Config: &fpcp.CamConfig{
+		Version:         cfg.Version,
+		FrameRate:       int32(cfg.FrameRate),
+		FrameSize:       &fpcp.Size{Width: uint32(cfg.FrameWidth), Height: uint32(cfg.FrameHeight)},
+		FaceSize:        &fpcp.Size{Width: uint32(cfg.FaceWidth), Height: uint32(cfg.FaceHeight)},
+		DetectThreshold: cfg.DetectThreshold,
+	}}
+}
+
+func (c *CamCommand) String() string {
+	return fmt.Sprint("{id=", c.Id, ", camId=", c.CamId, ", cmd=", c.Cmd, ", status=", c.Status, "}")
+}
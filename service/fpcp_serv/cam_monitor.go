@@ -0,0 +1,246 @@
+package fpcp_serv
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/jrivets/gorivets"
+	"github.com/jrivets/log4g"
+	"github.com/pixty/console/common"
+	"golang.org/x/net/context"
+)
+
+const (
+	// the rates are counted for the last minute in the buckets
+	cRateBuckets   = 6
+	cRateBucketSec = 10
+	// how often the cameras are checked for going offline
+	cCamCheckTo = 5 * time.Second
+)
+
+type (
+	// CamMonitor keeps the cameras status built from the FPCP traffic. The
+	// online and offline transitions are reported to the log only, there
+	// are no subscribers to notify.
+	CamMonitor interface {
+		// Returns the camera status. The camera, which was not seen since the
+		// console start, is offline and has zero times.
+		GetStatus(camId int64) *CamStatus
+		// Called by FPCP server on the camera authentication. The failed
+		// attempts are counted only for the cameras, which were authenticated
+		// since the console start, so random access keys don't grow the state.
+		OnAuthenticate(camId int64, success bool)
+		// Called by FPCP server when a scene is received, err is the reason
+		// the scene is rejected
+		OnScene(camId int64, faces int, err error)
+	}
+
+	CamStatus struct {
+		CamId  int64
+		Online bool
+		// when the camera went online or offline last time
+		Since          time.Time
+		LastAuthAt     time.Time
+		LastSceneAt    time.Time
+		ScenesPerMin   int
+		FacesPerMin    int
+		AuthErrors     uint64
+		RejectedScenes uint64
+	}
+
+	cam_monitor struct {
+		CConfig *common.ConsoleConfig `inject:""`
+		MainCtx context.Context       `inject:"mainCtx"`
+		lock    sync.Mutex
+		cams    map[int64]*cam_state
+		logger  log4g.Logger
+	}
+
+	cam_state struct {
+		st     CamStatus
+		scenes rate_counter
+		faces  rate_counter
+	}
+
+	// rate_counter counts events for the last minute
+	rate_counter struct {
+		buckets [cRateBuckets]int
+		// the last bucket number: unix time / cRateBucketSec
+		last int64
+	}
+)
+
+func NewCamMonitor() CamMonitor {
+	cm := new(cam_monitor)
+	cm.cams = make(map[int64]*cam_state)
+	return cm
+}
+
+// ========================== PostConstructor ================================
+func (cm *cam_monitor) DiPostConstruct() {
+	cm.logger = log4g.GetLogger("pixty.CamMonitor")
+	cm.logger.Info("Post construct, cameras go offline after ", cm.CConfig.CamOfflineToSec, " seconds of silence")
+
+	go func() {
+		cm.logger.Info("Entering cameras check routine.")
+		for {
+			select {
+			case <-cm.MainCtx.Done():
+				cm.logger.Info("Leaving cameras check routine.")
+				return
+			case <-time.After(cCamCheckTo):
+				err := gorivets.CheckPanic(func() { cm.checkOffline(time.Now()) })
+				if err != nil {
+					cm.logger.Error("Got the panic in checking cameras: ", err)
+				}
+			}
+		}
+	}()
+}
+
+// ============================== CamMonitor =================================
+func (cm *cam_monitor) GetStatus(camId int64) *CamStatus {
+	cm.lock.Lock()
+	defer cm.lock.Unlock()
+
+	cs, ok := cm.cams[camId]
+	if !ok {
+		return &CamStatus{CamId: camId}
+	}
+	now := time.Now()
+	st := cs.st
+	st.ScenesPerMin = cs.scenes.per_minute(now)
+	st.FacesPerMin = cs.faces.per_minute(now)
+	return &st
+}
+
+func (cm *cam_monitor) OnAuthenticate(camId int64, success bool) {
+	cm.lock.Lock()
+	if !success {
+		if cs, ok := cm.cams[camId]; ok {
+			cs.st.AuthErrors++
+		}
+		cm.lock.Unlock()
+		return
+	}
+	cs := cm.getCamState(camId)
+	now := time.Now()
+	cs.st.LastAuthAt = now
+	st := cm.setOnline(cs, now)
+	cm.lock.Unlock()
+	cm.logChange(st)
+}
+
+func (cm *cam_monitor) OnScene(camId int64, faces int, err error) {
+	cm.lock.Lock()
+	cs := cm.getCamState(camId)
+	now := time.Now()
+	cs.st.LastSceneAt = now
+	if err != nil {
+		cs.st.RejectedScenes++
+	} else {
+		cs.scenes.add(now, 1)
+		cs.faces.add(now, faces)
+	}
+	st := cm.setOnline(cs, now)
+	cm.lock.Unlock()
+	cm.logChange(st)
+}
+
+// ------------------------------- Private -----------------------------------
+func (cm *cam_monitor) getCamState(camId int64) *cam_state {
+	cs, ok := cm.cams[camId]
+	if !ok {
+		cs = &cam_state{st: CamStatus{CamId: camId}}
+		cm.cams[camId] = cs
+	}
+	return cs
+}
+
+// returns the status copy if the camera comes online, nil otherwise
+func (cm *cam_monitor) setOnline(cs *cam_state, now time.Time) *CamStatus {
+	if cs.st.Online {
+		return nil
+	}
+	cs.st.Online = true
+	cs.st.Since = now
+	st := cs.st
+	return &st
+}
+
+func (cm *cam_monitor) checkOffline(now time.Time) {
+	to := time.Duration(cm.CConfig.CamOfflineToSec) * time.Second
+	cm.lock.Lock()
+	offline := make([]*CamStatus, 0, 1)
+	for _, cs := range cm.cams {
+		if !cs.st.Online || now.Sub(cs.lastSeenAt()) < to {
+			continue
+		}
+		cs.st.Online = false
+		cs.st.Since = now
+		st := cs.st
+		offline = append(offline, &st)
+	}
+	cm.lock.Unlock()
+
+	for _, st := range offline {
+		cm.logChange(st)
+	}
+}
+
+// The transition event. It is written to the log "pixty.CamMonitor" only, the
+// offline ones with the WARN level, so they could be alerted on by the log
+// monitoring.
+func (cm *cam_monitor) logChange(st *CamStatus) {
+	if st == nil {
+		return
+	}
+	if st.Online {
+		cm.logger.Info("Camera camId=", st.CamId, " is online")
+	} else {
+		cm.logger.Warn("Camera camId=", st.CamId, " went offline, last scene at ", st.LastSceneAt, ", last authentication at ", st.LastAuthAt)
+	}
+}
+
+func (cs *cam_state) lastSeenAt() time.Time {
+	if cs.st.LastAuthAt.After(cs.st.LastSceneAt) {
+		return cs.st.LastAuthAt
+	}
+	return cs.st.LastSceneAt
+}
+
+func (rc *rate_counter) add(now time.Time, n int) {
+	rc.advance(now)
+	rc.buckets[rc.last%cRateBuckets] += n
+}
+
+func (rc *rate_counter) per_minute(now time.Time) int {
+	rc.advance(now)
+	res := 0
+	for _, n := range rc.buckets {
+		res += n
+	}
+	return res
+}
+
+// clears the buckets passed since the last one
+func (rc *rate_counter) advance(now time.Time) {
+	b := now.Unix() / cRateBucketSec
+	if b <= rc.last {
+		return
+	}
+	if b-rc.last >= cRateBuckets {
+		rc.buckets = [cRateBuckets]int{}
+	} else {
+		for i := rc.last + 1; i <= b; i++ {
+			rc.buckets[i%cRateBuckets] = 0
+		}
+	}
+	rc.last = b
+}
+
+func (st *CamStatus) String() string {
+	return fmt.Sprint("{camId=", st.CamId, ", online=", st.Online, ", since=", st.Since, ", lastAuthAt=", st.LastAuthAt,
+		", lastSceneAt=", st.LastSceneAt, ", authErrors=", st.AuthErrors, ", rejectedScenes=", st.RejectedScenes, "}")
+}
@@ -0,0 +1,77 @@
+package fpcp_serv
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/jrivets/log4g"
+	"github.com/pixty/console/common"
+)
+
+func TestRateCounter(t *testing.T) {
+	start := time.Unix(1000*cRateBucketSec, 0)
+	at := func(sec int) time.Time {
+		return start.Add(time.Duration(sec) * time.Second)
+	}
+
+	var rc rate_counter
+	tests := []struct {
+		at  time.Time
+		add int
+		res int
+	}{
+		{at(0), 1, 1},
+		{at(1), 2, 3},
+		{at(cRateBucketSec), 1, 4},
+		{at(30), 0, 4},
+		// the first bucket goes away
+		{at(60), 0, 1},
+		{at(65), 5, 6},
+		{at(71), 0, 5},
+		// going back in time doesn't clear anything
+		{at(10), 1, 6},
+		// a long pause clears everything
+		{at(1000), 0, 0},
+		{at(1001), 2, 2},
+	}
+	for i, tst := range tests {
+		if tst.add > 0 {
+			rc.add(tst.at, tst.add)
+		}
+		if pm := rc.per_minute(tst.at); pm != tst.res {
+			t.Fatal("Test #", i, ": expecting ", tst.res, " per minute, but ", pm)
+		}
+	}
+}
+
+func TestCamMonitorStatus(t *testing.T) {
+	cm := NewCamMonitor().(*cam_monitor)
+	cm.CConfig = common.NewConsoleConfig()
+	cm.logger = log4g.GetLogger("pixty.test")
+
+	cm.OnAuthenticate(1, false)
+	if st := cm.GetStatus(1); st.Online || st.AuthErrors != 0 || len(cm.cams) != 0 {
+		t.Fatal("Unknown camera failed authentication must not be kept ", st)
+	}
+
+	cm.OnAuthenticate(1, true)
+	cm.OnAuthenticate(1, false)
+	cm.OnScene(1, 3, nil)
+	cm.OnScene(1, 2, nil)
+	cm.OnScene(1, 0, errors.New("rejected"))
+	st := cm.GetStatus(1)
+	if !st.Online || st.AuthErrors != 1 || st.RejectedScenes != 1 || st.ScenesPerMin != 2 || st.FacesPerMin != 5 {
+		t.Fatal("Wrong status ", st)
+	}
+
+	to := time.Duration(cm.CConfig.CamOfflineToSec) * time.Second
+	cm.checkOffline(time.Now().Add(to / 2))
+	if !cm.GetStatus(1).Online {
+		t.Fatal("The camera must be online")
+	}
+	cm.checkOffline(time.Now().Add(2 * to))
+	if cm.GetStatus(1).Online {
+		t.Fatal("The camera must be offline")
+	}
+}
@@ -2,9 +2,11 @@ package fpcp_serv
 
 import (
 	"errors"
+	"io"
 	"net"
 	"strconv"
 	"sync"
+	"time"
 
 	"github.com/jrivets/gorivets"
 	"github.com/jrivets/log4g"
@@ -27,6 +29,11 @@ const (
 	mtErrVal_UnknonwSess = "1" //Unknown session id
 	mtErrVal_AuthFailed  = "2" //Unknown credentials
 	mtErrVal_UnableNow   = "3" //Unable run now. Please try again later
+	mtErrVal_Overloaded  = "4" //The scenes queue is full. Please slow down
+	mtErrVal_WrongPacket = "5" //The packet is not properly formed
+
+	// how often the queue is checked for the client, which ran out of credits
+	cCreditsPollTo = time.Second
 )
 
 type (
@@ -35,12 +42,17 @@ type (
 		Config     *common.ConsoleConfig `inject:""`
 		Persister  model.Persister       `inject:"persister"`
 		ScnService *scene.SceneProcessor `inject:"scnProcessor"`
+		CamMonitor CamMonitor            `inject:""`
+		CamControl CamControl            `inject:""`
+		MainCtx    context.Context       `inject:"mainCtx"`
 		log        gorivets.Logger
 		sessions   gorivets.LRU     // sessId->camId
 		camId2sess map[int64]string // access keys to sess
 		listener   net.Listener
-		started    bool
-		lock       sync.Mutex
+		// nil if FPCP is plaintext
+		tls     *fpcp_tls
+		started bool
+		lock    sync.Mutex
 	}
 )
 
@@ -62,6 +74,18 @@ func (fs *FPCPServer) DiInit() error {
 	port := fs.Config.GrpcFPCPPort
 	fs.log.Info("Listening on ", port)
 
+	if fs.Config.GrpcFPCPTlsCert != "" {
+		ft, err := new_fpcp_tls(fs.Config)
+		if err != nil {
+			fs.log.Fatal("Could not set up TLS for FPCP, err=", err)
+			return err
+		}
+		fs.tls = ft
+		go ft.watch(fs.MainCtx, time.Duration(fs.Config.GrpcFPCPTlsReloadSec)*time.Second)
+	} else {
+		fs.log.Warn("FPCP is not encrypted, the cameras secrets are sent in plaintext")
+	}
+
 	lis, err := net.Listen("tcp", ":"+strconv.Itoa(port))
 	if err != nil {
 		msg := "Could not open gRPC TCP socket for listening on " + strconv.Itoa(port)
@@ -92,7 +116,11 @@ func getFirstValue(md metadata.MD, key string) string {
 func (fs *FPCPServer) run() {
 	fs.log.Info("run() called")
 	fs.started = true
-	gs := grpc.NewServer()
+	var opts []grpc.ServerOption
+	if fs.tls != nil {
+		opts = append(opts, grpc.Creds(fs.tls.transportCreds()))
+	}
+	gs := grpc.NewServer(opts...)
 	fpcp.RegisterSceneProcessorServiceServer(gs, fs)
 	// Register reflection service on gRPC server.
 	reflection.Register(gs)
@@ -139,7 +167,9 @@ func (fs *FPCPServer) checkSession(ctx context.Context) int64 {
 	return -1
 }
 
-func (fs *FPCPServer) authenticate(camId int64, authToken *fpcp.AuthToken) (string, error) {
+// byCert is true if the camera presented the verified certificate, so the
+// secret key is not checked
+func (fs *FPCPServer) authenticate(camId int64, authToken *fpcp.AuthToken, byCert bool) (string, error) {
 	mpp, err := fs.Persister.GetPartitionTx("FAKE")
 	if err != nil {
 		return "", err
@@ -150,8 +180,8 @@ func (fs *FPCPServer) authenticate(camId int64, authToken *fpcp.AuthToken) (stri
 		return "", err
 	}
 
-	if cam == nil || cam.SecretKey != common.Hash(authToken.Secret) {
-		fs.log.Info("Cannot authenticate by access_key=", authToken.Access, ", not found or wrong secret key")
+	if cam == nil || (!byCert && cam.SecretKey != common.Hash(authToken.Secret)) {
+		fs.log.Info("Cannot authenticate camId=", camId, ", not found or wrong secret key")
 		return "", nil
 	}
 
@@ -162,7 +192,7 @@ func (fs *FPCPServer) authenticate(camId int64, authToken *fpcp.AuthToken) (stri
 	if ok {
 		delete(fs.camId2sess, camId)
 		fs.sessions.Delete(oldSess)
-		fs.log.Info("When authentication by access_key=", authToken.Access, ", found old session by the key sess=", oldSess)
+		fs.log.Info("When authentication camId=", camId, ", found old session by the key sess=", oldSess)
 	}
 	fs.sessions.Add(sid, camId, 1)
 	fs.camId2sess[camId] = sid
@@ -186,20 +216,34 @@ func ak2camId(access_key string) int64 {
 
 func (fs *FPCPServer) Authenticate(ctx context.Context, authToken *fpcp.AuthToken) (*fpcp.Void, error) {
 	fs.log.Info("Got authentication request for access_key=", authToken.Access)
-	if len(authToken.Access) == 0 || len(authToken.Secret) == 0 {
+	// the verified client certificate authenticates the camera without the
+	// secret key, access_key could be omitted then
+	certCamId := peerCamId(ctx)
+	access := authToken.Access
+	if access == "" && certCamId >= 0 {
+		access = strconv.FormatInt(certCamId, 10)
+	}
+	if len(access) == 0 || (len(authToken.Secret) == 0 && certCamId < 0) {
 		fs.log.Warn("Empty credentials in authentication!")
 		setError(ctx, mtErrVal_AuthFailed)
 		return &fpcp.Void{}, nil
 	}
 
-	camId := ak2camId(authToken.Access)
+	camId := ak2camId(access)
 	if camId < 0 {
-		fs.log.Warn("Frame Proc uses not int value for the access_key=", authToken.Access, ", expecting camId which is int")
+		fs.log.Warn("Frame Proc uses not int value for the access_key=", access, ", expecting camId which is int")
 		setError(ctx, mtErrVal_AuthFailed)
 		return &fpcp.Void{}, nil
 	}
 
-	sid, err := fs.authenticate(camId, authToken)
+	if certCamId >= 0 && certCamId != camId {
+		fs.log.Warn("The access_key=", access, " doesn't match the client certificate camId=", certCamId)
+		fs.CamMonitor.OnAuthenticate(camId, false)
+		setError(ctx, mtErrVal_AuthFailed)
+		return &fpcp.Void{}, nil
+	}
+
+	sid, err := fs.authenticate(camId, authToken, certCamId == camId)
 	if err != nil {
 		fs.log.Warn("Unable authenticate. err=", err)
 		setError(ctx, mtErrVal_UnableNow)
@@ -207,12 +251,14 @@ func (fs *FPCPServer) Authenticate(ctx context.Context, authToken *fpcp.AuthToke
 	}
 
 	if sid == "" {
-		fs.log.Info("Invalid credentials for access_key=", authToken.Access)
+		fs.log.Info("Invalid credentials for access_key=", access)
+		fs.CamMonitor.OnAuthenticate(camId, false)
 		setError(ctx, mtErrVal_AuthFailed)
 		return &fpcp.Void{}, nil
 	}
+	fs.CamMonitor.OnAuthenticate(camId, true)
 
-	fs.log.Info("Assigning session_id=", sid, " for access_key=", authToken.Access)
+	fs.log.Info("Assigning session_id=", sid, " for access_key=", access)
 	trailer := metadata.Pairs(mtKeySessionId, sid)
 	grpc.SetTrailer(ctx, trailer)
 	grpc.SetHeader(ctx, trailer)
@@ -226,6 +272,128 @@ func (fs *FPCPServer) OnScene(ctx context.Context, scn *fpcp.Scene) (*fpcp.Void,
 		setError(ctx, mtErrVal_UnknonwSess)
 		return &fpcp.Void{}, nil
 	}
-	fs.ScnService.OnFPCPScene(camId, scn)
+	if ec := fs.acceptScene(camId, scn); ec != "" {
+		setError(ctx, ec)
+	}
 	return &fpcp.Void{}, nil
 }
+
+// The stream is bound to the session it is opened with. It is closed with
+// the "error" trailer when the session is dropped, for instance when the
+// camera is authenticated again.
+func (fs *FPCPServer) Scenes(stream fpcp.SceneProcessorService_ScenesServer) error {
+	ctx := stream.Context()
+	camId := fs.checkSession(ctx)
+	if camId < 0 {
+		fs.log.Warn("Unauthorized call to Scenes()")
+		stream.SetTrailer(metadata.Pairs(mtKeyError, mtErrVal_UnknonwSess))
+		return nil
+	}
+
+	fs.log.Info("Scenes stream is opened for camId=", camId)
+	reqs := make(chan *fpcp.SceneReq)
+	rErr := make(chan error, 1)
+	go func() {
+		for {
+			req, err := stream.Recv()
+			if err != nil {
+				rErr <- err
+				return
+			}
+			select {
+			case reqs <- req:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	credits := fs.ScnService.GetCamQueueFree(camId)
+	err := stream.Send(&fpcp.SceneAck{Credits: int32(credits)})
+	for err == nil {
+		// the client without credits waits for an acknowledgement, so it is
+		// sent as soon as the queue has room
+		var poll <-chan time.Time
+		if credits <= 0 {
+			poll = time.After(cCreditsPollTo)
+		}
+
+		select {
+		case req := <-reqs:
+			if fs.checkSession(ctx) != camId {
+				fs.log.Warn("The session of scenes stream for camId=", camId, " is dropped, closing the stream")
+				stream.SetTrailer(metadata.Pairs(mtKeyError, mtErrVal_UnknonwSess))
+				return stream.Send(&fpcp.SceneAck{Seq: req.Seq, Error: mtErrVal_UnknonwSess})
+			}
+
+			ack := &fpcp.SceneAck{Seq: req.Seq, Error: fs.acceptScene(camId, req.Scene)}
+			credits = fs.ScnService.GetCamQueueFree(camId)
+			ack.Credits = int32(credits)
+			err = stream.Send(ack)
+		case err = <-rErr:
+		case <-poll:
+			credits = fs.ScnService.GetCamQueueFree(camId)
+			if credits > 0 {
+				err = stream.Send(&fpcp.SceneAck{Credits: int32(credits)})
+			}
+		}
+	}
+
+	if err == io.EOF {
+		fs.log.Info("Scenes stream is closed by camId=", camId)
+		return nil
+	}
+	fs.log.Warn("Scenes stream for camId=", camId, " is broken, err=", err)
+	return err
+}
+
+func (fs *FPCPServer) Control(stream fpcp.SceneProcessorService_ControlServer) error {
+	camId := fs.checkSession(stream.Context())
+	if camId < 0 {
+		fs.log.Warn("Unauthorized call to Control()")
+		stream.SetTrailer(metadata.Pairs(mtKeyError, mtErrVal_UnknonwSess))
+		return nil
+	}
+
+	cfg, err := fs.getCameraConfig(camId)
+	if err != nil {
+		fs.log.Warn("Could not read config of camId=", camId, ", err=", err)
+		stream.SetTrailer(metadata.Pairs(mtKeyError, mtErrVal_UnableNow))
+		return nil
+	}
+
+	fs.log.Info("Control stream is opened for camId=", camId)
+	err = fs.CamControl.Serve(camId, cfg, stream)
+	fs.log.Info("Control stream is closed for camId=", camId, ", err=", err)
+	return err
+}
+
+func (fs *FPCPServer) getCameraConfig(camId int64) (*model.CamConfig, error) {
+	mpp, err := fs.Persister.GetPartitionTx("FAKE")
+	if err != nil {
+		return nil, err
+	}
+	cam, err := mpp.GetCameraById(camId)
+	if err != nil {
+		return nil, err
+	}
+	return cam.Config, nil
+}
+
+// Puts the scene to the processing queue, returns the error code or empty
+// string if the scene is accepted
+func (fs *FPCPServer) acceptScene(camId int64, scn *fpcp.Scene) string {
+	err := fs.ScnService.EnqueueScene(camId, scn)
+	fs.CamMonitor.OnScene(camId, len(scn.GetFaces()), err)
+	switch {
+	case err == nil:
+		return ""
+	case common.CheckError(err, common.ERR_LIMIT_VIOLATION):
+		return mtErrVal_Overloaded
+	case common.CheckError(err, common.ERR_INVALID_VAL):
+		return mtErrVal_WrongPacket
+	default:
+		fs.log.Warn("Could not accept scene from camId=", camId, ", err=", err)
+		return mtErrVal_UnableNow
+	}
+}
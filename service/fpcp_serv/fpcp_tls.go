@@ -0,0 +1,168 @@
+package fpcp_serv
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/jrivets/log4g"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/peer"
+
+	"github.com/pixty/console/common"
+)
+
+type (
+	// fpcp_tls keeps the FPCP server certificate and the cameras CAs. The
+	// files are reloaded when they are changed, the new TLS connections use
+	// the reloaded ones.
+	fpcp_tls struct {
+		certFile   string
+		keyFile    string
+		caFile     string
+		clientAuth tls.ClientAuthType
+		lock       sync.Mutex
+		cert       *tls.Certificate
+		cas        *x509.CertPool
+		// the loaded files sizes and modification times
+		stamp  string
+		logger log4g.Logger
+	}
+)
+
+func new_fpcp_tls(cfg *common.ConsoleConfig) (*fpcp_tls, error) {
+	ft := new(fpcp_tls)
+	ft.certFile = cfg.GrpcFPCPTlsCert
+	ft.keyFile = cfg.GrpcFPCPTlsKey
+	ft.caFile = cfg.GrpcFPCPClientCA
+	ft.logger = log4g.GetLogger("pixty.fpcp.tls")
+
+	switch cfg.GrpcFPCPClientAuth {
+	case common.FPCP_CLIENT_AUTH_NONE:
+		ft.clientAuth = tls.NoClientCert
+	case common.FPCP_CLIENT_AUTH_OPTIONAL:
+		ft.clientAuth = tls.VerifyClientCertIfGiven
+	case common.FPCP_CLIENT_AUTH_REQUIRE:
+		ft.clientAuth = tls.RequireAndVerifyClientCert
+	default:
+		return nil, errors.New("Unknown FPCP client authentication mode \"" + cfg.GrpcFPCPClientAuth + "\"")
+	}
+	if ft.clientAuth != tls.NoClientCert && ft.caFile == "" {
+		return nil, errors.New("The client CA file must be provided for the FPCP client authentication")
+	}
+
+	if _, err := ft.reload(); err != nil {
+		return nil, err
+	}
+	return ft, nil
+}
+
+// Returns the gRPC transport credentials, which use the current certificates
+func (ft *fpcp_tls) transportCreds() credentials.TransportCredentials {
+	return credentials.NewTLS(&tls.Config{GetConfigForClient: ft.getConfigForClient})
+}
+
+// Loads the files if they were changed since the last load. Returns whether
+// they were loaded. The current certificates are kept if the files could
+// not be loaded.
+func (ft *fpcp_tls) reload() (bool, error) {
+	stamp, err := ft.filesStamp()
+	if err != nil {
+		return false, err
+	}
+	ft.lock.Lock()
+	changed := stamp != ft.stamp
+	ft.lock.Unlock()
+	if !changed {
+		return false, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(ft.certFile, ft.keyFile)
+	if err != nil {
+		return false, err
+	}
+	var cas *x509.CertPool
+	if ft.caFile != "" {
+		pem, err := ioutil.ReadFile(ft.caFile)
+		if err != nil {
+			return false, err
+		}
+		cas = x509.NewCertPool()
+		if !cas.AppendCertsFromPEM(pem) {
+			return false, errors.New("No CA certificates found in " + ft.caFile)
+		}
+	}
+
+	ft.lock.Lock()
+	ft.cert = &cert
+	ft.cas = cas
+	ft.stamp = stamp
+	ft.lock.Unlock()
+	ft.logger.Info("Loaded certificate ", ft.certFile, ", client CAs ", ft.caFile)
+	return true, nil
+}
+
+// Checks the files for changes until the context is closed
+func (ft *fpcp_tls) watch(ctx context.Context, to time.Duration) {
+	ft.logger.Info("Checking the certificate files every ", to)
+	for {
+		select {
+		case <-ctx.Done():
+			ft.logger.Info("Stop checking the certificate files.")
+			return
+		case <-time.After(to):
+			if _, err := ft.reload(); err != nil {
+				ft.logger.Error("Could not reload the certificate files, keeping the current ones. err=", err)
+			}
+		}
+	}
+}
+
+// the files are considered changed if their sizes or modification times
+// are changed
+func (ft *fpcp_tls) filesStamp() (string, error) {
+	res := ""
+	for _, fn := range []string{ft.certFile, ft.keyFile, ft.caFile} {
+		if fn == "" {
+			continue
+		}
+		fi, err := os.Stat(fn)
+		if err != nil {
+			return "", err
+		}
+		res += fmt.Sprint(fi.Size(), ":", fi.ModTime().UnixNano(), ";")
+	}
+	return res, nil
+}
+
+func (ft *fpcp_tls) getConfigForClient(*tls.ClientHelloInfo) (*tls.Config, error) {
+	ft.lock.Lock()
+	defer ft.lock.Unlock()
+	return &tls.Config{
+		Certificates: []tls.Certificate{*ft.cert},
+		ClientCAs:    ft.cas,
+		ClientAuth:   ft.clientAuth,
+		MinVersion:   tls.VersionTLS12,
+		NextProtos:   []string{"h2"},
+	}, nil
+}
+
+// Returns the camId of the verified client certificate the call is made
+// with, or -1 if there is no one
+func peerCamId(ctx context.Context) int64 {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return -1
+	}
+	ti, ok := p.AuthInfo.(credentials.TLSInfo)
+	if !ok || len(ti.State.VerifiedChains) == 0 {
+		return -1
+	}
+	return ak2camId(ti.State.VerifiedChains[0][0].Subject.CommonName)
+}
@@ -17,7 +17,9 @@ import (
 
 type (
 	ImageService struct {
-		BlobStorage storage.BlobStorage `inject:""`
+		BlobStorage storage.BlobStorage     `inject:""`
+		Config      *common.ConsoleConfig   `inject:""`
+		C2oCache    common.CamId2OrgIdCache `inject:"cam2orgCache"`
 		logger      log4g.Logger
 		dfltSize    byte
 	}
@@ -34,24 +36,13 @@ func NewImageService() *ImageService {
 // **** Public interface ****
 
 // Returns the image by the desired width or height. If the desired dimensions
-// width(w) and height(h) are less or equal 0, then the default size will be used
-func (ims *ImageService) GetImageByFileName(imd *ImgDesc, w, h int) (io.ReadCloser, error) {
-	imd.Format = IMG_FRMT_JPEG
-	imd.Size = ims.getSizeCode(w, h)
-
-	for {
-		id := imd.getStoreId()
-		ims.logger.Debug("Get image by ", imd)
-		rdr, bm := ims.BlobStorage.Read(id)
-		if bm != nil {
-			return rdr, nil
-		}
-
-		if imd.Size == IMG_SIZE_ORIGINAL {
-			return nil, common.NewError(common.ERR_NOT_FOUND, "Could not find image "+imd.getFileName())
-		}
-		imd.Size = nextSizeCode(imd.Size)
-	}
+// width(w) and height(h) are less or equal 0, then the default size will be used.
+// The image meta is returned as well, so the caller knows when the image was
+// stored and its checksum. A face image, which is not stored, is cut from
+// its frame.
+func (ims *ImageService) GetImageByFileName(imd *ImgDesc, w, h int) (io.ReadCloser, *storage.BlobMeta, error) {
+	rdr, bm, _, err := ims.getImage(imd, w, h)
+	return rdr, bm, err
 }
 
 func (ims *ImageService) DeleteAllTmpFiles() {
@@ -64,7 +55,37 @@ func (ims *ImageService) DeleteImageByFile(fileName string) error {
 	if err != nil {
 		return err
 	}
-	return ims.BlobStorage.Delete(imd.getPossibleIDs()...)
+	// the renditions ids are known, so the storage is not scanned for them
+	ids := append(imd.getPossibleIDs(), imd.getDerivedIds()...)
+	return ims.BlobStorage.Delete(ids...)
+}
+
+// Returns the image file name (the picture id) by the blob storage object id
+// and whether the object is a derived rendition of the image. The last
+// value is false if the object id is not an image id.
+func (ims *ImageService) GetFileNameByStoreId(id string) (string, bool, bool) {
+	var imd ImgDesc
+	if imd.parseStoreId(id) == nil {
+		return imd.getFileName(), false, true
+	}
+	if imd.parseDerivedId(id) == nil {
+		return imd.getFileName(), true, true
+	}
+	return "", false, false
+}
+
+// Returns whether the blob storage has at least one size of the image, or
+// the frame the face image could be cut from
+func (ims *ImageService) HasImage(fileName string) (bool, error) {
+	var imd ImgDesc
+	err := imd.ParseFileName(fileName)
+	if err != nil {
+		return false, err
+	}
+	if ims.hasStoredImage(&imd) {
+		return true, nil
+	}
+	return imd.Rect != nil && ims.hasFrame(&imd), nil
 }
 
 // Stores the image according to provided, returns the file name for the file
@@ -79,19 +100,48 @@ func (ims *ImageService) StoreImage(imgDesc *ImgDesc, reader io.Reader) (string,
 }
 
 func (ims *ImageService) IsValidPic(imgFn string) error {
-	var id ImgDesc
-	err := id.ParseFileName(imgFn)
+	ok, err := ims.HasImage(imgFn)
 	if err != nil {
 		return err
 	}
-
-	bm := ims.BlobStorage.ReadMeta(id.getStoreId())
-	if bm == nil {
+	if !ok {
 		return common.NewError(common.ERR_NOT_FOUND, "Could not find image file for "+imgFn)
 	}
 	return nil
 }
 
+// Returns the org storage usage by cameras. Returns ERR_NOT_FOUND if the blob
+// storage doesn't account usage.
+func (ims *ImageService) GetOrgStorageUsage(orgId int64) (*storage.BlobOwnerUsage, error) {
+	ur, ok := ims.BlobStorage.(storage.BlobUsageReporter)
+	if !ok {
+		return nil, common.NewError(common.ERR_NOT_FOUND, "The blob storage doesn't support usage reports.")
+	}
+	ou := ur.GetOwnerUsage(orgId)
+	if ou == nil {
+		ou = &storage.BlobOwnerUsage{Owner: orgId, Quota: ims.GetOwnerQuota(orgId)}
+	}
+	return ou, nil
+}
+
+// ============================ BlobOwnerResolver ============================
+// Images are owned by the camera organization, the camera is the sub-owner
+func (ims *ImageService) GetOwner(objId string) (int64, int64, bool) {
+	camId, ok := getCamIdByStoreId(objId)
+	if !ok {
+		return 0, 0, false
+	}
+	orgId := ims.C2oCache.GetOrgId(camId)
+	if orgId <= 0 {
+		return 0, 0, false
+	}
+	return orgId, camId, true
+}
+
+func (ims *ImageService) GetOwnerQuota(orgId int64) int64 {
+	return ims.Config.GetLbsOrgMaxSizeBytes(orgId)
+}
+
 // DEPRECATED. Left for future.
 // Stores the frame params:
 // camId - the camera where the image comes from
@@ -154,46 +204,57 @@ func (ims *ImageService) storeNewFrame(camId, frameId int64, img image.Image, re
 // is less than requested. The second returned param shows whether the transformation
 // was done or not
 func (ims *ImageService) scaleImage(size byte, img image.Image) (image.Image, bool) {
+	if size == IMG_SIZE_ORIGINAL {
+		return img, false
+	}
+	dw, dh := getDimensionsBySizeCode(size)
+	ims.logger.Debug("Scale Image: dw=", dw, ", dh=", dh, ", size=", string(size))
+	if dw == 0 || dh == 0 {
+		ims.logger.Warn("Cannot save an image with 0 width or height: dw=", dw, ", dh=", dh)
+		return img, false
+	}
+	return ims.scaleToFit(img, dw, dh)
+}
+
+// Scales the image proportionally to fit the dw x dh box, 0 dw or dh means
+// the dimension is not limited. Will do nothing if the original size is less
+// than requested, we don't stretch. The second returned param shows whether
+// the transformation was done or not
+func (ims *ImageService) scaleToFit(img image.Image, dw, dh int) (image.Image, bool) {
 	// Original dimensions
 	w := img.Bounds().Dx()
 	h := img.Bounds().Dy()
+	if w == 0 || h == 0 || (dw <= 0 && dh <= 0) {
+		ims.logger.Warn("Cannot scale an image with 0 width or height: w=", w, ", h=", h, ", dw=", dw, ", dh=", dh)
+		return img, false
+	}
 
-	nsImg := img
-	resized := false
-
-	// First resize if needed
-	if size != IMG_SIZE_ORIGINAL {
-		dw, dh := getDimensionsBySizeCode(size)
-		ims.logger.Debug("Scale Image: w=", w, ", h=", h, ", dw=", dw, ", dh=", dh, ", size=", string(size))
-
-		if w == 0 || h == 0 || dw == 0 || dh == 0 {
-			ims.logger.Warn("Cannot save an image with 0 width or height: w=", w, ", h=", h, ", dw=", dw, ", dh=", dh)
-			return nsImg, false
-		}
-
-		ddw := float64(dw) / float64(w)
-		ddh := float64(dh) / float64(h)
-
-		if ddw >= 1.0 && ddh >= 1.0 {
-			// desired size is bigger than original, do nothing, we don't streach
-			ims.logger.Debug("Orginal size less than desired, don't streach: w=", w, ", h=", h, ", dw=", dw, ", dh=", dh)
-			return nsImg, false
-		}
+	ddw := math.MaxFloat64
+	if dw > 0 {
+		ddw = float64(dw) / float64(w)
+	}
+	ddh := math.MaxFloat64
+	if dh > 0 {
+		ddh = float64(dh) / float64(h)
+	}
 
-		nw := uint(dw)
-		nh := uint(dh)
-		if ddw < ddh {
-			nh = uint(math.Max(1.0, float64(h)*ddw))
-		} else {
-			nw = uint(math.Max(1.0, float64(w)*ddh))
-		}
+	if ddw >= 1.0 && ddh >= 1.0 {
+		// desired size is bigger than original, do nothing, we don't streach
+		ims.logger.Debug("Orginal size less than desired, don't streach: w=", w, ", h=", h, ", dw=", dw, ", dh=", dh)
+		return img, false
+	}
 
-		ims.logger.Debug("Scale picutre w=", w, ", h=", h, " newW=", nw, ", newH=", nh)
-		nsImg = resize.Resize(nw, nh, img, resize.Bilinear)
-		resized = true
+	var nw, nh uint
+	if ddw < ddh {
+		nw = uint(math.Max(1.0, float64(w)*ddw))
+		nh = uint(math.Max(1.0, float64(h)*ddw))
+	} else {
+		nw = uint(math.Max(1.0, float64(w)*ddh))
+		nh = uint(math.Max(1.0, float64(h)*ddh))
 	}
 
-	return nsImg, resized
+	ims.logger.Debug("Scale picutre w=", w, ", h=", h, " newW=", nw, ", newH=", nh)
+	return resize.Resize(nw, nh, img, resize.Bilinear), true
 }
 
 // Stores the provided image by the descriptor. If the operation
@@ -218,6 +279,30 @@ func (ims *ImageService) storeImage(iDsc *ImgDesc, img image.Image) error {
 }
 
 // **** Private interface ****
+// The same as GetImageByFileName(), but returns also whether the image is
+// stored, or it is the face image cut from its frame
+func (ims *ImageService) getImage(imd *ImgDesc, w, h int) (io.ReadCloser, *storage.BlobMeta, bool, error) {
+	imd.Size = ims.getSizeCode(w, h)
+
+	for {
+		id := imd.getStoreId()
+		ims.logger.Debug("Get image by ", imd)
+		rdr, bm := ims.BlobStorage.Read(id)
+		if bm != nil {
+			return rdr, bm, true, nil
+		}
+
+		if imd.Size == IMG_SIZE_ORIGINAL {
+			if imd.Rect != nil {
+				rdr, bm, err := ims.cutFaceImage(imd, false)
+				return rdr, bm, false, err
+			}
+			return nil, nil, false, common.NewError(common.ERR_NOT_FOUND, "Could not find image "+imd.getFileName())
+		}
+		imd.Size = nextSizeCode(imd.Size)
+	}
+}
+
 func (ims *ImageService) getSizeCode(w, h int) byte {
 	if w <= 0 && h <= 0 {
 		return ims.dfltSize
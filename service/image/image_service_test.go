@@ -28,7 +28,11 @@ func getTestImage() image.Image {
 }
 
 func readImage(is *ImageService, id string, w, h int) []byte {
-	r, err := is.GetImageByFileName(id, 1200, 1200)
+	var imd ImgDesc
+	if err := imd.ParseFileName(id); err != nil {
+		panic(err)
+	}
+	r, _, err := is.GetImageByFileName(&imd, 1200, 1200)
 	if err != nil {
 		panic(err)
 	}
@@ -47,7 +51,7 @@ func XTestEmptyFrame(t *testing.T) {
 	is := NewImageService()
 	is.BlobStorage = bs
 
-	res, err := is.StoreNewFrame(1, 1, getTestImage(), nil)
+	res, err := is.storeNewFrame(1, 1, getTestImage(), nil)
 	if err != nil {
 		t.Fatal("Oops cannot save image err=", err)
 	}
@@ -70,7 +74,7 @@ func TestMutiFrame(t *testing.T) {
 	log4g.SetLogLevel("pixty", log4g.DEBUG)
 	is.BlobStorage = bs
 
-	res, err := is.StoreNewFrame(1, 123456, getTestImage(),
+	res, err := is.storeNewFrame(1, 123456, getTestImage(),
 		[]image.Rectangle{image.Rect(0, 0, 1200, 100), image.Rect(100, 100, 500, 500), image.Rect(10, 10, 350, 300)})
 	if err != nil {
 		t.Fatal("Oops cannot save image err=", err)
@@ -0,0 +1,122 @@
+package image
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/draw"
+	"io"
+	"io/ioutil"
+
+	"github.com/pixty/console/common"
+	"github.com/pixty/console/service/storage"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+)
+
+const (
+	// rectangle border width on 640 pixels wide image
+	cAnnBorderWidth = 2
+	cAnnLabelPad    = 2
+)
+
+var (
+	cAnnColor     = color.RGBA{0x00, 0xe6, 0x76, 0xff}
+	cAnnTextColor = color.Black
+)
+
+// An annotation drawn on a frame: the rectangle in the original frame
+// coordinates and its label
+type ImgAnnotation struct {
+	Rect  image.Rectangle
+	Label string
+}
+
+// Renders the original frame with the blurs regions blurred and then the
+// annotations drawn. The result is scaled and encoded as requested by the
+// rendition. The rendered images are not stored, labels and the regions to
+// blur could be changed at any time.
+func (ims *ImageService) RenderFrame(imd *ImgDesc, rnd *ImgRendition, blurs []image.Rectangle, anns []*ImgAnnotation) (io.ReadCloser, *storage.BlobMeta, error) {
+	// the rectangles are in the original frame coordinates
+	imd.Size = IMG_SIZE_ORIGINAL
+	rdr, bm := ims.BlobStorage.Read(imd.getStoreId())
+	if bm == nil {
+		return nil, nil, common.NewError(common.ERR_NOT_FOUND, "Could not find original frame "+imd.getFileName())
+	}
+	data, err := ioutil.ReadAll(rdr)
+	rdr.Close()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		ims.logger.Error("Could not decode image ", bm.Id, ", err=", err)
+		return nil, nil, err
+	}
+
+	dst := image.NewRGBA(img.Bounds())
+	draw.Draw(dst, dst.Bounds(), img, img.Bounds().Min, draw.Src)
+	for _, r := range blurs {
+		blurRect(dst, r)
+	}
+
+	bw := cAnnBorderWidth * dst.Bounds().Dx() / 640
+	if bw < 1 {
+		bw = 1
+	}
+	for _, ann := range anns {
+		drawAnnotation(dst, ann, bw)
+	}
+
+	w, h := ims.capDimensions(rnd.Width, rnd.Height)
+	sImg := image.Image(dst)
+	if w > 0 || h > 0 {
+		sImg, _ = ims.scaleToFit(dst, w, h)
+	}
+	frmt := rnd.Format
+	if frmt == 0 {
+		frmt = imd.Format
+	}
+	data, err = encodeImage(sImg, frmt)
+	if err != nil {
+		return nil, nil, err
+	}
+	return img_mem_reader{bytes.NewReader(data)}, &storage.BlobMeta{Id: bm.Id, Size: int64(len(data))}, nil
+}
+
+func drawAnnotation(dst *image.RGBA, ann *ImgAnnotation, bw int) {
+	r := ann.Rect.Canon().Intersect(dst.Bounds())
+	if r.Empty() {
+		return
+	}
+
+	clr := image.NewUniform(cAnnColor)
+	draw.Draw(dst, image.Rect(r.Min.X, r.Min.Y, r.Max.X, r.Min.Y+bw), clr, image.ZP, draw.Src)
+	draw.Draw(dst, image.Rect(r.Min.X, r.Max.Y-bw, r.Max.X, r.Max.Y), clr, image.ZP, draw.Src)
+	draw.Draw(dst, image.Rect(r.Min.X, r.Min.Y, r.Min.X+bw, r.Max.Y), clr, image.ZP, draw.Src)
+	draw.Draw(dst, image.Rect(r.Max.X-bw, r.Min.Y, r.Max.X, r.Max.Y), clr, image.ZP, draw.Src)
+
+	if ann.Label == "" {
+		return
+	}
+
+	// the label is above the rectangle, or inside if there is no space
+	face := basicfont.Face7x13
+	tw := font.MeasureString(face, ann.Label).Ceil()
+	th := face.Metrics().Height.Ceil()
+	lr := image.Rect(r.Min.X, r.Min.Y-th-2*cAnnLabelPad, r.Min.X+tw+2*cAnnLabelPad, r.Min.Y)
+	if lr.Min.Y < dst.Bounds().Min.Y {
+		lr = lr.Add(image.Pt(0, lr.Dy()))
+	}
+	draw.Draw(dst, lr, clr, image.ZP, draw.Src)
+
+	d := &font.Drawer{
+		Dst:  dst,
+		Src:  image.NewUniform(cAnnTextColor),
+		Face: face,
+		Dot:  fixed.P(lr.Min.X+cAnnLabelPad, lr.Min.Y+cAnnLabelPad+face.Metrics().Ascent.Ceil()),
+	}
+	d.DrawString(ann.Label)
+}
@@ -0,0 +1,78 @@
+package image
+
+import (
+	"image"
+)
+
+const (
+	// the blur radius relative to the rectangle size
+	cBlurRadiusDiv = 6
+	cBlurMinRadius = 3
+	// box blur passes, 3 gives a good approximation of the Gaussian one
+	cBlurPasses = 3
+)
+
+// Blurs the rectangle on the image. The rectangle is extended by the blur
+// radius, so the face borders are not left sharp.
+func blurRect(dst *image.RGBA, r image.Rectangle) {
+	r = r.Canon()
+	rad := r.Dx()
+	if r.Dy() > rad {
+		rad = r.Dy()
+	}
+	rad /= cBlurRadiusDiv
+	if rad < cBlurMinRadius {
+		rad = cBlurMinRadius
+	}
+	r = r.Inset(-rad).Intersect(dst.Bounds())
+	if r.Empty() {
+		return
+	}
+
+	w, h := r.Dx(), r.Dy()
+	buf := make([]uint8, w*h*4)
+	for y := 0; y < h; y++ {
+		off := dst.PixOffset(r.Min.X, r.Min.Y+y)
+		copy(buf[y*w*4:(y+1)*w*4], dst.Pix[off:off+w*4])
+	}
+
+	tmp := make([]uint8, len(buf))
+	for i := 0; i < cBlurPasses; i++ {
+		boxBlur(buf, tmp, w, h, 4, w*4, rad)
+		boxBlur(tmp, buf, h, w, w*4, 4, rad)
+	}
+
+	for y := 0; y < h; y++ {
+		off := dst.PixOffset(r.Min.X, r.Min.Y+y)
+		copy(dst.Pix[off:off+w*4], buf[y*w*4:(y+1)*w*4])
+	}
+}
+
+// One box blur pass along the lines of n pixels. The step is the distance
+// between pixels in the line, the stride is the distance between lines.
+// The edge pixels are repeated outside of the lines.
+func boxBlur(src, dst []uint8, n, lines, step, stride, rad int) {
+	div := 2*rad + 1
+	for l := 0; l < lines; l++ {
+		base := l * stride
+		for c := 0; c < 4; c++ {
+			pix := func(i int) int {
+				if i < 0 {
+					i = 0
+				} else if i >= n {
+					i = n - 1
+				}
+				return int(src[base+i*step+c])
+			}
+
+			sum := 0
+			for i := -rad; i <= rad; i++ {
+				sum += pix(i)
+			}
+			for i := 0; i < n; i++ {
+				dst[base+i*step+c] = uint8(sum / div)
+				sum += pix(i+rad+1) - pix(i-rad)
+			}
+		}
+	}
+}
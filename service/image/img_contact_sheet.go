@@ -0,0 +1,125 @@
+package image
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/draw"
+	"io"
+	"math"
+
+	"github.com/pixty/console/common"
+	"github.com/pixty/console/service/storage"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+)
+
+const (
+	cCsCellWidth  = 160
+	cCsCellHeight = 160
+	cCsCaptionH   = 18
+	cCsPad        = 4
+	cCsMaxCols    = 6
+	// no more images on one sheet
+	CONTACT_SHEET_MAX_ITEMS = 60
+)
+
+var (
+	cCsBgColor      = color.RGBA{0x20, 0x20, 0x20, 0xff}
+	cCsMissingColor = color.RGBA{0x50, 0x50, 0x50, 0xff}
+	cCsTextColor    = color.White
+)
+
+// An image on the contact sheet and its caption
+type ContactSheetItem struct {
+	FileName string
+	Caption  string
+}
+
+// Composes the images into a grid, every image is scaled to fit its cell and
+// has the caption below. Images which could not be read are left as empty
+// cells. The result is encoded in the rendition format, JPEG by default.
+func (ims *ImageService) GetContactSheet(items []*ContactSheetItem, rnd *ImgRendition) (io.ReadCloser, *storage.BlobMeta, error) {
+	if len(items) == 0 {
+		return nil, nil, common.NewError(common.ERR_NOT_FOUND, "No images for the contact sheet.")
+	}
+	if len(items) > CONTACT_SHEET_MAX_ITEMS {
+		items = items[:CONTACT_SHEET_MAX_ITEMS]
+	}
+
+	cols := int(math.Ceil(math.Sqrt(float64(len(items)))))
+	if cols > cCsMaxCols {
+		cols = cCsMaxCols
+	}
+	rows := (len(items) + cols - 1) / cols
+	cw := cCsCellWidth + 2*cCsPad
+	ch := cCsCellHeight + cCsCaptionH + 2*cCsPad
+
+	dst := image.NewRGBA(image.Rect(0, 0, cols*cw, rows*ch))
+	draw.Draw(dst, dst.Bounds(), image.NewUniform(cCsBgColor), image.ZP, draw.Src)
+	for i, it := range items {
+		x := (i%cols)*cw + cCsPad
+		y := (i/cols)*ch + cCsPad
+		ims.drawContactSheetCell(dst, image.Rect(x, y, x+cCsCellWidth, y+cCsCellHeight), it)
+	}
+
+	w, h := ims.capDimensions(rnd.Width, rnd.Height)
+	sImg := image.Image(dst)
+	if w > 0 || h > 0 {
+		sImg, _ = ims.scaleToFit(dst, w, h)
+	}
+	frmt := rnd.Format
+	if frmt == 0 {
+		frmt = IMG_FRMT_JPEG
+	}
+	data, err := encodeImage(sImg, frmt)
+	if err != nil {
+		return nil, nil, err
+	}
+	return img_mem_reader{bytes.NewReader(data)}, &storage.BlobMeta{Size: int64(len(data))}, nil
+}
+
+func (ims *ImageService) drawContactSheetCell(dst *image.RGBA, cell image.Rectangle, it *ContactSheetItem) {
+	img, err := ims.readImage(it.FileName, cell.Dx(), cell.Dy())
+	if err != nil {
+		ims.logger.Warn("Could not read image ", it.FileName, " for the contact sheet, err=", err)
+		draw.Draw(dst, cell, image.NewUniform(cCsMissingColor), image.ZP, draw.Src)
+	} else {
+		img, _ = ims.scaleToFit(img, cell.Dx(), cell.Dy())
+		b := img.Bounds()
+		// centered in the cell
+		p := cell.Min.Add(image.Pt((cell.Dx()-b.Dx())/2, (cell.Dy()-b.Dy())/2))
+		draw.Draw(dst, image.Rectangle{p, p.Add(b.Size())}, img, b.Min, draw.Src)
+	}
+
+	if it.Caption == "" {
+		return
+	}
+	face := basicfont.Face7x13
+	tw := font.MeasureString(face, it.Caption).Ceil()
+	d := &font.Drawer{
+		Dst:  dst,
+		Src:  image.NewUniform(cCsTextColor),
+		Face: face,
+		Dot:  fixed.P(cell.Min.X+(cell.Dx()-tw)/2, cell.Max.Y+face.Metrics().Ascent.Ceil()+2),
+	}
+	d.DrawString(it.Caption)
+}
+
+// reads and decodes the smallest stored size of the image, which is not
+// less than w x h
+func (ims *ImageService) readImage(fileName string, w, h int) (image.Image, error) {
+	var imd ImgDesc
+	err := imd.ParseFileName(fileName)
+	if err != nil {
+		return nil, err
+	}
+	rdr, _, err := ims.GetImageByFileName(&imd, w, h)
+	if err != nil {
+		return nil, err
+	}
+	defer rdr.Close()
+	img, _, err := image.Decode(rdr)
+	return img, err
+}
@@ -0,0 +1,159 @@
+package image
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"io"
+	"io/ioutil"
+	"time"
+
+	"github.com/pixty/console/common"
+	"github.com/pixty/console/service/storage"
+)
+
+// Returns the file name of the face image cut from the frame by the
+// rectangle. The image is not stored, it is cut from the frame every time it
+// is requested (see ImgsCropOnDemand), unless it is kept by KeepFaceImage().
+func (ims *ImageService) GetFaceFileName(frameFileName string, r image.Rectangle) (string, error) {
+	var imd ImgDesc
+	err := imd.ParseFileName(frameFileName)
+	if err != nil {
+		return "", err
+	}
+	imd.Rect = &r
+	return imd.getFileName(), nil
+}
+
+// Returns the prefix of the face images file names of the frame. Returns
+// false if the file name is not a frame file name.
+func (ims *ImageService) GetFaceFilesPrefix(frameFileName string) (string, bool) {
+	var imd ImgDesc
+	if imd.ParseFileName(frameFileName) != nil || imd.Rect != nil {
+		return "", false
+	}
+	return fmt.Sprint(imd.Prefix, "_", imd.CamId, "_", imd.FrameId, "_"), true
+}
+
+// Makes sure the face image is stored, cutting it from the frame if needed.
+// Face images which are used by persons and profiles should outlive their
+// frames. Does nothing for frames and for the face images stored already.
+func (ims *ImageService) KeepFaceImage(fileName string) error {
+	var imd ImgDesc
+	err := imd.ParseFileName(fileName)
+	if err != nil {
+		return err
+	}
+	if imd.Rect == nil || ims.hasStoredImage(&imd) {
+		return nil
+	}
+	rdr, _, err := ims.cutFaceImage(&imd, true)
+	if err != nil {
+		return err
+	}
+	rdr.Close()
+	return nil
+}
+
+// Deletes the stored face image, if it could be cut from its frame. Returns
+// false if the file is not a face image, the face image is not stored, or
+// its frame is missing.
+func (ims *ImageService) DropFaceImage(fileName string) (bool, error) {
+	var imd ImgDesc
+	if imd.ParseFileName(fileName) != nil || imd.Rect == nil {
+		return false, nil
+	}
+	if !ims.hasStoredImage(&imd) || !ims.hasFrame(&imd) {
+		return false, nil
+	}
+	return true, ims.DeleteImageByFile(fileName)
+}
+
+func (ims *ImageService) hasStoredImage(imd *ImgDesc) bool {
+	for _, id := range imd.getPossibleIDs() {
+		if ims.BlobStorage.ReadMeta(id) != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns whether the original frame of the face image is stored
+func (ims *ImageService) hasFrame(imd *ImgDesc) bool {
+	_, bm := ims.findFrame(imd, false)
+	return bm != nil
+}
+
+// Looks for the original size frame of the image. The frame could be stored
+// in a format other than the face image one.
+func (ims *ImageService) findFrame(imd *ImgDesc, read bool) (io.ReadCloser, *storage.BlobMeta) {
+	frm := &ImgDesc{Prefix: imd.Prefix, CamId: imd.CamId, FrameId: imd.FrameId, Size: IMG_SIZE_ORIGINAL, Format: imd.Format}
+	frmts := []byte{imd.Format}
+	for f := range storedFormats {
+		if f != imd.Format {
+			frmts = append(frmts, f)
+		}
+	}
+
+	for _, f := range frmts {
+		frm.Format = f
+		if !read {
+			if bm := ims.BlobStorage.ReadMeta(frm.getStoreId()); bm != nil {
+				return nil, bm
+			}
+			continue
+		}
+		if rdr, bm := ims.BlobStorage.Read(frm.getStoreId()); bm != nil {
+			return rdr, bm
+		}
+	}
+	return nil, nil
+}
+
+// Cuts the face image from the original frame with ImgsCropBorder around
+// the face rectangle. If store is true, the result is stored as the original
+// size of the face image, so next time it is read from the storage and it
+// outlives the frame. The face images which are just viewed are not stored,
+// so crops dropped by the crops migration are not re-created.
+func (ims *ImageService) cutFaceImage(imd *ImgDesc, store bool) (io.ReadCloser, *storage.BlobMeta, error) {
+	imd.Size = IMG_SIZE_ORIGINAL
+	rdr, bm := ims.findFrame(imd, true)
+	if bm == nil {
+		return nil, nil, common.NewError(common.ERR_NOT_FOUND, "Could not find image "+imd.getFileName()+" neither its frame")
+	}
+	data, err := ioutil.ReadAll(rdr)
+	rdr.Close()
+	if err != nil {
+		return nil, nil, err
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		ims.logger.Error("Could not decode frame ", bm.Id, ", err=", err)
+		return nil, nil, err
+	}
+
+	r := imd.Rect.Canon().Inset(-ims.Config.ImgsCropBorder).Intersect(img.Bounds())
+	if r.Empty() {
+		return nil, nil, common.NewError(common.ERR_NOT_FOUND, "The face rectangle is out of the frame for "+imd.getFileName())
+	}
+	si := img.(interface {
+		SubImage(r image.Rectangle) image.Image
+	}).SubImage(r)
+
+	data, err = encodeImage(si, imd.Format)
+	if err != nil {
+		ims.logger.Error("Could not encode face image ", imd, ", err=", err)
+		return nil, nil, err
+	}
+
+	fbm := &storage.BlobMeta{Id: imd.getStoreId(), Timestamp: time.Now(), Size: int64(len(data))}
+	if store && imd.Prefix == PFX_PERM {
+		ims.logger.Debug("Storing face image cut from the frame ", imd)
+		if _, err = ims.BlobStorage.Add(bytes.NewReader(data), fbm); err != nil {
+			ims.logger.Warn("Could not store face image ", imd, ", err=", err)
+		} else if sbm := ims.BlobStorage.ReadMeta(fbm.Id); sbm != nil {
+			fbm = sbm
+		}
+	}
+	return img_mem_reader{bytes.NewReader(data)}, fbm, nil
+}
@@ -30,6 +30,9 @@ const (
 	// Prefix code
 	PFX_PERM = "cm"
 	PFX_TEMP = "tcm"
+	// Prefix of the derived renditions (resized or converted) of permanent
+	// images, like "dcm"
+	PFX_DERIVED = "d"
 
 	// Image sizes
 	IMG_SIZE_ORIGINAL = 'o'
@@ -41,22 +44,33 @@ const (
 	// Image format
 	IMG_FRMT_JPEG = 'j'
 	IMG_FRMT_PNG  = 'p'
+	// is used for derived renditions only
+	IMG_FRMT_WEBP = 'w'
 )
 
 var sizeCodesMap = map[byte]int{'t': 0, 's': 1, 'm': 2, 'l': 3, 'o': 4}
 var sizeCodes = []byte{'t', 's', 'm', 'l', 'o'}
 
+// Formats images can be stored in, the format code is a part of the store id
+// and defines the file name extension. To support a new format it should be
+// added here.
+var storedFormats = map[byte]string{IMG_FRMT_JPEG: ".jpeg", IMG_FRMT_PNG: ".png"}
+
+// Formats the derived renditions could be encoded in
+var derivedFormats = []byte{IMG_FRMT_JPEG, IMG_FRMT_PNG, IMG_FRMT_WEBP}
+
 // Get a file name, parse it and fill the ImageDesc object fields by
 // the file fields values
 // The filename format is expected in the following form:
-// <Prefix>_<CamId>_<FrameId>_[<Rectangle>].jpeg
+// <Prefix>_<CamId>_<FrameId>_[<Rectangle>].<Ext>
 // <Rectangle> is encoded like Left-Top-Right-Bottom and optional
+// <Ext> is one of storedFormats extensions (jpeg, png) and defines the format
 func (imd *ImgDesc) ParseFileName(fn string) error {
-	// only jpeg is acceptable
-	id := strings.TrimSuffix(fn, ".jpeg")
-	if len(id) == len(fn) {
-		return common.NewError(common.ERR_INVALID_VAL, "Expecting .jpeg filename, but received "+fn)
+	frmt, ok := getFormatByFileName(fn)
+	if !ok {
+		return common.NewError(common.ERR_INVALID_VAL, "Expecting .jpeg or .png filename, but received "+fn)
 	}
+	id := strings.TrimSuffix(fn, storedFormats[frmt])
 
 	parts := strings.Split(id, "_")
 	if len(parts) < 3 || len(parts) > 4 {
@@ -106,7 +120,7 @@ func (imd *ImgDesc) ParseFileName(fn string) error {
 	imd.CamId = camId
 	imd.FrameId = frmId
 	imd.Size = 0
-	imd.Format = IMG_FRMT_JPEG
+	imd.Format = frmt
 	return nil
 }
 
@@ -115,7 +129,7 @@ func (imd *ImgDesc) check() {
 		panic("Unknown prefix " + imd.Prefix)
 	}
 
-	if imd.Format != IMG_FRMT_JPEG && imd.Format != IMG_FRMT_PNG {
+	if _, ok := storedFormats[imd.Format]; !ok {
 		panic("Unsupported format " + string(imd.Format))
 	}
 
@@ -126,10 +140,7 @@ func (imd *ImgDesc) check() {
 
 // Formats file name based on the descriptor settings
 func (imd *ImgDesc) getFileName() string {
-	ext := ".jpeg"
-	if imd.Format == IMG_FRMT_PNG {
-		ext = ".png"
-	}
+	ext := storedFormats[imd.Format]
 	if imd.Rect != nil {
 		return fmt.Sprint(imd.Prefix, "_", imd.CamId, "_", imd.FrameId, "_",
 			imd.Rect.Min.X, "-", imd.Rect.Min.Y, "-", imd.Rect.Max.X, "-", imd.Rect.Max.Y, ext)
@@ -137,6 +148,14 @@ func (imd *ImgDesc) getFileName() string {
 	return fmt.Sprint(imd.Prefix, "_", imd.CamId, "_", imd.FrameId, ext)
 }
 
+// Returns the file name of the frame the image belongs to, for a frame it
+// is the image file name itself
+func (imd *ImgDesc) GetFrameFileName() string {
+	frm := *imd
+	frm.Rect = nil
+	return frm.getFileName()
+}
+
 func (imd *ImgDesc) String() string {
 	return fmt.Sprint("ImageDesc:{Prefix=", imd.Prefix, ", CamId=", imd.CamId, ", FrameId=", imd.FrameId, ", Rect=", imd.Rect, ", Size=", imd.Size, ", Format=", imd.Format, "}")
 }
@@ -161,3 +180,121 @@ func (imd *ImgDesc) getStoreIdForSize(size byte) string {
 	}
 	return fmt.Sprintf("%s_%x_%x_%c%c%x", imd.Prefix, imd.CamId, imd.FrameId, imd.Format, size, imd.FrameId&255)
 }
+
+// Parses the store id (see getStoreIdForSize()) and fills the descriptor fields
+func (imd *ImgDesc) parseStoreId(id string) error {
+	parts := strings.Split(id, "_")
+	if len(parts) < 4 || len(parts) > 5 || (parts[0] != PFX_PERM && parts[0] != PFX_TEMP) {
+		return common.NewError(common.ERR_INVALID_VAL, "Unexpected store id format "+id)
+	}
+
+	camId, err := strconv.ParseInt(parts[1], 16, 64)
+	if err != nil {
+		return common.NewError(common.ERR_INVALID_VAL, "Unexpected store id format "+id+": Wrong camId="+parts[1])
+	}
+	frmId, err := strconv.ParseInt(parts[2], 16, 64)
+	if err != nil {
+		return common.NewError(common.ERR_INVALID_VAL, "Unexpected store id format "+id+": Wrong frameId="+parts[2])
+	}
+
+	sfx := parts[len(parts)-1]
+	if len(sfx) < 3 {
+		return common.NewError(common.ERR_INVALID_VAL, "Unexpected store id format "+id+": Wrong format or size")
+	}
+	if _, ok := storedFormats[sfx[0]]; !ok {
+		return common.NewError(common.ERR_INVALID_VAL, "Unexpected store id format "+id+": Unknown format "+string(sfx[0]))
+	}
+	if _, ok := sizeCodesMap[sfx[1]]; !ok {
+		return common.NewError(common.ERR_INVALID_VAL, "Unexpected store id format "+id+": Unknown size "+string(sfx[1]))
+	}
+
+	imd.Rect = nil
+	if len(parts) == 5 {
+		var x0, y0, x1, y1 int
+		if _, err := fmt.Sscanf(parts[3], "%d-%d-%d-%d", &x0, &y0, &x1, &y1); err != nil {
+			return common.NewError(common.ERR_INVALID_VAL, "Unexpected store id format "+id+": Wrong rectangle encoding="+parts[3])
+		}
+		rect := image.Rect(x0, y0, x1, y1)
+		imd.Rect = &rect
+	}
+	imd.Prefix = parts[0]
+	imd.CamId = camId
+	imd.FrameId = frmId
+	imd.Format = sfx[0]
+	imd.Size = sfx[1]
+	return nil
+}
+
+// Returns the prefix of all derived renditions store ids of the image:
+// d<Prefix>_<CamId>_<FrameId>_<Rectangle or "f">_<Format>
+func (imd *ImgDesc) getDerivedPrefix() string {
+	if imd.Rect != nil {
+		return fmt.Sprintf("%s%s_%x_%x_%d-%d-%d-%d_%c", PFX_DERIVED, imd.Prefix, imd.CamId, imd.FrameId,
+			imd.Rect.Min.X, imd.Rect.Min.Y, imd.Rect.Max.X, imd.Rect.Max.Y, imd.Format)
+	}
+	return fmt.Sprintf("%s%s_%x_%x_f_%c", PFX_DERIVED, imd.Prefix, imd.CamId, imd.FrameId, imd.Format)
+}
+
+// Returns the store id of the derived rendition of the image, the width and
+// height are the requested ones, not the result image dimensions. The id
+// ends by the frame id like the image sizes ids do, so the renditions are
+// spread over the LFS directories.
+func (imd *ImgDesc) getDerivedId(w, h int, format byte) string {
+	return fmt.Sprintf("%s%dx%d%c%x", imd.getDerivedPrefix(), w, h, format, imd.FrameId&255)
+}
+
+// Parses the derived rendition store id (see getDerivedId()) and fills the
+// descriptor fields of the image the rendition is made of. The size is
+// not known, it is set to the original one.
+func (imd *ImgDesc) parseDerivedId(id string) error {
+	if !strings.HasPrefix(id, PFX_DERIVED) {
+		return common.NewError(common.ERR_INVALID_VAL, "Unexpected derived id format "+id)
+	}
+	parts := strings.Split(strings.TrimPrefix(id, PFX_DERIVED), "_")
+	if len(parts) != 5 || len(parts[4]) < 2 {
+		return common.NewError(common.ERR_INVALID_VAL, "Unexpected derived id format "+id)
+	}
+	// the source image store id of the original size
+	sid := strings.Join(parts[:3], "_")
+	if parts[3] != "f" {
+		sid += "_" + parts[3]
+	}
+	sid += fmt.Sprintf("_%c%c0", parts[4][0], IMG_SIZE_ORIGINAL)
+	if err := imd.parseStoreId(sid); err != nil {
+		return common.NewError(common.ERR_INVALID_VAL, "Unexpected derived id format "+id)
+	}
+	return nil
+}
+
+// Returns the store ids of all derived renditions the image could have
+// stored, they are of the standard sizes only (see isStdSize())
+func (imd *ImgDesc) getDerivedIds() []string {
+	res := make([]string, 0, len(sizeCodes)*len(derivedFormats))
+	for _, sc := range sizeCodes {
+		w, h := getDimensionsBySizeCode(sc)
+		for _, frmt := range derivedFormats {
+			res = append(res, imd.getDerivedId(w, h, frmt))
+		}
+	}
+	return res
+}
+
+func getFormatByFileName(fn string) (byte, bool) {
+	for frmt, ext := range storedFormats {
+		if strings.HasSuffix(fn, ext) {
+			return frmt, true
+		}
+	}
+	return 0, false
+}
+
+// Returns camId the store id (see getStoreIdForSize() and getDerivedId()) was
+// built for
+func getCamIdByStoreId(id string) (int64, bool) {
+	parts := strings.SplitN(strings.TrimPrefix(id, PFX_DERIVED), "_", 3)
+	if len(parts) < 3 || (parts[0] != PFX_PERM && parts[0] != PFX_TEMP) {
+		return 0, false
+	}
+	camId, err := strconv.ParseInt(parts[1], 16, 64)
+	return camId, err == nil
+}
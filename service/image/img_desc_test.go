@@ -0,0 +1,115 @@
+package image
+
+import (
+	"image"
+	"strings"
+	"testing"
+)
+
+func TestParseStoreId(t *testing.T) {
+	rect := image.Rect(10, 20, 110, 140)
+	tests := []*ImgDesc{
+		{PFX_PERM, 1, 123456, nil, IMG_SIZE_ORIGINAL, IMG_FRMT_JPEG},
+		{PFX_TEMP, 255, 1, nil, IMG_SIZE_160x120, IMG_FRMT_PNG},
+		{PFX_PERM, 1234, 9876543210, &rect, IMG_SIZE_640x480, IMG_FRMT_JPEG},
+	}
+	for _, imd := range tests {
+		id := imd.getStoreId()
+		var res ImgDesc
+		if err := res.parseStoreId(id); err != nil {
+			t.Fatal("Could not parse store id ", id, " of ", imd, ", err=", err)
+		}
+		if res.getStoreId() != id || res.getFileName() != imd.getFileName() {
+			t.Fatal("Parsed ", &res, " doesn't match the original ", imd)
+		}
+	}
+}
+
+func TestParseStoreIdErrors(t *testing.T) {
+	tests := []string{
+		"",
+		"cm_1_2",
+		"xx_1_2_jo2",
+		"cm_zz_2_jo2",
+		"cm_1_zz_jo2",
+		"cm_1_2_j",
+		"cm_1_2_wo2",
+		"cm_1_2_jx2",
+		"cm_1_2_1-2-3_jo2",
+		"cm_1_2_1-2-3-4_jo2_x",
+		// derived renditions are not images
+		"dcm_1_2_f_160x120j",
+	}
+	for _, id := range tests {
+		var imd ImgDesc
+		if err := imd.parseStoreId(id); err == nil {
+			t.Fatal("Store id \"", id, "\" must not be parsed, but got ", &imd)
+		}
+	}
+}
+
+func TestGetCamIdByStoreId(t *testing.T) {
+	imd := &ImgDesc{PFX_PERM, 300, 2, nil, IMG_SIZE_ORIGINAL, IMG_FRMT_JPEG}
+	tests := []struct {
+		id    string
+		camId int64
+		ok    bool
+	}{
+		{imd.getStoreId(), 300, true},
+		{imd.getDerivedId(160, 120, IMG_FRMT_WEBP), 300, true},
+		{"tcm_a_1_jo1", 10, true},
+		{"xx_a_1_jo1", 0, false},
+		{"cm_zz_1_jo1", 0, false},
+		{"cm", 0, false},
+	}
+	for _, tst := range tests {
+		camId, ok := getCamIdByStoreId(tst.id)
+		if ok != tst.ok || (ok && camId != tst.camId) {
+			t.Fatal("getCamIdByStoreId(", tst.id, ") returned ", camId, ", ", ok)
+		}
+	}
+}
+
+func TestDerivedIdSuffix(t *testing.T) {
+	rect := image.Rect(1, 2, 3, 4)
+	tests := []struct {
+		imd *ImgDesc
+		sfx string
+	}{
+		{&ImgDesc{PFX_PERM, 1, 0x1234, nil, IMG_SIZE_ORIGINAL, IMG_FRMT_JPEG}, "34"},
+		{&ImgDesc{PFX_PERM, 1, 0xff, &rect, IMG_SIZE_ORIGINAL, IMG_FRMT_PNG}, "ff"},
+	}
+	for _, tst := range tests {
+		for _, id := range tst.imd.getDerivedIds() {
+			if !strings.HasSuffix(id, tst.sfx) {
+				t.Fatal("Derived id ", id, " should end by the frame id ", tst.sfx)
+			}
+		}
+	}
+}
+
+func TestParseDerivedId(t *testing.T) {
+	rect := image.Rect(10, 20, 110, 140)
+	tests := []*ImgDesc{
+		{PFX_PERM, 1, 123456, nil, IMG_SIZE_ORIGINAL, IMG_FRMT_JPEG},
+		{PFX_PERM, 1234, 9876543210, &rect, IMG_SIZE_ORIGINAL, IMG_FRMT_PNG},
+	}
+	for _, imd := range tests {
+		for _, id := range imd.getDerivedIds() {
+			var res ImgDesc
+			if err := res.parseDerivedId(id); err != nil {
+				t.Fatal("Could not parse derived id ", id, ", err=", err)
+			}
+			if res.getFileName() != imd.getFileName() {
+				t.Fatal("Derived id ", id, " gives ", res.getFileName(), " instead of ", imd.getFileName())
+			}
+		}
+	}
+
+	for _, id := range []string{"cm_1_2_jo2", "dcm_1_2_jo2", "dcm_1_2_f_", "dxx_1_2_f_j160x120j2", "dcm_1_2_f_x160x120j2"} {
+		var imd ImgDesc
+		if imd.parseDerivedId(id) == nil {
+			t.Fatal("Derived id \"", id, "\" must not be parsed")
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package image
+
+import (
+	"image"
+	"math"
+)
+
+const (
+	// the face region is sampled by the grid no more than the size
+	cQltyMaxSamples = 96
+	// Laplacian variance which gives 0.5 sharpness score
+	cQltySharpHalf = 100.0
+	// the mean brightness which is considered the best one
+	cQltyBrightBest = 128.0
+	// faces of the size (on the frame) and bigger get the maximum size score
+	cQltyFullSize = 160.0
+
+	cQltySharpWeight  = 0.5
+	cQltyBrightWeight = 0.25
+	cQltySizeWeight   = 0.25
+)
+
+// Returns the face image quality in (0..1], sharp, well lit and big faces
+// get higher scores. r is the face region on the image, side is the face
+// size on the original frame. If the image is nil, only the size is scored.
+func FaceQuality(img image.Image, r image.Rectangle, side int) float32 {
+	var lum [][]float64
+	if img != nil {
+		lum = sampleLuminance(img, r.Canon().Intersect(img.Bounds()))
+	}
+	sharp, bright := 0.0, 0.0
+	if len(lum) > 2 && len(lum[0]) > 2 {
+		v := laplacianVariance(lum)
+		sharp = v / (v + cQltySharpHalf)
+
+		sum := 0.0
+		for _, row := range lum {
+			for _, l := range row {
+				sum += l
+			}
+		}
+		mean := sum / float64(len(lum)*len(lum[0]))
+		bright = math.Max(0, 1.0-math.Abs(mean-cQltyBrightBest)/cQltyBrightBest)
+	}
+	size := math.Min(1.0, float64(side)/cQltyFullSize)
+
+	q := cQltySharpWeight*sharp + cQltyBrightWeight*bright + cQltySizeWeight*size
+	// 0 is reserved for unknown quality
+	return float32(math.Max(q, 0.001))
+}
+
+// returns the luminance (0..255) of the region pixels sampled by a grid
+func sampleLuminance(img image.Image, r image.Rectangle) [][]float64 {
+	if r.Empty() {
+		return nil
+	}
+	step := 1
+	if r.Dx() > cQltyMaxSamples || r.Dy() > cQltyMaxSamples {
+		step = int(math.Ceil(math.Max(float64(r.Dx()), float64(r.Dy())) / cQltyMaxSamples))
+	}
+
+	res := make([][]float64, 0, r.Dy()/step+1)
+	for y := r.Min.Y; y < r.Max.Y; y += step {
+		row := make([]float64, 0, r.Dx()/step+1)
+		for x := r.Min.X; x < r.Max.X; x += step {
+			cr, cg, cb, _ := img.At(x, y).RGBA()
+			row = append(row, (0.299*float64(cr)+0.587*float64(cg)+0.114*float64(cb))/257.0)
+		}
+		res = append(res, row)
+	}
+	return res
+}
+
+// the variance of the Laplacian is a common measure of the image focus,
+// blurred images have few edges, so the variance is low
+func laplacianVariance(lum [][]float64) float64 {
+	sum, sum2, n := 0.0, 0.0, 0.0
+	for y := 1; y < len(lum)-1; y++ {
+		for x := 1; x < len(lum[y])-1; x++ {
+			l := lum[y-1][x] + lum[y+1][x] + lum[y][x-1] + lum[y][x+1] - 4*lum[y][x]
+			sum += l
+			sum2 += l * l
+			n++
+		}
+	}
+	if n == 0 {
+		return 0
+	}
+	mean := sum / n
+	return sum2/n - mean*mean
+}
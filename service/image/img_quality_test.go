@@ -0,0 +1,62 @@
+package image
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+func newGrayImage(w, h int, lum func(x, y int) uint8) image.Image {
+	img := image.NewGray(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.SetGray(x, y, color.Gray{lum(x, y)})
+		}
+	}
+	return img
+}
+
+func TestFaceQuality(t *testing.T) {
+	gray := newGrayImage(64, 64, func(x, y int) uint8 { return 128 })
+	black := newGrayImage(64, 64, func(x, y int) uint8 { return 0 })
+	checker := newGrayImage(64, 64, func(x, y int) uint8 {
+		if (x+y)%2 == 0 {
+			return 255
+		}
+		return 0
+	})
+	// bigger than the samples grid
+	bigChecker := newGrayImage(400, 400, func(x, y int) uint8 {
+		if (x/5+y/5)%2 == 0 {
+			return 255
+		}
+		return 0
+	})
+	all := image.Rect(0, 0, 64, 64)
+
+	tests := []struct {
+		name     string
+		img      image.Image
+		r        image.Rectangle
+		side     int
+		min, max float32
+	}{
+		{"no image", nil, all, 160, 0.25, 0.25},
+		{"no image, big face", nil, all, 1000, 0.25, 0.25},
+		{"no image, half size", nil, all, 80, 0.125, 0.125},
+		{"no image, zero size", nil, all, 0, 0.001, 0.001},
+		{"out of image", gray, image.Rect(100, 100, 200, 200), 160, 0.25, 0.25},
+		{"flat well lit", gray, all, 160, 0.5, 0.5},
+		{"flat dark", black, all, 160, 0.25, 0.25},
+		{"sharp", checker, all, 160, 0.99, 1.0},
+		{"sharp, region", checker, image.Rect(10, 10, 30, 30), 160, 0.99, 1.0},
+		{"sharp, sampled", bigChecker, image.Rect(0, 0, 400, 400), 160, 0.75, 1.0},
+		{"sharp, small", checker, all, 16, 0.75, 0.8},
+	}
+	for _, tst := range tests {
+		q := FaceQuality(tst.img, tst.r, tst.side)
+		if q < tst.min-0.0001 || q > tst.max+0.0001 {
+			t.Fatal("Test \"", tst.name, "\": quality ", q, " is out of [", tst.min, ", ", tst.max, "]")
+		}
+	}
+}
@@ -0,0 +1,165 @@
+package image
+
+import (
+	"bytes"
+	"hash/crc32"
+	"image"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"io/ioutil"
+	"strconv"
+
+	"github.com/chai2010/webp"
+	"github.com/pixty/console/common"
+	"github.com/pixty/console/service/storage"
+)
+
+const cWebpQuality = 80
+
+type (
+	// The desired image representation. 0 Width or Height means the dimension
+	// is not limited, 0 Format means the format the image is stored in.
+	ImgRendition struct {
+		Width  int
+		Height int
+		Format byte
+	}
+
+	img_mem_reader struct {
+		*bytes.Reader
+	}
+)
+
+// Returns the image rendition. The image is scaled proportionally to fit the
+// requested width and height (limited by ImgsMaxWidth and ImgsMaxHeight) and
+// encoded in the requested format. Renditions of permanent images of the
+// standard sizes (see sizeCodes) are stored in the blob storage, so they are
+// reused and deleted together with the image. Other sizes, and renditions
+// of the face images cut from their frames, are rendered on every request,
+// so the number of stored renditions per image is limited.
+func (ims *ImageService) GetImage(imd *ImgDesc, rnd *ImgRendition) (io.ReadCloser, *storage.BlobMeta, error) {
+	w, h := ims.capDimensions(rnd.Width, rnd.Height)
+	rdr, bm, stored, err := ims.getImage(imd, w, h)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	frmt := rnd.Format
+	if frmt == 0 {
+		frmt = imd.Format
+	}
+	if w <= 0 && h <= 0 && frmt == imd.Format {
+		return rdr, bm, nil
+	}
+
+	did := imd.getDerivedId(w, h, frmt)
+	store := stored && imd.Prefix == PFX_PERM && isStdSize(w, h)
+	if store {
+		if drdr, dbm := ims.BlobStorage.Read(did); dbm != nil {
+			rdr.Close()
+			return drdr, dbm, nil
+		}
+	}
+
+	data, err := ioutil.ReadAll(rdr)
+	rdr.Close()
+	if err != nil {
+		return nil, nil, err
+	}
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		ims.logger.Error("Could not decode image ", bm.Id, ", err=", err)
+		return nil, nil, err
+	}
+
+	sImg, scaled := ims.scaleToFit(img, w, h)
+	if scaled || frmt != imd.Format {
+		data, err = encodeImage(sImg, frmt)
+		if err != nil {
+			ims.logger.Error("Could not encode image ", bm.Id, " to ", string(frmt), ", err=", err)
+			return nil, nil, err
+		}
+	}
+
+	// the rendition keeps the source timestamp, so its Last-Modified doesn't
+	// change when it is re-created. Temporary images go away soon, so their
+	// renditions are not stored.
+	dbm := &storage.BlobMeta{Id: did, Timestamp: bm.Timestamp, Size: int64(len(data))}
+	if store {
+		if _, err = ims.BlobStorage.Add(bytes.NewReader(data), dbm); err != nil {
+			ims.logger.Warn("Could not store image rendition ", did, ", err=", err)
+		} else if sbm := ims.BlobStorage.ReadMeta(did); sbm != nil {
+			dbm = sbm
+		}
+	}
+	if dbm.Checksum == "" {
+		dbm.Checksum = strconv.FormatUint(uint64(crc32.ChecksumIEEE(data)), 16)
+	}
+	return img_mem_reader{bytes.NewReader(data)}, dbm, nil
+}
+
+// Returns the MIME type for the image format
+func GetImageContentType(format byte) string {
+	switch format {
+	case IMG_FRMT_PNG:
+		return "image/png"
+	case IMG_FRMT_WEBP:
+		return "image/webp"
+	default:
+		return "image/jpeg"
+	}
+}
+
+// Returns the file extension for the image format
+func GetImageFileExt(format byte) string {
+	if format == IMG_FRMT_WEBP {
+		return ".webp"
+	}
+	if ext, ok := storedFormats[format]; ok {
+		return ext
+	}
+	return ".jpeg"
+}
+
+// Returns whether the w x h box is one of the standard sizes, 0x0 (the
+// original size) is the standard one as well
+func isStdSize(w, h int) bool {
+	for _, sc := range sizeCodes {
+		sw, sh := getDimensionsBySizeCode(sc)
+		if sw == w && sh == h {
+			return true
+		}
+	}
+	return false
+}
+
+func (ims *ImageService) capDimensions(w, h int) (int, int) {
+	if w > ims.Config.ImgsMaxWidth {
+		w = ims.Config.ImgsMaxWidth
+	}
+	if h > ims.Config.ImgsMaxHeight {
+		h = ims.Config.ImgsMaxHeight
+	}
+	return w, h
+}
+
+func encodeImage(img image.Image, format byte) ([]byte, error) {
+	bb := bytes.NewBuffer([]byte{})
+	var err error
+	switch format {
+	case IMG_FRMT_JPEG:
+		err = jpeg.Encode(bb, img, nil)
+	case IMG_FRMT_PNG:
+		err = png.Encode(bb, img)
+	case IMG_FRMT_WEBP:
+		err = webp.Encode(bb, img, &webp.Options{Quality: cWebpQuality})
+	default:
+		err = common.NewError(common.ERR_INVALID_VAL, "Unsupported image format "+string(format))
+	}
+	return bb.Bytes(), err
+}
+
+func (r img_mem_reader) Close() error {
+	return nil
+}
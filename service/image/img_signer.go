@@ -0,0 +1,87 @@
+package image
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+	"time"
+
+	"github.com/pixty/console/common"
+)
+
+// The signed image variants (endpoints). The signature made for one variant
+// doesn't work for others, so a frame URL cannot be used to get the frame
+// annotated by the persons names.
+const (
+	IMG_VARIANT_IMAGE     = ""
+	IMG_VARIANT_ANNOTATED = "annotated"
+)
+
+// The image URL signature is HMAC-SHA256 of
+// "<fileName>:<variant>:<orgId>:<expires>", where the orgId is the
+// organization of the camera the image is taken by and expires is unix time
+// in seconds.
+type ImgSignature struct {
+	OrgId   int64
+	Expires int64
+	Sig     string
+}
+
+// Returns the signature for the image file name variant, or nil if signing is off
+// or the image organization is not known. The expiration time is rounded
+// to ImgsSignTTLSec, so the same image gets the same URL for a while and
+// browsers can cache it.
+func (ims *ImageService) SignFileName(fileName, variant string) *ImgSignature {
+	key := ims.Config.ImgsSignKey
+	if key == "" {
+		return nil
+	}
+	orgId := ims.getOrgIdByFileName(fileName)
+	if orgId <= 0 {
+		return nil
+	}
+
+	ttl := int64(ims.Config.ImgsSignTTLSec)
+	exp := (time.Now().Unix()/ttl + 2) * ttl
+	return &ImgSignature{OrgId: orgId, Expires: exp, Sig: signFileName(key, fileName, variant, orgId, exp)}
+}
+
+// Checks the image file name variant signature. Returns ERR_UNAUTHORIZED if
+// the signature is wrong, expired or the image doesn't belong to the
+// signature org.
+func (ims *ImageService) CheckFileNameSignature(fileName, variant string, is *ImgSignature) error {
+	key := ims.Config.ImgsSignKey
+	if key == "" {
+		return common.NewError(common.ERR_UNAUTHORIZED, "Signed image URLs are not supported.")
+	}
+	if is.Expires < time.Now().Unix() {
+		return common.NewError(common.ERR_UNAUTHORIZED, "The image URL is expired.")
+	}
+
+	exp := signFileName(key, fileName, variant, is.OrgId, is.Expires)
+	if !hmac.Equal([]byte(exp), []byte(is.Sig)) {
+		ims.logger.Warn("Wrong signature for image ", fileName, ", orgId=", is.OrgId)
+		return common.NewError(common.ERR_UNAUTHORIZED, "Wrong image URL signature.")
+	}
+
+	// the camera could be moved to another org after the URL was signed
+	if ims.getOrgIdByFileName(fileName) != is.OrgId {
+		return common.NewError(common.ERR_UNAUTHORIZED, "The image doesn't belong to the organization.")
+	}
+	return nil
+}
+
+func (ims *ImageService) getOrgIdByFileName(fileName string) int64 {
+	var imd ImgDesc
+	if imd.ParseFileName(fileName) != nil {
+		return -1
+	}
+	return ims.C2oCache.GetOrgId(imd.CamId)
+}
+
+func signFileName(key, fileName, variant string, orgId, expires int64) string {
+	mac := hmac.New(sha256.New, []byte(key))
+	mac.Write([]byte(fileName + ":" + variant + ":" + strconv.FormatInt(orgId, 10) + ":" + strconv.FormatInt(expires, 10)))
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,103 @@
+package image
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pixty/console/common"
+)
+
+// camId -> orgId
+type test_c2o_cache map[int64]int64
+
+func (c test_c2o_cache) GetOrgId(camId int64) int64 {
+	if orgId, ok := c[camId]; ok {
+		return orgId
+	}
+	return -1
+}
+
+func initSigner(key string) *ImageService {
+	ims := NewImageService()
+	ims.Config = common.NewConsoleConfig()
+	ims.Config.ImgsSignKey = key
+	ims.C2oCache = test_c2o_cache{1: 10, 2: 20}
+	return ims
+}
+
+func TestSignFileName(t *testing.T) {
+	ims := initSigner("secret")
+	tests := []struct {
+		fn     string
+		orgId  int64
+		signed bool
+	}{
+		{"cm_1_123.jpeg", 10, true},
+		{"cm_2_123_10-10-50-50.png", 20, true},
+		{"tcm_1_123.jpeg", 10, true},
+		// unknown camera
+		{"cm_3_123.jpeg", 0, false},
+		{"cm_1_123.gif", 0, false},
+		{"wrong", 0, false},
+	}
+	for _, tst := range tests {
+		is := ims.SignFileName(tst.fn, IMG_VARIANT_IMAGE)
+		if (is != nil) != tst.signed {
+			t.Fatal("Unexpected signature ", is, " of ", tst.fn)
+		}
+		if is == nil {
+			continue
+		}
+		if is.OrgId != tst.orgId || is.Expires <= time.Now().Unix() || is.Sig == "" {
+			t.Fatal("Wrong signature ", is, " of ", tst.fn)
+		}
+		if err := ims.CheckFileNameSignature(tst.fn, IMG_VARIANT_IMAGE, is); err != nil {
+			t.Fatal("The signature of ", tst.fn, " must be valid, err=", err)
+		}
+	}
+
+	if initSigner("").SignFileName("cm_1_123.jpeg", IMG_VARIANT_IMAGE) != nil {
+		t.Fatal("Signing is off without the key")
+	}
+}
+
+func TestCheckFileNameSignature(t *testing.T) {
+	ims := initSigner("secret")
+	fn := "cm_1_123.jpeg"
+	is := ims.SignFileName(fn, IMG_VARIANT_IMAGE)
+	expired := &ImgSignature{OrgId: 10, Expires: time.Now().Unix() - 1}
+	expired.Sig = signFileName("secret", fn, IMG_VARIANT_IMAGE, expired.OrgId, expired.Expires)
+
+	tests := []struct {
+		name    string
+		ims     *ImageService
+		fn      string
+		variant string
+		is      *ImgSignature
+		valid   bool
+	}{
+		{"valid", ims, fn, IMG_VARIANT_IMAGE, is, true},
+		{"other variant", ims, fn, IMG_VARIANT_ANNOTATED, is, false},
+		{"other image", ims, "cm_1_124.jpeg", IMG_VARIANT_IMAGE, is, false},
+		{"other key", initSigner("other"), fn, IMG_VARIANT_IMAGE, is, false},
+		{"no key", initSigner(""), fn, IMG_VARIANT_IMAGE, is, false},
+		{"other org", ims, fn, IMG_VARIANT_IMAGE, &ImgSignature{OrgId: 20, Expires: is.Expires, Sig: is.Sig}, false},
+		{"longer expiration", ims, fn, IMG_VARIANT_IMAGE, &ImgSignature{OrgId: 10, Expires: is.Expires + 1, Sig: is.Sig}, false},
+		{"expired", ims, fn, IMG_VARIANT_IMAGE, expired, false},
+	}
+	for _, tst := range tests {
+		err := tst.ims.CheckFileNameSignature(tst.fn, tst.variant, tst.is)
+		if (err == nil) != tst.valid {
+			t.Fatal("Test \"", tst.name, "\": unexpected check result, err=", err)
+		}
+		if err != nil && !common.CheckError(err, common.ERR_UNAUTHORIZED) {
+			t.Fatal("Test \"", tst.name, "\": expecting ERR_UNAUTHORIZED, but err=", err)
+		}
+	}
+
+	// the camera is moved to another org after the URL was signed
+	ims.C2oCache = test_c2o_cache{1: 20}
+	if ims.CheckFileNameSignature(fn, IMG_VARIANT_IMAGE, is) == nil {
+		t.Fatal("The image doesn't belong to the signature org anymore")
+	}
+}
@@ -0,0 +1,113 @@
+package scene
+
+import (
+	"github.com/pixty/console/common"
+	"github.com/pixty/console/model"
+)
+
+type (
+	// face_retention decides whether the face of a person seen by a camera
+	// should be stored. The person history is kept in the person_desc, the
+	// policy updates it when the face is accepted.
+	face_retention interface {
+		add_if_needed(pd *person_desc, face *model.Face) bool
+	}
+
+	// the faces of new persons are stored, then the faces which are better
+	// than the last stored one or captured long after it
+	default_retention struct {
+		newPersonFaces int
+		maxFaces       int
+		minIntervalMs  uint64
+		maxIntervalMs  uint64
+	}
+
+	// one face per the interval
+	time_sampled_retention struct {
+		intervalMs uint64
+	}
+
+	// the face is stored if it is one of the bestN faces seen for the
+	// interval so far. Already stored faces are not removed, so the number of
+	// faces stored per interval could be bigger than bestN, the faces sweeper
+	// drops the worse ones later.
+	best_n_retention struct {
+		bestN      int
+		intervalMs uint64
+	}
+
+	keep_all_retention struct{}
+)
+
+// returns nil if the policy is not known
+func new_face_retention(fr *common.FaceRetention) face_retention {
+	switch fr.Policy {
+	case common.FACE_RETENTION_TIME_SAMPLED:
+		return &time_sampled_retention{intervalMs: uint64(fr.MinIntervalSec) * 1000}
+	case common.FACE_RETENTION_BEST_N:
+		return &best_n_retention{bestN: fr.BestN, intervalMs: uint64(fr.MaxIntervalSec) * 1000}
+	case common.FACE_RETENTION_KEEP_ALL:
+		return keep_all_retention{}
+	case common.FACE_RETENTION_DEFAULT:
+		return &default_retention{newPersonFaces: fr.NewPersonFaces, maxFaces: fr.MaxFaces,
+			minIntervalMs: uint64(fr.MinIntervalSec) * 1000, maxIntervalMs: uint64(fr.MaxIntervalSec) * 1000}
+	}
+	return nil
+}
+
+func (dr *default_retention) add_if_needed(pd *person_desc, face *model.Face) bool {
+	if pd.faces == 0 || (pd.newPerson && pd.faces < dr.newPersonFaces) {
+		pd.newFace(face)
+		return true
+	}
+
+	diff := pd.sinceLastFace(face)
+	if pd.faces > dr.maxFaces && diff < dr.minIntervalMs {
+		return false
+	}
+
+	if face.Quality > pd.lastFace.Quality || diff > dr.maxIntervalMs {
+		pd.newFace(face)
+		return true
+	}
+	return false
+}
+
+func (tr *time_sampled_retention) add_if_needed(pd *person_desc, face *model.Face) bool {
+	if pd.faces == 0 || pd.sinceLastFace(face) >= tr.intervalMs {
+		pd.newFace(face)
+		return true
+	}
+	return false
+}
+
+func (br *best_n_retention) add_if_needed(pd *person_desc, face *model.Face) bool {
+	if pd.faces == 0 || face.CapturedAt < pd.windowStart || face.CapturedAt-pd.windowStart >= br.intervalMs {
+		pd.windowStart = face.CapturedAt
+		pd.windowBest = pd.windowBest[:0]
+	}
+
+	// windowBest is sorted by quality descending
+	n := len(pd.windowBest)
+	if n >= br.bestN && face.Quality <= pd.windowBest[n-1] {
+		return false
+	}
+	if n >= br.bestN {
+		n--
+		pd.windowBest = pd.windowBest[:n]
+	}
+	i := 0
+	for i < n && pd.windowBest[i] >= face.Quality {
+		i++
+	}
+	pd.windowBest = append(pd.windowBest, 0)
+	copy(pd.windowBest[i+1:], pd.windowBest[i:n])
+	pd.windowBest[i] = face.Quality
+	pd.newFace(face)
+	return true
+}
+
+func (kr keep_all_retention) add_if_needed(pd *person_desc, face *model.Face) bool {
+	pd.newFace(face)
+	return true
+}
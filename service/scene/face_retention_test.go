@@ -0,0 +1,103 @@
+package scene
+
+import (
+	"testing"
+
+	"github.com/pixty/console/common"
+	"github.com/pixty/console/model"
+)
+
+type test_face struct {
+	capturedAt uint64
+	quality    float32
+	added      bool
+}
+
+func TestFaceRetention(t *testing.T) {
+	tests := []struct {
+		name      string
+		fr        common.FaceRetention
+		newPerson bool
+		faces     []test_face
+	}{
+		{"default", common.FaceRetention{Policy: common.FACE_RETENTION_DEFAULT, NewPersonFaces: 2, MaxFaces: 3, MinIntervalSec: 1, MaxIntervalSec: 10}, true,
+			[]test_face{
+				{0, 0.5, true},
+				// new person faces are stored anyway
+				{100, 0.1, true},
+				{200, 0.2, true},
+				{300, 0.1, false},
+				{400, 0.3, true},
+				// too many faces for the min interval
+				{500, 0.9, false},
+				{1500, 0.9, true},
+				{5000, 0.1, false},
+				// the max interval passed
+				{11600, 0.1, true},
+			}},
+		{"default, known person", common.FaceRetention{Policy: common.FACE_RETENTION_DEFAULT, NewPersonFaces: 2, MaxFaces: 3, MinIntervalSec: 1, MaxIntervalSec: 10}, false,
+			[]test_face{
+				{0, 0.5, true},
+				{100, 0.1, false},
+				{200, 0.6, true},
+			}},
+		{"timesampled", common.FaceRetention{Policy: common.FACE_RETENTION_TIME_SAMPLED, MinIntervalSec: 1}, true,
+			[]test_face{
+				{0, 0.1, true},
+				{500, 0.9, false},
+				{999, 0.9, false},
+				{1000, 0.1, true},
+				// out of order
+				{100, 0.9, false},
+				{1500, 0.9, false},
+				{2100, 0.1, true},
+			}},
+		{"bestn", common.FaceRetention{Policy: common.FACE_RETENTION_BEST_N, BestN: 2, MaxIntervalSec: 10}, true,
+			[]test_face{
+				{0, 0.5, true},
+				{1, 0.4, true},
+				{2, 0.3, false},
+				{3, 0.6, true},
+				{4, 0.5, false},
+				{5, 0.55, true},
+				{6, 0.5, false},
+				// new interval
+				{10000, 0.1, true},
+				{10001, 0.2, true},
+				{10002, 0.1, false},
+				// out of order restarts the interval
+				{5, 0.1, true},
+			}},
+		{"keepall", common.FaceRetention{Policy: common.FACE_RETENTION_KEEP_ALL}, false,
+			[]test_face{
+				{0, 0.1, true},
+				{0, 0.1, true},
+				{1, 0.1, true},
+			}},
+	}
+
+	for _, tst := range tests {
+		fr := new_face_retention(&tst.fr)
+		if fr == nil {
+			t.Fatal("Test \"", tst.name, "\": no policy for ", tst.fr.Policy)
+		}
+		pd := &person_desc{newPerson: tst.newPerson}
+		stored := 0
+		for i, tf := range tst.faces {
+			face := &model.Face{CapturedAt: tf.capturedAt, Quality: tf.quality}
+			if fr.add_if_needed(pd, face) != tf.added {
+				t.Fatal("Test \"", tst.name, "\": face #", i, " should be added=", tf.added)
+			}
+			if tf.added {
+				stored++
+			}
+			if pd.faces != stored {
+				t.Fatal("Test \"", tst.name, "\": the person should have ", stored, " faces, but ", pd.faces)
+			}
+		}
+	}
+
+	if new_face_retention(&common.FaceRetention{Policy: "unknown"}) != nil {
+		t.Fatal("Unknown policy must not be created")
+	}
+}
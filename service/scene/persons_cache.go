@@ -5,6 +5,8 @@ import (
 	"time"
 
 	"github.com/jrivets/gorivets"
+	"github.com/jrivets/log4g"
+	"github.com/pixty/console/common"
 	"github.com/pixty/console/model"
 )
 
@@ -12,6 +14,15 @@ type (
 	persons_cache struct {
 		lock  sync.Mutex
 		cache gorivets.LRU
+		cfg   *common.ConsoleConfig
+		// camId -> the camera faces retention policy
+		policies map[int64]*cam_retention
+		logger   log4g.Logger
+	}
+
+	cam_retention struct {
+		policy face_retention
+		ttlMs  uint64
 	}
 
 	person_desc struct {
@@ -21,33 +32,73 @@ type (
 		newPerson bool
 		lastFace  *model.Face
 		faces     int
+		// the face the new person picture is made of
+		avatar *model.Face
+		// capture time of the last face seen, stored or not
+		seenAt uint64
+		// bestn policy: the interval start and qualities of the best faces
+		windowStart uint64
+		windowBest  []float32
 	}
 )
 
-func new_persons_cache(cache_ttl time.Duration) *persons_cache {
+// The cache keeps the persons for the biggest retention policy TTL, the
+// smaller camera TTLs are checked by the face capture time.
+func new_persons_cache(cfg *common.ConsoleConfig) *persons_cache {
 	pc := new(persons_cache)
-	pc.cache = gorivets.NewTtlLRU(10000, cache_ttl, nil)
+	pc.cfg = cfg
+	pc.policies = make(map[int64]*cam_retention)
+	pc.logger = log4g.GetLogger("pixty.PersonsCache")
+
+	ttl := cfg.ScnFaceRetention.CacheTTLSec
+	for _, fr := range cfg.ScnCamFaceRetentions {
+		if fr != nil && fr.CacheTTLSec > ttl {
+			ttl = fr.CacheTTLSec
+		}
+	}
+	pc.cache = gorivets.NewTtlLRU(10000, time.Duration(ttl)*time.Second, nil)
 	return pc
 }
 
-func (pc *persons_cache) should_be_added(face *model.Face) bool {
+func (pc *persons_cache) should_be_added(camId int64, face *model.Face) bool {
 	pc.lock.Lock()
 	defer pc.lock.Unlock()
 
+	cr := pc.get_cam_retention(camId)
 	var pd *person_desc
 	inf, ok := pc.cache.Get(face.PersonId)
-	if !ok {
-		// this is the first time we met the face for the LRU TTL, so add it no problem
+	if ok {
+		pd = inf.(*person_desc)
+	}
+	if !ok || (face.CapturedAt > pd.seenAt && face.CapturedAt-pd.seenAt > cr.ttlMs) {
+		// this is the first time we met the face for the TTL, so add it no problem
 		pd = new(person_desc)
 		pc.cache.Add(face.PersonId, pd, 1)
-	} else {
-		pd = inf.(*person_desc)
 	}
+	pd.seenAt = face.CapturedAt
 
-	return pd.addIfNeeded(face)
+	return cr.policy.add_if_needed(pd, face)
 }
 
-func (pc *persons_cache) mark_person_as_new(personId string) {
+func (pc *persons_cache) get_cam_retention(camId int64) *cam_retention {
+	if cr, ok := pc.policies[camId]; ok {
+		return cr
+	}
+
+	fr := pc.cfg.GetFaceRetention(camId)
+	policy := new_face_retention(fr)
+	if policy == nil {
+		pc.logger.Warn("Unknown faces retention policy \"", fr.Policy, "\" for camId=", camId, ", will use default one.")
+		fr.Policy = common.FACE_RETENTION_DEFAULT
+		policy = new_face_retention(fr)
+	}
+	pc.logger.Info("Faces retention policy for camId=", camId, " is ", fr)
+	cr := &cam_retention{policy: policy, ttlMs: uint64(fr.CacheTTLSec) * 1000}
+	pc.policies[camId] = cr
+	return cr
+}
+
+func (pc *persons_cache) mark_person_as_new(personId string, avatar *model.Face) {
 	pc.lock.Lock()
 	defer pc.lock.Unlock()
 
@@ -55,30 +106,53 @@ func (pc *persons_cache) mark_person_as_new(personId string) {
 	if ok {
 		pd := inf.(*person_desc)
 		pd.newPerson = true
+		pd.avatar = avatar
 	}
 }
 
-func (pd *person_desc) newFace(face *model.Face) {
-	pd.lastFace = face
-	pd.faces++
+// checks whether the face of the new person is better than its picture. The
+// cache is not changed, set_avatar() is called when the new picture is
+// persisted.
+func (pc *persons_cache) is_better_avatar(face *model.Face) bool {
+	pc.lock.Lock()
+	defer pc.lock.Unlock()
+
+	pd := pc.get_new_person(face.PersonId)
+	return pd != nil && pd.avatar != nil && face.Quality > pd.avatar.Quality
 }
 
-func (pd *person_desc) addIfNeeded(face *model.Face) bool {
-	if pd.faces == 0 || (pd.newPerson && pd.faces < 3) {
-		pd.newFace(face)
-		return true
-	}
+// the face becomes the new person avatar, if it is better than the current one
+func (pc *persons_cache) set_avatar(face *model.Face) {
+	pc.lock.Lock()
+	defer pc.lock.Unlock()
 
-	// hardcoded 0.5 minute diff
-	diff := face.CapturedAt - pd.lastFace.CapturedAt
-	if pd.faces > 5 && diff < 30000 {
-		return false
+	pd := pc.get_new_person(face.PersonId)
+	if pd != nil && (pd.avatar == nil || face.Quality > pd.avatar.Quality) {
+		pd.avatar = face
 	}
+}
 
-	if face.Rect.Area() > pd.lastFace.Rect.Area() || diff > 120000 {
-		pd.newFace(face)
-		return true
+func (pc *persons_cache) get_new_person(personId string) *person_desc {
+	inf, ok := pc.cache.Peek(personId)
+	if !ok {
+		return nil
 	}
+	pd := inf.(*person_desc)
+	if !pd.newPerson {
+		return nil
+	}
+	return pd
+}
+
+func (pd *person_desc) newFace(face *model.Face) {
+	pd.lastFace = face
+	pd.faces++
+}
 
-	return false
+// returns time in milliseconds between the last stored face and the face
+func (pd *person_desc) sinceLastFace(face *model.Face) uint64 {
+	if face.CapturedAt < pd.lastFace.CapturedAt {
+		return 0
+	}
+	return face.CapturedAt - pd.lastFace.CapturedAt
 }
@@ -16,6 +16,7 @@ import (
 	"github.com/pixty/console/common"
 	"github.com/pixty/console/common/fpcp"
 	"github.com/pixty/console/model"
+	"github.com/pixty/console/service"
 	"github.com/pixty/console/service/matcher"
 	"golang.org/x/net/context"
 )
@@ -27,11 +28,17 @@ type (
 		CConfig      *common.ConsoleConfig  `inject:""`
 		ImageService *imageSrv.ImageService `inject:""`
 		Matcher      matcher.Matcher        `inject:"matcher"`
+		Dc           service.DataController `inject:""`
 		logger       log4g.Logger
 		cpCache      *cam_pictures_cache
 		persCache    *persons_cache
-		// Cutting faces border size
-		border int
+		scnQueue     *scene_queue
+		scnDedup     *scenes_dedup
+		// nil if the spool is off
+		spool *scene_spool
+		// camId -> the last camera settings read from DB
+		camsLock  sync.Mutex
+		knownCams map[int64]*known_camera
 	}
 
 	SceneTimeline struct {
@@ -53,18 +60,27 @@ type (
 		deleted *list.List
 		dead    *list.List
 	}
+
+	// The last known camera settings, they are used while the DB is not
+	// available
+	known_camera struct {
+		cam *model.Camera
+		// the DB is not asked for the camera before the time
+		retryAt time.Time
+	}
 )
 
+// how long the last known camera settings are used after a DB error
+const cCamRetryTo = 10 * time.Second
+
 func NewSceneProcessor() *SceneProcessor {
 	sp := new(SceneProcessor)
 	sp.logger = log4g.GetLogger("pixty.SceneProcessor")
-	sp.border = 20
 	sp.cpCache = new(cam_pictures_cache)
 	sp.cpCache.camPics = gorivets.NewTtlLRU(1000, time.Minute, sp.cpCache.on_delete)
 	sp.cpCache.deleted = list.New()
 	sp.cpCache.dead = list.New()
-	// keep a person information for 5 minutes to reduce the number of faces to be stored
-	sp.persCache = new_persons_cache(time.Minute * time.Duration(5))
+	sp.knownCams = make(map[int64]*known_camera)
 	return sp
 }
 
@@ -76,6 +92,13 @@ func (sp *SceneProcessor) DiPhase() int {
 
 func (sp *SceneProcessor) DiInit() error {
 	sp.logger.Info("DiInit()")
+	// keeps the persons information to reduce the number of faces to be stored
+	sp.persCache = new_persons_cache(sp.CConfig)
+	sp.scnDedup = new_scenes_dedup(time.Duration(sp.CConfig.ScnDedupWindowSec) * time.Second)
+	sp.scnQueue = new_scene_queue(sp.CConfig.ScnQueueSize, sp.ingestScene)
+	sp.logger.Info("Starting ", sp.CConfig.ScnWorkers, " scene workers, queue size is ", sp.CConfig.ScnQueueSize, " per camera")
+	sp.scnQueue.start(sp.CConfig.ScnWorkers, sp.logger)
+	sp.initSpool()
 	sp.ImageService.DeleteAllTmpFiles()
 
 	go func() {
@@ -85,6 +108,7 @@ func (sp *SceneProcessor) DiInit() error {
 			select {
 			case <-time.After(sleepTime):
 				sp.cpCache.on_sweep(sp.ImageService)
+				sp.scnDedup.sweep()
 			case <-sp.MainCtx.Done():
 				sp.logger.Info("Shutting down cleaning temporary images loop")
 				return
@@ -95,12 +119,54 @@ func (sp *SceneProcessor) DiInit() error {
 }
 
 func (sp *SceneProcessor) DiShutdown() {
-	sp.logger.Info("Shutting down.")
+	sp.logger.Info("Shutting down, processing the queued scenes.")
+	// the scenes are acknowledged to the cameras, so they are not lost
+	if left := sp.scnQueue.close(cScnQueueDrainTO); left > 0 {
+		sp.logger.Error("Could not process ", left, " queued scenes in ", cScnQueueDrainTO, ", they are lost.")
+	}
+	total, _ := sp.scnQueue.get_stats()
+	sp.logger.Info("Scenes ingest totals ", total)
 }
 
 // ------------------------------- Public ------------------------------------
-// Handles scene object which is sent by FP. Returns error only in case of the
-// packet is not properly formed.
+// Puts the scene into the camera queue for processing by the workers. Returns
+// ERR_INVALID_VAL if the packet is not properly formed and ERR_LIMIT_VIOLATION
+// if the camera queue is full, so the frame processor should slow down. The
+// scene frame, which is received again within ScnDedupWindowSec, is ignored
+// and nil is returned, so the frame processor retries are safe.
+func (sp *SceneProcessor) EnqueueScene(camId int64, scene *fpcp.Scene) error {
+	if scene == nil || scene.Frame == nil {
+		sp.logger.Error("Got wrong Scene packet from camId=", camId, ", the scene or the frame is nil")
+		return common.NewError(common.ERR_INVALID_VAL, "Wrong packet")
+	}
+
+	key := scene_key(camId, scene)
+	if !sp.scnDedup.check_and_add(key) {
+		sp.logger.Debug("Ignoring scene id=", scene.Id, " frameId=", scene.Frame.Id, " from camId=", camId, ", it was received already")
+		sp.scnQueue.on_duplicate(camId)
+		return nil
+	}
+	err := sp.scnQueue.push(camId, scene)
+	if err != nil {
+		sp.logger.Warn("Dropping scene id=", scene.Id, ", err=", err)
+		sp.scnDedup.forget(key)
+	}
+	return err
+}
+
+// Returns how many scenes of the camera can be queued now, so the frame
+// processor gets them as the flow control credits
+func (sp *SceneProcessor) GetCamQueueFree(camId int64) int {
+	return sp.scnQueue.get_free(camId)
+}
+
+// Returns the scenes ingest counters: the totals and the ones per camera
+func (sp *SceneProcessor) GetIngestStats() (*IngestStats, []*IngestStats) {
+	return sp.scnQueue.get_stats()
+}
+
+// Handles scene object which is sent by FP. Returns error if the packet is
+// not properly formed, or the scene could be neither persisted nor spooled.
 func (sp *SceneProcessor) OnFPCPScene(camId int64, scene *fpcp.Scene) error {
 	sp.logger.Debug("Got new scene from camId=", camId, " with ", scene.Persons, " persons on the scene")
 
@@ -115,10 +181,19 @@ func (sp *SceneProcessor) OnFPCPScene(camId int64, scene *fpcp.Scene) error {
 		return err
 	}
 
+	cam := sp.getCamera(camId)
+	if !cam.Ingest.IsActive(common.Timestamp(scene.Frame.Timestamp).ToTime()) {
+		sp.logger.Debug("Drop the scene id=", scene.Id, " from camId=", camId, ", it is out of the camera active hours.")
+		sp.scnQueue.count_rejected(camId, true, 0, 0)
+		return nil
+	}
+
 	// Filtering faces through the cache. Some faces can be rejected due to the cache rules
 	f2f := make(map[int]*model.Face)
 	if len(scene.Faces) > 0 {
+		frame := newFrameDecoder(scene.Frame.Pictures)
 		skpdPers := make([]string, 0, 1)
+		small, outOfZones := 0, 0
 		for i, f := range scene.Faces {
 			// toFace sets PersonId, Rect and V128D
 			face, err := sp.toFace(f)
@@ -126,11 +201,22 @@ func (sp *SceneProcessor) OnFPCPScene(camId int64, scene *fpcp.Scene) error {
 				sp.logger.Warn("Error while parsing face for camId=", camId, ", err=", err)
 				return err
 			}
+			if !cam.Ingest.IsBigEnough(face.Rect) {
+				sp.logger.Debug("Drop the face for personId=", face.PersonId, ", it is too small.")
+				small++
+				continue
+			}
+			if !cam.Zones.Accept(face.Rect) {
+				sp.logger.Debug("Drop the face for personId=", face.PersonId, ", it is out of the camera zones.")
+				outOfZones++
+				continue
+			}
 			face.CapturedAt = scene.Frame.Timestamp
 			face.SceneId = scene.Id
+			face.Quality = sp.faceQuality(face, f.Pictures, frame)
 
 			// check whether we have to persist the face?
-			if sp.persCache.should_be_added(face) {
+			if sp.persCache.should_be_added(camId, face) {
 				f2f[i] = face
 			} else {
 				sp.logger.Debug("Drop the face for personId=", face.PersonId, ", by the cache rules.")
@@ -142,6 +228,9 @@ func (sp *SceneProcessor) OnFPCPScene(camId int64, scene *fpcp.Scene) error {
 			// update last seen time
 			sp.updateLastSeenTime(skpdPers, scene.Frame.Timestamp)
 		}
+		if small > 0 || outOfZones > 0 {
+			sp.scnQueue.count_rejected(camId, false, small, outOfZones)
+		}
 	}
 
 	// Now we checks should we store the frame picture permanently or just temporary
@@ -153,7 +242,7 @@ func (sp *SceneProcessor) OnFPCPScene(camId int64, scene *fpcp.Scene) error {
 	imgFrameFN, err := sp.savePictures(pfx, camId, frameId, nil, scene.Frame.Pictures)
 	if err != nil {
 		sp.logger.Warn("Could not save frame pictures err=", err)
-		return nil
+		return err
 	}
 	sp.cpCache.set_cam_image(camId, imgFrameFN)
 
@@ -169,26 +258,47 @@ func (sp *SceneProcessor) OnFPCPScene(camId int64, scene *fpcp.Scene) error {
 			mr := face.Rect
 			r := image.Rect(mr.LeftTop.X, mr.LeftTop.Y, mr.RightBottom.X, mr.RightBottom.Y)
 
-			// save face pics
-			imgFn, err := sp.savePictures(pfx, camId, frameId, &r, f.Pictures)
+			// save face pics, or they will be cut from the frame when requested
+			var imgFn string
+			if sp.CConfig.ImgsCropOnDemand {
+				imgFn, err = sp.ImageService.GetFaceFileName(imgFrameFN, r)
+			} else {
+				imgFn, err = sp.savePictures(pfx, camId, frameId, &r, f.Pictures)
+			}
 			if err != nil {
 				sp.logger.Warn("Could not save a face pictures err=", err)
-				return nil
+				return err
 			}
 			face.ImageId = imgFrameFN
 			face.FaceImageId = imgFn
 		}
 
 		// Looks good now, trying to store the data to DB
-		err = sp.persistSceneFaces(camId, faces)
-		if err != nil {
-			sp.logger.Warn("Got the error while saving faces(", len(faces), ") to DB: err=", err, ", ignoring the scene :(")
-		}
+		return sp.persistOrSpool(camId, faces)
 	}
 
 	return nil
 }
 
+// Returns the scenes ingest counters of the camera
+func (sp *SceneProcessor) GetCamIngestStats(camId int64) *IngestStats {
+	return sp.scnQueue.get_cam_stats(camId)
+}
+
+// Returns the scenes spool counters, nil if the spool is off
+func (sp *SceneProcessor) GetSpoolStats() *SpoolStats {
+	if sp.spool == nil {
+		return nil
+	}
+	return sp.spool.get_stats()
+}
+
+// Returns true if there are scenes, which are not persisted yet. Their
+// face images are stored, but don't have picture records.
+func (sp *SceneProcessor) HasSpooledScenes() bool {
+	return sp.spool != nil && !sp.spool.is_empty()
+}
+
 // Returns scene timeline object
 func (sp *SceneProcessor) GetTimelineView(camId int64, maxTs common.Timestamp, limit int) (*SceneTimeline, error) {
 	pp, err := sp.Persister.GetPartitionTx("FAKE")
@@ -276,6 +386,102 @@ func (sp *SceneProcessor) GetTimelineView(camId int64, maxTs common.Timestamp, l
 }
 
 // ------------------------------ Private ------------------------------------
+// Returns the camera settings. If the camera cannot be read, the last known
+// settings are used and the DB is not asked again for cCamRetryTo, so the
+// scenes are filtered as before the DB outage.
+func (sp *SceneProcessor) getCamera(camId int64) *model.Camera {
+	now := time.Now()
+	sp.camsLock.Lock()
+	kc, ok := sp.knownCams[camId]
+	sp.camsLock.Unlock()
+	if ok && now.Before(kc.retryAt) {
+		return kc.cam
+	}
+
+	cam, err := sp.Dc.GetCachedCamera(camId)
+	if err == nil {
+		sp.camsLock.Lock()
+		sp.knownCams[camId] = &known_camera{cam: cam}
+		sp.camsLock.Unlock()
+		return cam
+	}
+
+	if ok {
+		cam = kc.cam
+		sp.logger.Warn("Could not read camId=", camId, ", using the last known settings, err=", err)
+	} else {
+		cam = &model.Camera{Id: camId}
+		sp.logger.Warn("Could not read camId=", camId, ", no settings are known, err=", err)
+	}
+	sp.camsLock.Lock()
+	sp.knownCams[camId] = &known_camera{cam: cam, retryAt: now.Add(cCamRetryTo)}
+	sp.camsLock.Unlock()
+	return cam
+}
+
+// processes the queued scene, the scene could be sent again if it is failed
+func (sp *SceneProcessor) ingestScene(camId int64, scene *fpcp.Scene) error {
+	err := sp.OnFPCPScene(camId, scene)
+	if err != nil {
+		sp.scnDedup.forget(scene_key(camId, scene))
+	}
+	return err
+}
+
+func (sp *SceneProcessor) initSpool() {
+	if sp.CConfig.ScnSpoolDir == "" {
+		sp.logger.Info("Scenes spool is off, the scenes will be lost if the DB is not available")
+		return
+	}
+	spool := new_scene_spool(sp.CConfig.ScnSpoolDir, sp.CConfig.GetScnSpoolMaxSizeBytes())
+	if err := spool.open(); err != nil {
+		sp.logger.Error("Could not open scenes spool in ", sp.CConfig.ScnSpoolDir, ", the spool is off. err=", err)
+		return
+	}
+	sp.spool = spool
+
+	go func() {
+		sp.logger.Info("Running scenes spool replay loop")
+		for {
+			select {
+			case <-time.After(cSpoolReplayTo):
+				spool.replay(sp.persistSceneFaces, sp.Persister.Ping)
+			case <-sp.MainCtx.Done():
+				sp.logger.Info("Shutting down scenes spool replay loop, ", spool.get_stats())
+				return
+			}
+		}
+	}()
+}
+
+// Persists the scene faces or puts them into the spool if the DB is not
+// available. While there are spooled scenes the new ones go to the spool
+// too, so the scenes are persisted in order. Returns the error if the faces
+// are neither persisted nor spooled.
+func (sp *SceneProcessor) persistOrSpool(camId int64, faces []*model.Face) error {
+	if sp.spool == nil || sp.spool.is_empty() {
+		err := sp.persistSceneFaces(camId, faces)
+		if err == nil {
+			return nil
+		}
+		if sp.spool == nil {
+			sp.logger.Warn("Got the error while saving faces(", len(faces), ") to DB: err=", err, ", ignoring the scene :(")
+			return err
+		}
+		if perr := sp.Persister.Ping(); perr == nil {
+			sp.logger.Warn("Got the error while saving faces(", len(faces), ") to DB, which is available: err=", err, ", ignoring the scene :(")
+			return err
+		}
+		sp.logger.Warn("Got the error while saving faces(", len(faces), ") to DB: err=", err, ", spooling the scene")
+	}
+
+	if err := sp.spool.put(camId, faces); err != nil {
+		sp.logger.Error("Could not spool faces(", len(faces), ") for camId=", camId, ", ignoring the scene :( err=", err)
+		return err
+	}
+	return nil
+}
+
 func (sp *SceneProcessor) updateLastSeenTime(persIds []string, captAt uint64) {
 	pp, err := sp.Persister.GetPartitionTx("FAKE")
 	if err != nil {
@@ -300,7 +506,10 @@ func (sp *SceneProcessor) persistSceneFaces(camId int64, faces []*model.Face) er
 	persIdMap := make(map[string]*model.Face)
 	for i, f := range faces {
 		persIds[i] = f.PersonId
-		persIdMap[f.PersonId] = f
+		// the best face of the person on the scene
+		if pf, ok := persIdMap[f.PersonId]; !ok || f.Quality > pf.Quality {
+			persIdMap[f.PersonId] = f
+		}
 	}
 
 	persons, err := pp.FindPersons(&model.PersonsQuery{PersonIds: persIds})
@@ -309,10 +518,20 @@ func (sp *SceneProcessor) persistSceneFaces(camId int64, faces []*model.Face) er
 		return err
 	}
 
+	// persons with better avatars and the faces the avatars are made of
+	avatars := make([]*model.Person, 0, 1)
+	avatarFaces := make([]*model.Face, 0, 1)
+	var newPers []*model.Person
 	if len(persons) > 0 {
 		exists := make([]string, len(persons))
 		for _, p := range persons {
 			exists = append(exists, p.Id)
+			if f, ok := persIdMap[p.Id]; ok && p.ProfileId <= 0 && sp.persCache.is_better_avatar(f) {
+				p.PictureId = f.FaceImageId
+				p.LastSeenAt = faces[0].CapturedAt
+				avatars = append(avatars, p)
+				avatarFaces = append(avatarFaces, f)
+			}
 			delete(persIdMap, p.Id)
 		}
 
@@ -326,7 +545,7 @@ func (sp *SceneProcessor) persistSceneFaces(camId int64, faces []*model.Face) er
 	npc := len(persIdMap)
 	if npc > 0 {
 		sp.logger.Info("Found ", npc, " new person(s) on ", camId, ", will persist them...")
-		newPers := make([]*model.Person, 0, npc)
+		newPers = make([]*model.Person, 0, npc)
 		createdAt := common.CurrentTimestamp()
 		for pid, f := range persIdMap {
 			p := new(model.Person)
@@ -335,10 +554,12 @@ func (sp *SceneProcessor) persistSceneFaces(camId int64, faces []*model.Face) er
 			p.CreatedAt = uint64(createdAt)
 			p.LastSeenAt = f.CapturedAt
 			p.PictureId = f.FaceImageId
+			// the person picture should outlive the frame
+			if err := sp.ImageService.KeepFaceImage(p.PictureId); err != nil {
+				sp.logger.Warn("Could not keep the picture ", p.PictureId, " of new person, err=", err)
+			}
 			persons = append(persons, p)
 			newPers = append(newPers, p)
-			// marks the person as seen first time on the scene (affects faces filtering)
-			sp.persCache.mark_person_as_new(p.Id)
 		}
 		err := pp.InsertPersons(newPers)
 		if err != nil {
@@ -355,7 +576,36 @@ func (sp *SceneProcessor) persistSceneFaces(camId int64, faces []*model.Face) er
 		return err
 	}
 
-	pp.Commit()
+	// new persons get better avatars while they are seen first time
+	newAvatars := make([]*model.Face, 0, len(avatars))
+	for i, p := range avatars {
+		if err := sp.ImageService.KeepFaceImage(p.PictureId); err != nil {
+			sp.logger.Warn("Could not keep the picture ", p.PictureId, " of person ", p.Id, ", err=", err)
+			continue
+		}
+		sp.logger.Debug("Updating avatar of the new person ", p.Id, " to ", p.PictureId)
+		if err := pp.UpdatePerson(p); err != nil {
+			sp.logger.Error("Could not update person ", p.Id, " avatar, err=", err)
+			pp.Rollback()
+			return err
+		}
+		newAvatars = append(newAvatars, avatarFaces[i])
+	}
+
+	err = pp.Commit()
+	if err != nil {
+		sp.logger.Error("Could not commit faces(", len(faces), ") of camId=", camId, ", err=", err)
+		return err
+	}
+
+	// the cache is updated when the persons are persisted only
+	for _, p := range newPers {
+		// marks the person as seen first time on the scene (affects faces filtering)
+		sp.persCache.mark_person_as_new(p.Id, persIdMap[p.Id])
+	}
+	for _, f := range newAvatars {
+		sp.persCache.set_avatar(f)
+	}
 	sp.Matcher.OnNewFaces(camId, persons, faces)
 
 	return nil
@@ -386,6 +636,49 @@ func (sp *SceneProcessor) savePicture(imDesc *imageSrv.ImgDesc, pic *fpcp.Pictur
 	return sp.ImageService.StoreImage(imDesc, bytes.NewReader(pic.Data))
 }
 
+// Evaluates the face quality on the biggest face picture, or on the face
+// region of the original frame, if there are no face pictures.
+func (sp *SceneProcessor) faceQuality(face *model.Face, pics []*fpcp.Picture, frame func() image.Image) float32 {
+	mr := face.Rect
+	r := image.Rect(mr.LeftTop.X, mr.LeftTop.Y, mr.RightBottom.X, mr.RightBottom.Y)
+	side := gorivets.Min(r.Dx(), r.Dy())
+
+	var pic *fpcp.Picture
+	for _, p := range pics {
+		if pic == nil || len(p.Data) > len(pic.Data) {
+			pic = p
+		}
+	}
+	if pic != nil {
+		img, _, err := image.Decode(bytes.NewReader(pic.Data))
+		if err == nil {
+			return imageSrv.FaceQuality(img, img.Bounds(), side)
+		}
+		sp.logger.Warn("Could not decode face picture of personId=", face.PersonId, ", err=", err)
+	}
+	return imageSrv.FaceQuality(frame(), r, side)
+}
+
+// returns function which decodes the original size frame picture once, the
+// function returns nil if there is no such picture or it cannot be decoded
+func newFrameDecoder(pics []*fpcp.Picture) func() image.Image {
+	var img image.Image
+	done := false
+	return func() image.Image {
+		if done {
+			return img
+		}
+		done = true
+		for _, p := range pics {
+			if byte(p.SizeCode) == imageSrv.IMG_SIZE_ORIGINAL {
+				img, _, _ = image.Decode(bytes.NewReader(p.Data))
+				break
+			}
+		}
+		return img
+	}
+}
+
 // creates new face and fills it partially by populating:
 // - PersonId
 // - Rect
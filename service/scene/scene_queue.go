@@ -0,0 +1,262 @@
+package scene
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/jrivets/gorivets"
+	"github.com/jrivets/log4g"
+	"github.com/pixty/console/common"
+	"github.com/pixty/console/common/fpcp"
+)
+
+// how long the queued scenes are processed on shutdown
+const cScnQueueDrainTO = 30 * time.Second
+
+type (
+	// Ingest counters, totals or for a camera
+	IngestStats struct {
+		CamId int64
+		// scenes waiting for processing now
+		Pending int
+		// scenes accepted to the queue since the start
+		Queued uint64
+		// scenes rejected, because the queue was full
+		Dropped uint64
+		// scenes received again and ignored
+		Duplicates uint64
+		// scenes out of the camera active hours
+		InactiveScenes uint64
+		// faces rejected by the camera settings
+		SmallFaces     uint64
+		OutOfZoneFaces uint64
+		// scenes processed, including failed ones
+		Processed uint64
+		Failed    uint64
+	}
+
+	// scene_queue keeps a bounded queue of scenes per camera. The workers
+	// take cameras which have scenes in the ready list, so scenes of a camera
+	// are processed one by one in order they come, but different cameras are
+	// processed in parallel.
+	scene_queue struct {
+		lock   sync.Mutex
+		cond   *sync.Cond
+		size   int
+		cams   map[int64]*cam_queue
+		ready  []*cam_queue
+		closed bool
+		// the workers stop even if there are queued scenes
+		aborted bool
+		workers sync.WaitGroup
+		process func(camId int64, scn *fpcp.Scene) error
+	}
+
+	cam_queue struct {
+		scenes []*fpcp.Scene
+		// the camera is in the ready list or processed by a worker
+		busy  bool
+		stats IngestStats
+	}
+)
+
+func new_scene_queue(size int, process func(camId int64, scn *fpcp.Scene) error) *scene_queue {
+	sq := new(scene_queue)
+	sq.cond = sync.NewCond(&sq.lock)
+	sq.size = size
+	sq.cams = make(map[int64]*cam_queue)
+	sq.process = process
+	return sq
+}
+
+// Puts the scene to the camera queue. Returns ERR_LIMIT_VIOLATION if the
+// queue is full.
+func (sq *scene_queue) push(camId int64, scn *fpcp.Scene) error {
+	sq.lock.Lock()
+	defer sq.lock.Unlock()
+
+	if sq.closed {
+		return common.NewError(common.ERR_LIMIT_VIOLATION, "The scenes queue is closed.")
+	}
+	cq := sq.get_cam_queue(camId)
+	if len(cq.scenes) >= sq.size {
+		cq.stats.Dropped++
+		return common.NewError(common.ERR_LIMIT_VIOLATION, "The scenes queue for camId="+fmt.Sprint(camId)+" is full.")
+	}
+
+	cq.scenes = append(cq.scenes, scn)
+	cq.stats.Queued++
+	if !cq.busy {
+		cq.busy = true
+		sq.ready = append(sq.ready, cq)
+		sq.cond.Signal()
+	}
+	return nil
+}
+
+func (sq *scene_queue) on_duplicate(camId int64) {
+	sq.lock.Lock()
+	defer sq.lock.Unlock()
+	sq.get_cam_queue(camId).stats.Duplicates++
+}
+
+func (sq *scene_queue) get_cam_queue(camId int64) *cam_queue {
+	cq, ok := sq.cams[camId]
+	if !ok {
+		cq = &cam_queue{stats: IngestStats{CamId: camId}}
+		sq.cams[camId] = cq
+	}
+	return cq
+}
+
+// Counts scenes and faces rejected by the camera settings
+func (sq *scene_queue) count_rejected(camId int64, inactive bool, small, outOfZones int) {
+	sq.lock.Lock()
+	defer sq.lock.Unlock()
+	st := &sq.get_cam_queue(camId).stats
+	if inactive {
+		st.InactiveScenes++
+	}
+	st.SmallFaces += uint64(small)
+	st.OutOfZoneFaces += uint64(outOfZones)
+}
+
+// Starts n workers
+func (sq *scene_queue) start(n int, logger log4g.Logger) {
+	for i := 0; i < n; i++ {
+		sq.workers.Add(1)
+		go func() {
+			defer sq.workers.Done()
+			sq.work(logger)
+		}()
+	}
+}
+
+// The worker routine, returns when the queue is closed and the queued scenes
+// are processed
+func (sq *scene_queue) work(logger log4g.Logger) {
+	sq.lock.Lock()
+	defer sq.lock.Unlock()
+	for {
+		for len(sq.ready) == 0 && !sq.closed {
+			sq.cond.Wait()
+		}
+		if len(sq.ready) == 0 || sq.aborted {
+			return
+		}
+
+		cq := sq.ready[0]
+		sq.ready[0] = nil
+		sq.ready = sq.ready[1:]
+		scn := cq.scenes[0]
+		cq.scenes[0] = nil
+		cq.scenes = cq.scenes[1:]
+
+		sq.lock.Unlock()
+		var err error
+		if perr := gorivets.CheckPanic(func() { err = sq.process(cq.stats.CamId, scn) }); perr != nil {
+			err = fmt.Errorf("panic: %v", perr)
+		}
+		sq.lock.Lock()
+
+		cq.stats.Processed++
+		if err != nil {
+			logger.Warn("Could not process scene for camId=", cq.stats.CamId, ", err=", err)
+			cq.stats.Failed++
+		}
+		if len(cq.scenes) > 0 {
+			sq.ready = append(sq.ready, cq)
+		} else {
+			cq.busy = false
+		}
+	}
+}
+
+// Stops accepting new scenes and waits until the workers process the queued
+// ones, but not longer than to. Returns the number of scenes left
+// unprocessed.
+func (sq *scene_queue) close(to time.Duration) int {
+	sq.lock.Lock()
+	sq.closed = true
+	sq.cond.Broadcast()
+	sq.lock.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		sq.workers.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(to):
+	}
+
+	sq.lock.Lock()
+	defer sq.lock.Unlock()
+	sq.aborted = true
+	left := 0
+	for _, cq := range sq.cams {
+		left += len(cq.scenes)
+	}
+	return left
+}
+
+// Returns the number of scenes, which can be put to the camera queue now
+func (sq *scene_queue) get_free(camId int64) int {
+	sq.lock.Lock()
+	defer sq.lock.Unlock()
+	if sq.closed {
+		return 0
+	}
+	cq, ok := sq.cams[camId]
+	if !ok {
+		return sq.size
+	}
+	return sq.size - len(cq.scenes)
+}
+
+// Returns the camera counters
+func (sq *scene_queue) get_cam_stats(camId int64) *IngestStats {
+	sq.lock.Lock()
+	defer sq.lock.Unlock()
+	cq, ok := sq.cams[camId]
+	if !ok {
+		return &IngestStats{CamId: camId}
+	}
+	st := cq.stats
+	st.Pending = len(cq.scenes)
+	return &st
+}
+
+// Returns the totals and the per camera counters sorted by camId
+func (sq *scene_queue) get_stats() (*IngestStats, []*IngestStats) {
+	sq.lock.Lock()
+	defer sq.lock.Unlock()
+
+	total := new(IngestStats)
+	res := make([]*IngestStats, 0, len(sq.cams))
+	for _, cq := range sq.cams {
+		st := cq.stats
+		st.Pending = len(cq.scenes)
+		total.Pending += st.Pending
+		total.Queued += st.Queued
+		total.Dropped += st.Dropped
+		total.Duplicates += st.Duplicates
+		total.InactiveScenes += st.InactiveScenes
+		total.SmallFaces += st.SmallFaces
+		total.OutOfZoneFaces += st.OutOfZoneFaces
+		total.Processed += st.Processed
+		total.Failed += st.Failed
+		res = append(res, &st)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].CamId < res[j].CamId })
+	return total, res
+}
+
+func (is *IngestStats) String() string {
+	return fmt.Sprint("{camId=", is.CamId, ", pending=", is.Pending, ", queued=", is.Queued, ", dropped=", is.Dropped,
+		", duplicates=", is.Duplicates, ", processed=", is.Processed, ", failed=", is.Failed, ", inactiveScenes=",
+		is.InactiveScenes, ", smallFaces=", is.SmallFaces, ", outOfZoneFaces=", is.OutOfZoneFaces, "}")
+}
@@ -0,0 +1,130 @@
+package scene
+
+import (
+	"errors"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jrivets/log4g"
+	"github.com/pixty/console/common"
+	"github.com/pixty/console/common/fpcp"
+)
+
+func newTestScene(id int) *fpcp.Scene {
+	sid := strconv.Itoa(id)
+	return &fpcp.Scene{Id: sid, Frame: &fpcp.Frame{Id: sid}}
+}
+
+func TestSceneQueueCredits(t *testing.T) {
+	sq := new_scene_queue(3, func(camId int64, scn *fpcp.Scene) error { return nil })
+	tests := []struct {
+		camId int64
+		ok    bool
+		free  int
+	}{
+		{1, true, 2},
+		{1, true, 1},
+		{2, true, 2},
+		{1, true, 0},
+		// overflow
+		{1, false, 0},
+		{1, false, 0},
+		{2, true, 1},
+		{3, true, 2},
+	}
+	for i, tst := range tests {
+		err := sq.push(tst.camId, newTestScene(i))
+		if (err == nil) != tst.ok {
+			t.Fatal("Push #", i, " to camId=", tst.camId, " returned err=", err)
+		}
+		if err != nil && !common.CheckError(err, common.ERR_LIMIT_VIOLATION) {
+			t.Fatal("Expecting ERR_LIMIT_VIOLATION, but err=", err)
+		}
+		if free := sq.get_free(tst.camId); free != tst.free {
+			t.Fatal("Push #", i, ": camId=", tst.camId, " should have ", tst.free, " free slots, but ", free)
+		}
+	}
+	if sq.get_free(4) != 3 {
+		t.Fatal("Unknown camera queue must be empty")
+	}
+
+	st := sq.get_cam_stats(1)
+	if st.Pending != 3 || st.Queued != 3 || st.Dropped != 2 {
+		t.Fatal("Wrong camId=1 stats ", st)
+	}
+	total, cams := sq.get_stats()
+	if total.Pending != 6 || total.Dropped != 2 || len(cams) != 3 || cams[0].CamId != 1 || cams[2].CamId != 3 {
+		t.Fatal("Wrong stats ", total, " ", cams)
+	}
+}
+
+func TestSceneQueueProcessing(t *testing.T) {
+	var lock sync.Mutex
+	processed := make(map[int64][]string)
+	sq := new_scene_queue(100, func(camId int64, scn *fpcp.Scene) error {
+		lock.Lock()
+		processed[camId] = append(processed[camId], scn.Id)
+		lock.Unlock()
+		if scn.Id == "13" {
+			return errors.New("test")
+		}
+		if scn.Id == "14" {
+			panic("test")
+		}
+		return nil
+	})
+
+	for i := 0; i < 50; i++ {
+		sq.push(int64(i%2), newTestScene(i))
+	}
+	sq.start(4, log4g.GetLogger("pixty.test"))
+	if left := sq.close(5 * time.Second); left != 0 {
+		t.Fatal("All the queued scenes must be processed, but ", left, " are left")
+	}
+	if sq.push(1, newTestScene(100)) == nil || sq.get_free(1) != 0 {
+		t.Fatal("The closed queue must not accept scenes")
+	}
+
+	// the camera scenes are processed in order
+	for camId, ids := range processed {
+		if len(ids) != 25 {
+			t.Fatal("camId=", camId, " should have 25 scenes processed, but ", len(ids))
+		}
+		for i, id := range ids {
+			if id != strconv.Itoa(2*i+int(camId)) {
+				t.Fatal("camId=", camId, " scenes are processed out of order ", ids)
+			}
+		}
+	}
+	total, _ := sq.get_stats()
+	if total.Processed != 50 || total.Failed != 2 || total.Pending != 0 {
+		t.Fatal("Wrong stats ", total)
+	}
+}
+
+func TestSceneQueueCloseTimeout(t *testing.T) {
+	release := make(chan struct{})
+	sq := new_scene_queue(10, func(camId int64, scn *fpcp.Scene) error {
+		<-release
+		return nil
+	})
+	for i := 0; i < 5; i++ {
+		sq.push(1, newTestScene(i))
+	}
+	sq.start(1, log4g.GetLogger("pixty.test"))
+	// the first scene is taken by the worker
+	for sq.get_cam_stats(1).Pending != 4 {
+		time.Sleep(time.Millisecond)
+	}
+
+	if left := sq.close(50 * time.Millisecond); left != 4 {
+		t.Fatal("4 scenes should be left, but ", left)
+	}
+	close(release)
+	sq.workers.Wait()
+	if st := sq.get_cam_stats(1); st.Processed != 1 || st.Pending != 4 {
+		t.Fatal("The aborted queue must not process scenes ", st)
+	}
+}
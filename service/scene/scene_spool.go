@@ -0,0 +1,235 @@
+package scene
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jrivets/log4g"
+	"github.com/pixty/console/common"
+	"github.com/pixty/console/model"
+)
+
+const (
+	cSpoolFileExt = ".scene"
+	// how often the spooled scenes replay is tried
+	cSpoolReplayTo = 5 * time.Second
+)
+
+type (
+	// Scenes spool counters
+	SpoolStats struct {
+		// scenes waiting for replay now and their size on disk
+		Entries int
+		Size    int64
+		// when the oldest waiting scene was spooled, zero if no one
+		OldestAt time.Time
+		// counters since the start
+		Spooled  uint64
+		Replayed uint64
+		// scenes not spooled, because the spool was full
+		Dropped uint64
+		// scenes which could not be replayed while the DB was available
+		Failed uint64
+		// the last replay error
+		Err error
+	}
+
+	// scene_spool keeps the scenes faces, which could not be persisted, in
+	// files named by the sequence number, so they are replayed in the order
+	// they were spooled. The images of the faces are stored already.
+	scene_spool struct {
+		dir     string
+		maxSize int64
+		lock    sync.Mutex
+		entries []*spool_entry
+		seq     uint64
+		stats   SpoolStats
+		logger  log4g.Logger
+	}
+
+	spool_entry struct {
+		fileName  string
+		size      int64
+		spooledAt time.Time
+	}
+
+	spool_record struct {
+		CamId int64
+		Faces []*model.Face
+	}
+)
+
+func new_scene_spool(dir string, maxSize int64) *scene_spool {
+	ss := new(scene_spool)
+	ss.dir = dir
+	ss.maxSize = maxSize
+	ss.logger = log4g.GetLogger("pixty.SceneSpool")
+	return ss
+}
+
+// Reads the scenes spooled before the restart
+func (ss *scene_spool) open() error {
+	if err := os.MkdirAll(ss.dir, 0750); err != nil {
+		return err
+	}
+	fis, err := ioutil.ReadDir(ss.dir)
+	if err != nil {
+		return err
+	}
+
+	ss.lock.Lock()
+	defer ss.lock.Unlock()
+	for _, fi := range fis {
+		if strings.HasSuffix(fi.Name(), cSpoolFileExt+".tmp") {
+			ss.logger.Warn("Removing partially written scene ", fi.Name())
+			os.Remove(filepath.Join(ss.dir, fi.Name()))
+			continue
+		}
+		seq, ok := spoolFileSeq(fi.Name())
+		if !ok {
+			continue
+		}
+		ss.entries = append(ss.entries, &spool_entry{fileName: fi.Name(), size: fi.Size(), spooledAt: fi.ModTime()})
+		ss.stats.Size += fi.Size()
+		if seq >= ss.seq {
+			ss.seq = seq + 1
+		}
+	}
+	sort.Slice(ss.entries, func(i, j int) bool { return ss.entries[i].fileName < ss.entries[j].fileName })
+	ss.logger.Info("Opened spool in ", ss.dir, ", found ", len(ss.entries), " scenes (", ss.stats.Size, " bytes) to replay")
+	return nil
+}
+
+func (ss *scene_spool) is_empty() bool {
+	ss.lock.Lock()
+	defer ss.lock.Unlock()
+	return len(ss.entries) == 0
+}
+
+// Writes the scene faces to the spool. Returns ERR_LIMIT_VIOLATION if the
+// spool is full.
+func (ss *scene_spool) put(camId int64, faces []*model.Face) error {
+	data, err := json.Marshal(&spool_record{CamId: camId, Faces: faces})
+	if err != nil {
+		return err
+	}
+
+	ss.lock.Lock()
+	defer ss.lock.Unlock()
+	if ss.stats.Size+int64(len(data)) > ss.maxSize {
+		ss.stats.Dropped++
+		return common.NewError(common.ERR_LIMIT_VIOLATION, "The scenes spool is full.")
+	}
+
+	// the file is renamed when it is written completely, so a crash doesn't
+	// leave a partial scene
+	fn := fmt.Sprintf("%020d%s", ss.seq, cSpoolFileExt)
+	tmp := filepath.Join(ss.dir, fn+".tmp")
+	if err = ioutil.WriteFile(tmp, data, 0640); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err = os.Rename(tmp, filepath.Join(ss.dir, fn)); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	ss.seq++
+	ss.entries = append(ss.entries, &spool_entry{fileName: fn, size: int64(len(data)), spooledAt: time.Now()})
+	ss.stats.Size += int64(len(data))
+	ss.stats.Spooled++
+	return nil
+}
+
+// Replays the spooled scenes in order until the spool is empty or the DB
+// is not available. The scene is dropped if it could not be persisted
+// while the DB is available, so one bad scene doesn't block the others.
+func (ss *scene_spool) replay(persist func(camId int64, faces []*model.Face) error, healthy func() error) {
+	for {
+		ss.lock.Lock()
+		if len(ss.entries) == 0 {
+			ss.lock.Unlock()
+			return
+		}
+		se := ss.entries[0]
+		ss.lock.Unlock()
+
+		rec, err := ss.read(se)
+		if err == nil {
+			err = persist(rec.CamId, rec.Faces)
+		}
+		if err != nil {
+			if herr := healthy(); herr != nil {
+				ss.logger.Debug("The DB is not available, will replay later. err=", herr)
+				ss.onError(herr)
+				return
+			}
+			ss.logger.Error("Could not replay scene ", se.fileName, ", dropping it. err=", err)
+			ss.onError(err)
+		}
+
+		if rerr := os.Remove(filepath.Join(ss.dir, se.fileName)); rerr != nil && !os.IsNotExist(rerr) {
+			ss.logger.Error("Could not delete spooled scene ", se.fileName, ", err=", rerr)
+			ss.onError(rerr)
+			return
+		}
+
+		ss.lock.Lock()
+		ss.entries[0] = nil
+		ss.entries = ss.entries[1:]
+		ss.stats.Size -= se.size
+		if err == nil {
+			ss.stats.Replayed++
+		} else {
+			ss.stats.Failed++
+		}
+		ss.lock.Unlock()
+	}
+}
+
+func (ss *scene_spool) read(se *spool_entry) (*spool_record, error) {
+	data, err := ioutil.ReadFile(filepath.Join(ss.dir, se.fileName))
+	if err != nil {
+		return nil, err
+	}
+	rec := new(spool_record)
+	err = json.Unmarshal(data, rec)
+	return rec, err
+}
+
+func (ss *scene_spool) onError(err error) {
+	ss.lock.Lock()
+	defer ss.lock.Unlock()
+	ss.stats.Err = err
+}
+
+func (ss *scene_spool) get_stats() *SpoolStats {
+	ss.lock.Lock()
+	defer ss.lock.Unlock()
+	st := ss.stats
+	st.Entries = len(ss.entries)
+	if st.Entries > 0 {
+		st.OldestAt = ss.entries[0].spooledAt
+	}
+	return &st
+}
+
+func spoolFileSeq(fn string) (uint64, bool) {
+	if !strings.HasSuffix(fn, cSpoolFileExt) {
+		return 0, false
+	}
+	seq, err := strconv.ParseUint(strings.TrimSuffix(fn, cSpoolFileExt), 10, 64)
+	return seq, err == nil
+}
+
+func (st *SpoolStats) String() string {
+	return fmt.Sprint("{entries=", st.Entries, ", size=", st.Size, ", spooled=", st.Spooled, ", replayed=", st.Replayed,
+		", dropped=", st.Dropped, ", failed=", st.Failed, ", err=", st.Err, "}")
+}
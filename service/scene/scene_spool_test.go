@@ -0,0 +1,160 @@
+package scene
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pixty/console/common"
+	"github.com/pixty/console/model"
+)
+
+type test_persister struct {
+	persisted []int64
+	// the camId the persist fails for
+	failCamId int64
+	dbErr     error
+}
+
+func (tp *test_persister) persist(camId int64, faces []*model.Face) error {
+	if tp.dbErr != nil {
+		return tp.dbErr
+	}
+	if camId == tp.failCamId {
+		return errors.New("wrong scene")
+	}
+	tp.persisted = append(tp.persisted, camId)
+	return nil
+}
+
+func (tp *test_persister) healthy() error {
+	return tp.dbErr
+}
+
+func initSpool(t *testing.T, maxSize int64) *scene_spool {
+	dir, err := ioutil.TempDir("", "spool")
+	if err != nil {
+		t.Fatal("Could not create temp dir, err=", err)
+	}
+	ss := new_scene_spool(dir, maxSize)
+	if err := ss.open(); err != nil {
+		t.Fatal("Could not open spool, err=", err)
+	}
+	return ss
+}
+
+func spoolFaces(n int) []*model.Face {
+	res := make([]*model.Face, n)
+	for i := range res {
+		res[i] = &model.Face{PersonId: "p", CapturedAt: uint64(i)}
+	}
+	return res
+}
+
+func TestSceneSpoolReplay(t *testing.T) {
+	ss := initSpool(t, 1000000)
+	defer os.RemoveAll(ss.dir)
+
+	for camId := int64(1); camId <= 5; camId++ {
+		if err := ss.put(camId, spoolFaces(int(camId))); err != nil {
+			t.Fatal("Could not spool the scene, err=", err)
+		}
+	}
+	if ss.is_empty() || ss.get_stats().Entries != 5 || ss.get_stats().Spooled != 5 {
+		t.Fatal("Wrong stats ", ss.get_stats())
+	}
+
+	// the DB is not available, nothing is replayed
+	tp := &test_persister{failCamId: 3, dbErr: errors.New("no DB")}
+	ss.replay(tp.persist, tp.healthy)
+	if st := ss.get_stats(); st.Entries != 5 || st.Replayed != 0 || st.Err == nil {
+		t.Fatal("Nothing should be replayed ", st)
+	}
+
+	// the bad scene is dropped, the others are replayed in order
+	tp.dbErr = nil
+	ss.replay(tp.persist, tp.healthy)
+	st := ss.get_stats()
+	if !ss.is_empty() || st.Size != 0 || st.Replayed != 4 || st.Failed != 1 {
+		t.Fatal("Wrong stats after replay ", st)
+	}
+	exp := []int64{1, 2, 4, 5}
+	for i, camId := range exp {
+		if len(tp.persisted) != len(exp) || tp.persisted[i] != camId {
+			t.Fatal("Expecting ", exp, " replayed, but ", tp.persisted)
+		}
+	}
+	if fis, _ := ioutil.ReadDir(ss.dir); len(fis) != 0 {
+		t.Fatal("The replayed scenes files must be deleted, but found ", len(fis))
+	}
+}
+
+func TestSceneSpoolReopen(t *testing.T) {
+	ss := initSpool(t, 1000000)
+	defer os.RemoveAll(ss.dir)
+
+	tests := []struct {
+		camId int64
+		faces int
+	}{
+		{10, 1}, {11, 2}, {12, 3},
+	}
+	for _, tst := range tests {
+		ss.put(tst.camId, spoolFaces(tst.faces))
+	}
+	// partially written scene
+	ioutil.WriteFile(filepath.Join(ss.dir, "00000000000000000003"+cSpoolFileExt+".tmp"), []byte("{"), 0640)
+	size := ss.get_stats().Size
+
+	ss2 := new_scene_spool(ss.dir, 1000000)
+	if err := ss2.open(); err != nil {
+		t.Fatal("Could not reopen spool, err=", err)
+	}
+	if st := ss2.get_stats(); st.Entries != 3 || st.Size != size {
+		t.Fatal("Wrong stats after reopen ", st, ", expecting size=", size)
+	}
+	// new scenes go after the old ones
+	ss2.put(13, spoolFaces(1))
+
+	var faces []int
+	ss2.replay(func(camId int64, fcs []*model.Face) error {
+		if camId != int64(10+len(faces)) {
+			t.Fatal("Unexpected camId=", camId, " replayed")
+		}
+		faces = append(faces, len(fcs))
+		return nil
+	}, func() error { return nil })
+	if len(faces) != 4 || faces[2] != 3 || faces[3] != 1 {
+		t.Fatal("Wrong scenes replayed ", faces)
+	}
+}
+
+func TestSceneSpoolFull(t *testing.T) {
+	ss := initSpool(t, 1000)
+	defer os.RemoveAll(ss.dir)
+
+	tests := []struct {
+		faces int
+		ok    bool
+	}{
+		{1, true},
+		{1, true},
+		// too big
+		{50, false},
+		{1, true},
+	}
+	for i, tst := range tests {
+		err := ss.put(1, spoolFaces(tst.faces))
+		if (err == nil) != tst.ok {
+			t.Fatal("Put #", i, " returned err=", err)
+		}
+		if err != nil && !common.CheckError(err, common.ERR_LIMIT_VIOLATION) {
+			t.Fatal("Expecting ERR_LIMIT_VIOLATION, but err=", err)
+		}
+	}
+	if st := ss.get_stats(); st.Entries != 3 || st.Dropped != 1 || st.Size > 1000 {
+		t.Fatal("Wrong stats ", st)
+	}
+}
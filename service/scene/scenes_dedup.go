@@ -0,0 +1,58 @@
+package scene
+
+import (
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/jrivets/gorivets"
+	"github.com/pixty/console/common/fpcp"
+)
+
+// scenes_dedup remembers the scene frames received from the cameras for the
+// window, so the frames which are sent again by frame processor retries are
+// not stored twice.
+type scenes_dedup struct {
+	lock   sync.Mutex
+	window time.Duration
+	// the key to the time the scene frame was seen
+	seen gorivets.LRU
+}
+
+func new_scenes_dedup(window time.Duration) *scenes_dedup {
+	sd := new(scenes_dedup)
+	sd.window = window
+	sd.seen = gorivets.NewTtlLRU(100000, window, nil)
+	return sd
+}
+
+func scene_key(camId int64, scn *fpcp.Scene) string {
+	return strconv.FormatInt(camId, 10) + "/" + scn.Id + "/" + scn.Frame.Id
+}
+
+// Returns false if the scene frame was seen already, remembers it otherwise
+func (sd *scenes_dedup) check_and_add(key string) bool {
+	sd.lock.Lock()
+	defer sd.lock.Unlock()
+	now := time.Now()
+	// the expired keys stay till the sweep, so the time is checked
+	if ts, ok := sd.seen.Peek(key); ok && now.Sub(ts.(time.Time)) < sd.window {
+		return false
+	}
+	sd.seen.Add(key, now, 1)
+	return true
+}
+
+// Forgets the scene frame, so it will be accepted if it comes again
+func (sd *scenes_dedup) forget(key string) {
+	sd.lock.Lock()
+	defer sd.lock.Unlock()
+	sd.seen.Delete(key)
+}
+
+// Removes the scene frames seen before the window. Called periodically.
+func (sd *scenes_dedup) sweep() {
+	sd.lock.Lock()
+	defer sd.lock.Unlock()
+	sd.seen.Sweep()
+}
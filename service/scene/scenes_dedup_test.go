@@ -0,0 +1,58 @@
+package scene
+
+import (
+	"testing"
+	"time"
+
+	"github.com/pixty/console/common/fpcp"
+)
+
+func TestScenesDedup(t *testing.T) {
+	sd := new_scenes_dedup(time.Minute)
+	k1 := scene_key(1, &fpcp.Scene{Id: "s1", Frame: &fpcp.Frame{Id: "1"}})
+	k2 := scene_key(1, &fpcp.Scene{Id: "s1", Frame: &fpcp.Frame{Id: "2"}})
+	k3 := scene_key(2, &fpcp.Scene{Id: "s1", Frame: &fpcp.Frame{Id: "1"}})
+	if k1 == k2 || k1 == k3 {
+		t.Fatal("Different frames must have different keys ", k1, ", ", k2, ", ", k3)
+	}
+
+	tests := []struct {
+		key    string
+		forget bool
+		res    bool
+	}{
+		{k1, false, true},
+		{k1, false, false},
+		{k2, false, true},
+		{k3, false, true},
+		{k2, false, false},
+		{k1, true, true},
+		{k1, false, false},
+	}
+	for i, tst := range tests {
+		if tst.forget {
+			sd.forget(tst.key)
+		}
+		if sd.check_and_add(tst.key) != tst.res {
+			t.Fatal("Check #", i, " of ", tst.key, " should return ", tst.res)
+		}
+	}
+}
+
+func TestScenesDedupWindow(t *testing.T) {
+	sd := new_scenes_dedup(20 * time.Millisecond)
+	if !sd.check_and_add("k1") || sd.check_and_add("k1") {
+		t.Fatal("k1 should be accepted once")
+	}
+	time.Sleep(30 * time.Millisecond)
+	if !sd.check_and_add("k1") {
+		t.Fatal("k1 is out of the window, but not accepted")
+	}
+
+	sd.check_and_add("k2")
+	time.Sleep(30 * time.Millisecond)
+	sd.sweep()
+	if sd.seen.Len() != 0 {
+		t.Fatal("All the keys should be swept, but ", sd.seen.Len(), " are left")
+	}
+}
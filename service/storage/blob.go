@@ -1,8 +1,12 @@
 package storage
 
 import (
+	"fmt"
+	"hash/crc32"
 	"io"
 	"time"
+
+	"github.com/pixty/console/common"
 )
 
 type (
@@ -10,6 +14,12 @@ type (
 		Id        string
 		Timestamp time.Time
 		Size      int64
+		// Id of the key the object is encrypted by, empty if the object
+		// is not encrypted. See BlobCipher
+		KeyId string
+		// CRC-32C of the stored (encrypted if so) content, hex encoded. Empty
+		// for objects stored before checksums were introduced.
+		Checksum string
 	}
 
 	BlobStorage interface {
@@ -29,4 +39,78 @@ type (
 		// Deletes all ids with prefix. Returns number of objects deleted
 		DeleteAllWithPrefix(prefix string) int
 	}
+
+	// Objects in the storage could belong to an owner (organization) and its
+	// sub-owner (camera). The resolver defines the ownership and owners quotas.
+	BlobOwnerResolver interface {
+		// Returns owner and sub-owner of the object. ok is false if the
+		// object owner is unknown, such objects are not accounted.
+		GetOwner(objId string) (owner, subOwner int64, ok bool)
+
+		// Returns the maximum size in bytes the owner objects can take,
+		// 0 means unlimited.
+		GetOwnerQuota(owner int64) int64
+	}
+
+	BlobUsage struct {
+		Objects int
+		Size    int64
+	}
+
+	BlobOwnerUsage struct {
+		Owner int64
+		Quota int64
+		BlobUsage
+		SubOwners map[int64]*BlobUsage
+	}
+
+	// Implemented by the storages which can check their objects integrity
+	BlobVerifier interface {
+		// Checks the stored object content against its meta. Returns
+		// ERR_NOT_FOUND error if the object or its content is missing and
+		// ERR_INVALID_VAL if the content is corrupted
+		Verify(objId string) error
+	}
+
+	// Implemented by the storages which can enumerate their objects
+	BlobLister interface {
+		// Returns ids of all objects with the prefix
+		ListIds(prefix string) ([]string, error)
+	}
+
+	// Implemented by the storages which support encryption
+	BlobEncryptor interface {
+		// Returns the key id new objects are encrypted by, empty string
+		// means the encryption is off
+		ActiveKeyId() string
+		// Re-encrypts the object by the active key keeping its meta (but
+		// the key id). Returns false if the object is not found or it is
+		// encrypted by the active key already.
+		Rekey(objId string) (bool, error)
+	}
+
+	// Implemented by the storages which account objects per owner
+	BlobUsageReporter interface {
+		// Returns the owner usage, or nil if the owner has no objects
+		GetOwnerUsage(owner int64) *BlobOwnerUsage
+	}
 )
+
+var crc32c = crc32.MakeTable(crc32.Castagnoli)
+
+func blobChecksum(data []byte) string {
+	return fmt.Sprintf("%08x", crc32.Checksum(data, crc32c))
+}
+
+// checks the stored content against the meta, objects without checksum are
+// checked by size only
+func checkBlob(bm *BlobMeta, data []byte) error {
+	if bm.Size >= 0 && int64(len(data)) != bm.Size {
+		return common.NewError(common.ERR_INVALID_VAL, fmt.Sprint("Size mismatch for id=", bm.Id, ", expected ",
+			bm.Size, ", but content is ", len(data), " bytes"))
+	}
+	if bm.Checksum != "" && bm.Checksum != blobChecksum(data) {
+		return common.NewError(common.ERR_INVALID_VAL, "Checksum mismatch for id="+bm.Id)
+	}
+	return nil
+}
@@ -0,0 +1,234 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"github.com/jrivets/log4g"
+	"github.com/pixty/console/common"
+)
+
+const (
+	cCipherMagic  = "PXE1"
+	cCipherKeyLen = 32
+)
+
+type (
+	// The keys file content. Keys are base64 encoded 32 bytes AES keys. New
+	// objects are encrypted by the active key, all others are used for
+	// reading objects encrypted before the rotation.
+	//
+	// {"activeKeyId": "k2", "keys": {"k1": "...", "k2": "..."}}
+	BlobKeys struct {
+		ActiveKeyId string
+		Keys        map[string]string
+	}
+
+	// BlobCipher provides envelope encryption for the blob storages. Every
+	// object is encrypted by its own random data key (AES-256-GCM), the data
+	// key is encrypted (wrapped) by a master key from the keys file. The
+	// encrypted object has the following layout:
+	//
+	//   "PXE1" | len(keyId) | keyId | wrapNonce | wrapped data key | nonce | ciphertext
+	//
+	// The object id is used as additional data, so encrypted content could
+	// not be moved to another id. Objects without the magic are considered
+	// as not encrypted, so storages with plain objects can be read as is.
+	BlobCipher struct {
+		logger   log4g.Logger
+		activeId string
+		keks     map[string]cipher.AEAD
+	}
+
+	// in-memory content returned by Read(). It is seekable, so callers like
+	// http.ServeContent can use it.
+	blob_mem_reader struct {
+		*bytes.Reader
+	}
+)
+
+func NewBlobCipher(keys *BlobKeys) (*BlobCipher, error) {
+	bc := new(BlobCipher)
+	bc.logger = log4g.GetLogger("pixty.service.BlobCipher")
+	bc.keks = make(map[string]cipher.AEAD, len(keys.Keys))
+	for id, k64 := range keys.Keys {
+		if id == "" || len(id) > 255 {
+			return nil, common.NewError(common.ERR_INVALID_VAL, "Key id must be 1..255 characters long")
+		}
+		key, err := base64.StdEncoding.DecodeString(k64)
+		if err != nil || len(key) != cCipherKeyLen {
+			return nil, common.NewError(common.ERR_INVALID_VAL, "Key "+id+" must be base64 encoded 32 bytes value")
+		}
+		bc.keks[id], err = newGCM(key)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if _, ok := bc.keks[keys.ActiveKeyId]; !ok {
+		return nil, common.NewError(common.ERR_INVALID_VAL, "Active key id="+keys.ActiveKeyId+" is not found among the keys")
+	}
+	bc.activeId = keys.ActiveKeyId
+	bc.logger.Info("Initialized with ", len(bc.keks), " keys, active key id=", bc.activeId)
+	return bc, nil
+}
+
+// Reads the keys file (see BlobKeys) and creates the cipher
+func NewBlobCipherFromFile(fileName string) (*BlobCipher, error) {
+	data, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+	keys := &BlobKeys{}
+	err = json.Unmarshal(data, keys)
+	if err != nil {
+		return nil, err
+	}
+	return NewBlobCipher(keys)
+}
+
+func (r blob_mem_reader) Close() error {
+	return nil
+}
+
+func newBlobMemReader(data []byte) io.ReadCloser {
+	return blob_mem_reader{bytes.NewReader(data)}
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	blk, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(blk)
+}
+
+func (bc *BlobCipher) ActiveKeyId() string {
+	return bc.activeId
+}
+
+// Encrypts the object data by the active key. Returns the encrypted data and
+// the key id.
+func (bc *BlobCipher) Encrypt(id string, data []byte) ([]byte, string, error) {
+	dek := make([]byte, cCipherKeyLen)
+	if _, err := rand.Read(dek); err != nil {
+		return nil, "", err
+	}
+	daead, err := newGCM(dek)
+	if err != nil {
+		return nil, "", err
+	}
+	kek := bc.keks[bc.activeId]
+
+	hdrLen := len(cCipherMagic) + 1 + len(bc.activeId) + 2*kek.NonceSize() + cCipherKeyLen + kek.Overhead() + daead.NonceSize()
+	res := make([]byte, 0, hdrLen+len(data)+daead.Overhead())
+	res = append(res, cCipherMagic...)
+	res = append(res, byte(len(bc.activeId)))
+	res = append(res, bc.activeId...)
+
+	wNonce, err := randBytes(kek.NonceSize())
+	if err != nil {
+		return nil, "", err
+	}
+	res = append(res, wNonce...)
+	res = kek.Seal(res, wNonce, dek, []byte(bc.activeId))
+
+	nonce, err := randBytes(daead.NonceSize())
+	if err != nil {
+		return nil, "", err
+	}
+	res = append(res, nonce...)
+	res = daead.Seal(res, nonce, data, []byte(id))
+	return res, bc.activeId, nil
+}
+
+// Decrypts the object data. Returns plain data and the key id, the key id is
+// empty if the data was not encrypted.
+func (bc *BlobCipher) Decrypt(id string, data []byte) ([]byte, string, error) {
+	if !IsEncryptedBlob(data) {
+		return data, "", nil
+	}
+
+	keyId, kek, rest, err := bc.parseKeyId(data)
+	if err != nil {
+		return nil, keyId, err
+	}
+
+	wLen := kek.NonceSize() + cCipherKeyLen + kek.Overhead()
+	if len(rest) < wLen {
+		return nil, keyId, errCorrupted(id)
+	}
+	dek, err := kek.Open(nil, rest[:kek.NonceSize()], rest[kek.NonceSize():wLen], []byte(keyId))
+	if err != nil {
+		return nil, keyId, errCorrupted(id)
+	}
+	rest = rest[wLen:]
+
+	daead, err := newGCM(dek)
+	if err != nil {
+		return nil, keyId, err
+	}
+	if len(rest) < daead.NonceSize() {
+		return nil, keyId, errCorrupted(id)
+	}
+	plain, err := daead.Open(nil, rest[:daead.NonceSize()], rest[daead.NonceSize():], []byte(id))
+	if err != nil {
+		return nil, keyId, errCorrupted(id)
+	}
+	return plain, keyId, nil
+}
+
+func IsEncryptedBlob(data []byte) bool {
+	return bytes.HasPrefix(data, []byte(cCipherMagic))
+}
+
+// Returns the key id the object file is encrypted by, or empty string if the
+// file is not encrypted or could not be read.
+func readBlobKeyId(fileName string) string {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+
+	hdr := make([]byte, len(cCipherMagic)+1+255)
+	n, _ := io.ReadFull(f, hdr)
+	hdr = hdr[:n]
+	if !IsEncryptedBlob(hdr) {
+		return ""
+	}
+	hdr = hdr[len(cCipherMagic):]
+	if len(hdr) < 1 || len(hdr) < 1+int(hdr[0]) {
+		return ""
+	}
+	return string(hdr[1 : 1+int(hdr[0])])
+}
+
+func (bc *BlobCipher) parseKeyId(data []byte) (string, cipher.AEAD, []byte, error) {
+	data = data[len(cCipherMagic):]
+	if len(data) < 1 || len(data) < 1+int(data[0]) {
+		return "", nil, nil, common.NewError(common.ERR_INVALID_VAL, "Corrupted encrypted object header")
+	}
+	keyId := string(data[1 : 1+int(data[0])])
+	kek, ok := bc.keks[keyId]
+	if !ok {
+		return keyId, nil, nil, common.NewError(common.ERR_NOT_FOUND, "Unknown encryption key id="+keyId)
+	}
+	return keyId, kek, data[1+int(data[0]):], nil
+}
+
+func errCorrupted(id string) error {
+	return common.NewError(common.ERR_INVALID_VAL, "Could not decrypt object id="+id+", wrong key or corrupted data")
+}
+
+func randBytes(n int) ([]byte, error) {
+	res := make([]byte, n)
+	_, err := rand.Read(res)
+	return res, err
+}
@@ -0,0 +1,213 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/jrivets/gorivets"
+	"github.com/pixty/console/common"
+)
+
+func newTestKey(b byte) string {
+	return base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{b}, cCipherKeyLen))
+}
+
+func newTestCipher(t *testing.T, active string) *BlobCipher {
+	bc, err := NewBlobCipher(&BlobKeys{ActiveKeyId: active, Keys: map[string]string{"k1": newTestKey(1), "k2": newTestKey(2)}})
+	if err != nil {
+		t.Fatal("Could not create cipher, err=", err)
+	}
+	return bc
+}
+
+func TestCipherRoundTrip(t *testing.T) {
+	bc := newTestCipher(t, "k1")
+	data := []byte("some jpeg content")
+
+	enc, keyId, err := bc.Encrypt("obj1", data)
+	if err != nil || keyId != "k1" || !IsEncryptedBlob(enc) || bytes.Contains(enc, data) {
+		t.Fatal("Wrong encryption keyId=", keyId, ", err=", err)
+	}
+
+	enc2, _, _ := bc.Encrypt("obj1", data)
+	if bytes.Equal(enc, enc2) {
+		t.Fatal("Every object must have its own data key and nonce")
+	}
+
+	plain, keyId, err := bc.Decrypt("obj1", enc)
+	if err != nil || keyId != "k1" || !bytes.Equal(plain, data) {
+		t.Fatal("Wrong decryption keyId=", keyId, ", err=", err)
+	}
+
+	if _, _, err = bc.Decrypt("obj2", enc); err == nil {
+		t.Fatal("The content must not be decrypted for another id")
+	}
+
+	enc[len(enc)-1]++
+	if _, _, err = bc.Decrypt("obj1", enc); err == nil {
+		t.Fatal("Corrupted content must not be decrypted")
+	}
+}
+
+func TestCipherRotation(t *testing.T) {
+	bc1 := newTestCipher(t, "k1")
+	enc, _, _ := bc1.Encrypt("obj1", []byte("data"))
+
+	// k2 is active now, but k1 is still readable
+	bc2 := newTestCipher(t, "k2")
+	plain, keyId, err := bc2.Decrypt("obj1", enc)
+	if err != nil || keyId != "k1" || string(plain) != "data" {
+		t.Fatal("Old key must be used for decryption, keyId=", keyId, ", err=", err)
+	}
+
+	bc3, _ := NewBlobCipher(&BlobKeys{ActiveKeyId: "k2", Keys: map[string]string{"k2": newTestKey(2)}})
+	if _, _, err = bc3.Decrypt("obj1", enc); !common.CheckError(err, common.ERR_NOT_FOUND) {
+		t.Fatal("Expecting unknown key error, but err=", err)
+	}
+
+	// not encrypted data is returned as is
+	plain, keyId, err = bc3.Decrypt("obj1", []byte("plain"))
+	if err != nil || keyId != "" || string(plain) != "plain" {
+		t.Fatal("Plain data must be returned as is")
+	}
+}
+
+func TestCipherWrongKeys(t *testing.T) {
+	if _, err := NewBlobCipher(&BlobKeys{ActiveKeyId: "k3", Keys: map[string]string{"k1": newTestKey(1)}}); err == nil {
+		t.Fatal("Active key must be among the keys")
+	}
+	if _, err := NewBlobCipher(&BlobKeys{ActiveKeyId: "k1", Keys: map[string]string{"k1": "abc"}}); err == nil {
+		t.Fatal("Key must be 32 bytes")
+	}
+}
+
+func TestLfsEncrypted(t *testing.T) {
+	lbs := initLbs()
+	defer removeDir(lbs.storeDir)
+	lbs.SetCipher(newTestCipher(t, "k1"))
+
+	data := "face image content"
+	id, _ := lbs.Add(strings.NewReader(data), nil)
+	fn, _ := lbs.getFilePath(id)
+	raw, _ := ioutil.ReadFile(fn)
+	if !IsEncryptedBlob(raw) || strings.Contains(string(raw), data) {
+		t.Fatal("The file must be encrypted")
+	}
+
+	r, bm := lbs.Read(id)
+	if r == nil || bm.KeyId != "k1" || readString(r) != data {
+		t.Fatal("Could not read encrypted object ", bm)
+	}
+	lbs.Shutdown()
+
+	// restart with rotated keys
+	lbs2 := NewLfsBlobStorage(lbs.storeDir, 1000000000)
+	defer lbs2.Shutdown()
+	lbs2.SetCipher(newTestCipher(t, "k2"))
+	if lbs2.ReadMeta(id).KeyId != "k1" || lbs2.ActiveKeyId() != "k2" {
+		t.Fatal("Key id must be kept in meta ", lbs2.ReadMeta(id))
+	}
+
+	if ok, err := lbs2.Rekey(id); !ok || err != nil {
+		t.Fatal("The object must be re-encrypted, err=", err)
+	}
+	r, bm = lbs2.Read(id)
+	if r == nil || bm.KeyId != "k2" || readString(r) != data {
+		t.Fatal("The object must be re-encrypted by k2 ", bm)
+	}
+	if ok, _ := lbs2.Rekey(id); ok {
+		t.Fatal("The object is encrypted by the active key already")
+	}
+}
+
+func TestLfsEncryptedRescan(t *testing.T) {
+	lbs := initLbs()
+	defer removeDir(lbs.storeDir)
+	lbs.SetCipher(newTestCipher(t, "k1"))
+
+	data := "face image content"
+	id, _ := lbs.Add(strings.NewReader(data), nil)
+	lbs.Shutdown()
+
+	// no journal, the objects are scanned from the folder
+	os.Remove(filepath.Join(lbs.storeDir, cJrnlFileName))
+	os.Remove(filepath.Join(lbs.storeDir, cJrnlSnapFileName))
+	lbs2 := NewLfsBlobStorage(lbs.storeDir, 1000000000)
+	defer lbs2.Shutdown()
+	lbs2.SetCipher(newTestCipher(t, "k2"))
+	if lbs2.ReadMeta(id).KeyId != "k1" {
+		t.Fatal("Key id must be read from the file header ", lbs2.ReadMeta(id))
+	}
+
+	r, _ := lbs2.Read(id)
+	if r == nil || readString(r) != data {
+		t.Fatal("The scanned object must be decrypted")
+	}
+
+	lbs2.Rekey(id)
+	r, bm := lbs2.Read(id)
+	if r == nil || bm.KeyId != "k2" || readString(r) != data {
+		t.Fatal("The object must be re-encrypted by k2 ", bm)
+	}
+}
+
+func TestLfsRekeyInPlace(t *testing.T) {
+	lbs := initLbs()
+	defer removeDir(lbs.storeDir)
+	defer lbs.Shutdown()
+
+	// plain objects, the encryption is turned on later. The LRU fits the
+	// two of them and one encrypted
+	bc := newTestCipher(t, "k1")
+	enc, _, _ := bc.Encrypt("id", []byte("0123456789"))
+	lbs.lru = gorivets.NewLRU(int64(15+len(enc)), lbs.onLRUDelete)
+	id1, _ := lbs.Add(strings.NewReader("0123456789"), nil)
+	id2, _ := lbs.Add(strings.NewReader("0123456789"), nil)
+	lbs.SetCipher(bc)
+
+	if ok, err := lbs.Rekey(id1); !ok || err != nil {
+		t.Fatal("id1 must be re-encrypted, err=", err)
+	}
+	r, bm := lbs.Read(id1)
+	if r == nil || bm.KeyId != "k1" || readString(r) != "0123456789" {
+		t.Fatal("id1 must be encrypted by k1 ", bm)
+	}
+
+	// id1 is still the oldest one and goes away first
+	lbs.Add(strings.NewReader("0123456789"), nil)
+	if lbs.ReadMeta(id1) != nil || lbs.ReadMeta(id2) == nil {
+		t.Fatal("id1 must keep its LRU position")
+	}
+
+	// deleted object is not restored
+	lbs.Delete(id2)
+	if ok, _ := lbs.Rekey(id2); ok || lbs.ReadMeta(id2) != nil {
+		t.Fatal("id2 is deleted and must not be re-encrypted")
+	}
+}
+
+func TestS3Encrypted(t *testing.T) {
+	fs3 := newFakeS3("test")
+	s3bs, srv := initS3bs(fs3)
+	defer srv.Close()
+	s3bs.SetCipher(newTestCipher(t, "k1"))
+
+	data := "face image content"
+	id, _ := s3bs.Add(strings.NewReader(data), nil)
+	if !IsEncryptedBlob(fs3.objects["pxt/"+id]) {
+		t.Fatal("The object must be encrypted")
+	}
+
+	r, bm := s3bs.Read(id)
+	if r == nil || bm.KeyId != "k1" || readString(r) != data {
+		t.Fatal("Could not read encrypted object ", bm)
+	}
+	if s3bs.ReadMeta(id).KeyId != "k1" {
+		t.Fatal("Key id must be in meta")
+	}
+}
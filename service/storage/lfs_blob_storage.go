@@ -1,11 +1,14 @@
 package storage
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
 	"sync"
 	"time"
@@ -15,16 +18,33 @@ import (
 	"github.com/pixty/console/common"
 )
 
+// the meta file was used before the journal, it is read only once to
+// migrate old storages
 const cMetaFileName = ".meta"
 
 type LfsBlobStorage struct {
-	logger   log4g.Logger
-	objects  map[string]*BlobMeta
+	// optional, if provided the objects are accounted per owner and the
+	// owners quotas are applied
+	OwnerResolver BlobOwnerResolver `inject:""`
+
+	logger  log4g.Logger
+	objects map[string]*BlobMeta
+	// objects which are journaled, but their files are being written now
+	pending  map[string]*BlobMeta
 	lru      gorivets.LRU
+	jrnl     *lfs_journal
+	cipher   *BlobCipher
 	metaFN   string
 	storeDir string
 	rwLock   sync.RWMutex
-	lastSave time.Time
+
+	// per owner accounting, see lfs_owners.go
+	owners      map[int64]*lfs_owner
+	objOwners   map[string]*lfs_owner
+	ownersReady bool
+
+	// closed when the background compaction is done, nil if it doesn't run
+	compactDone chan struct{}
 }
 
 func NewLfsBlobStorage(storeDir string, maxSize int64) *LfsBlobStorage {
@@ -39,11 +59,18 @@ func NewLfsBlobStorage(storeDir string, maxSize int64) *LfsBlobStorage {
 }
 
 func newBlobMeta() *BlobMeta {
-	return &BlobMeta{"", time.Now(), 0}
+	return &BlobMeta{"", time.Now(), 0, "", ""}
 }
 
 func (bm *BlobMeta) String() string {
-	return fmt.Sprint("{Id=", bm.Id, ", ts=", bm.Timestamp.Format("01-02-2017 12:13:43"), ", size=", bm.Size, "}")
+	return fmt.Sprint("{Id=", bm.Id, ", ts=", bm.Timestamp.Format("01-02-2017 12:13:43"), ", size=", bm.Size, ", keyId=", bm.KeyId,
+		", checksum=", bm.Checksum, "}")
+}
+
+// Turns on the encryption, all new objects will be encrypted by the cipher.
+// Must be called before the storage is used.
+func (lbs *LfsBlobStorage) SetCipher(bc *BlobCipher) {
+	lbs.cipher = bc
 }
 
 // ============================= LifeCycler ==================================
@@ -68,6 +95,7 @@ func (lbs *LfsBlobStorage) init() error {
 	defer lbs.rwLock.Unlock()
 
 	lbs.objects = make(map[string]*BlobMeta)
+	lbs.pending = make(map[string]*BlobMeta)
 
 	path := lbs.storeDir
 	lbs.logger.Info("Initializing. Loading data from ", path)
@@ -82,6 +110,7 @@ func (lbs *LfsBlobStorage) init() error {
 
 	lbs.metaFN = filepath.Join(path, cMetaFileName)
 	lbs.storeDir = path
+	lbs.jrnl = newLfsJournal(path)
 	return lbs.readObjects()
 }
 
@@ -89,7 +118,8 @@ func (lbs *LfsBlobStorage) Shutdown() {
 	lbs.rwLock.Lock()
 	defer lbs.rwLock.Unlock()
 
-	lbs.saveObjects(false)
+	lbs.compact()
+	lbs.jrnl.close()
 	lbs.objects = nil
 }
 
@@ -117,62 +147,86 @@ func (lbs *LfsBlobStorage) Add(r io.Reader, bMeta *BlobMeta) (string, error) {
 		return "", err
 	}
 
-	file, err := os.Create(fileName)
+	data, err := ioutil.ReadAll(r)
 	if err != nil {
-		lbs.logger.Error("Could not create new file ", fileName)
+		lbs.logger.Error("Could not read data for id=", id, ", err=", err)
 		return "", err
 	}
-	defer file.Close()
 
-	_, err = io.Copy(file, r)
-	if err != nil {
-		lbs.logger.Error("Could not copy data to fileName=", fileName, ", err=", err)
-		return "", err
+	bMeta.Id = id
+	bMeta.Size = -1
+	bMeta.KeyId = ""
+	if lbs.cipher != nil {
+		data, bMeta.KeyId, err = lbs.cipher.Encrypt(id, data)
+		if err != nil {
+			lbs.logger.Error("Could not encrypt data for id=", id, ", err=", err)
+			return "", err
+		}
 	}
+	bMeta.Checksum = blobChecksum(data)
 
-	fi, err := os.Stat(fileName)
+	// the resolver could go to DB, so it is not called under the lock
+	lbs.prepareOwners()
+	ownerRef := lbs.resolveOwner(id)
+
+	lbs.rwLock.Lock()
+	err = lbs.jrnl.logAdd(bMeta)
+	if err == nil {
+		lbs.pending[id] = bMeta
+	}
+	lbs.rwLock.Unlock()
 	if err != nil {
-		lbs.logger.Error("Could not obtain fileName=", fileName, " stat err=", err)
 		return "", err
 	}
-	bMeta.Id = id
-	bMeta.Size = fi.Size()
+
+	size, err := lbs.writeFile(fileName, bytes.NewReader(data))
 
 	lbs.rwLock.Lock()
 	defer lbs.rwLock.Unlock()
 
+	delete(lbs.pending, id)
+	if err != nil {
+		lbs.jrnl.logDelete(id)
+		return "", err
+	}
+
+	bMeta.Size = size
+	lbs.lru.DeleteWithCallback(id, false)
+	lbs.accountDelete(id)
 	lbs.objects[id] = bMeta
 	lbs.logger.Debug("New BLOB with id=", id, " is created. file=", fileName)
 	lbs.lru.Add(bMeta.Id, bMeta, bMeta.Size)
-	//	lbs.saveObjects(true)
+	lbs.accountAdd(bMeta, ownerRef)
+	lbs.compactIfNeeded()
 	return id, nil
 }
 
 func (lbs *LfsBlobStorage) Read(objId string) (io.ReadCloser, *BlobMeta) {
-	fileName, err := lbs.getFilePath(string(objId))
+	data, bMeta, err := lbs.readVerified(objId)
 	if err != nil {
-		lbs.logger.Error("Could not form path for id=", objId, ", err=", err)
-		return nil, nil
-	}
-
-	lbs.rwLock.RLock()
-	defer lbs.rwLock.RUnlock()
-
-	bMeta, ok := lbs.objects[objId]
-	if !ok {
-		lbs.logger.Debug("Could not find BLOB by id=", objId)
+		if common.CheckError(err, common.ERR_NOT_FOUND) {
+			lbs.logger.Debug("Could not read BLOB id=", objId, ", err=", err)
+		} else {
+			lbs.logger.Error("Could not read BLOB id=", objId, ", err=", err)
+		}
 		return nil, nil
 	}
 
-	file, err := os.Open(fileName)
-	if err != nil {
-		lbs.logger.Warn("Found metadata, but could not find the file with the content. Removing metadata... fileNmae=", fileName, ", id=", objId)
-		delete(lbs.objects, objId)
-		return nil, nil
+	// the content is checked, not the meta KeyId, which could be unknown
+	// when the objects were scanned from the folder
+	if IsEncryptedBlob(data) {
+		if lbs.cipher == nil {
+			lbs.logger.Error("The object id=", objId, " is encrypted, but no keys provided.")
+			return nil, nil
+		}
+		data, _, err = lbs.cipher.Decrypt(objId, data)
+		if err != nil {
+			lbs.logger.Error("Could not decrypt the object id=", objId, ", err=", err)
+			return nil, nil
+		}
 	}
-
 	lbs.logger.Debug("Found BLOB id=", objId, " meta=", bMeta)
-	return file, bMeta
+	return newBlobMemReader(data), bMeta
 }
 
 func (lbs *LfsBlobStorage) ReadMeta(objId string) *BlobMeta {
@@ -203,29 +257,45 @@ func (lbs *LfsBlobStorage) Delete(objIds ...string) error {
 			continue
 		}
 
+		if err := lbs.jrnl.logDelete(objId); err != nil {
+			return err
+		}
 		delete(lbs.objects, objId)
 		go func() {
 			os.Remove(fileName)
 		}()
 		lbs.lru.DeleteWithCallback(objId, false)
+		lbs.accountDelete(objId)
 	}
+	lbs.compactIfNeeded()
 	return nil
 }
 
 func (lbs *LfsBlobStorage) DeleteAllWithPrefix(prefix string) int {
+	// look for the objects under the read lock, most of the time nothing is
+	// found
+	ids, _ := lbs.ListIds(prefix)
+	if len(ids) == 0 {
+		return 0
+	}
+
 	lbs.rwLock.Lock()
 	defer lbs.rwLock.Unlock()
 
 	deleted := 0
-	toDel := make([]string, 0, 10)
-	for id := range lbs.objects {
-		if !strings.HasPrefix(id, prefix) {
+	toDel := make([]string, 0, len(ids))
+	for _, id := range ids {
+		if _, ok := lbs.objects[id]; !ok {
 			continue
 		}
 		fileName, _ := lbs.getFilePath(id)
+		if lbs.jrnl.logDelete(id) != nil {
+			break
+		}
 		delete(lbs.objects, id)
 		toDel = append(toDel, fileName)
 		lbs.lru.DeleteWithCallback(id, false)
+		lbs.accountDelete(id)
 		deleted++
 	}
 
@@ -235,9 +305,95 @@ func (lbs *LfsBlobStorage) DeleteAllWithPrefix(prefix string) int {
 		}
 	}()
 
+	lbs.compactIfNeeded()
 	return deleted
 }
 
+// ============================= BlobVerifier ================================
+func (lbs *LfsBlobStorage) Verify(objId string) error {
+	_, _, err := lbs.readVerified(objId)
+	return err
+}
+
+// ============================= BlobLister ==================================
+func (lbs *LfsBlobStorage) ListIds(prefix string) ([]string, error) {
+	lbs.rwLock.RLock()
+	defer lbs.rwLock.RUnlock()
+
+	res := make([]string, 0, 10)
+	for id := range lbs.objects {
+		if strings.HasPrefix(id, prefix) {
+			res = append(res, id)
+		}
+	}
+	return res, nil
+}
+
+// ============================ BlobEncryptor ================================
+func (lbs *LfsBlobStorage) ActiveKeyId() string {
+	if lbs.cipher == nil {
+		return ""
+	}
+	return lbs.cipher.ActiveKeyId()
+}
+
+// The object is re-encrypted under the lock, so it cannot be deleted or
+// re-written in between. The object keeps its LRU position, the LRU and
+// the owner accounting keep the size the object was added with, it differs
+// by the encryption header bytes only.
+func (lbs *LfsBlobStorage) Rekey(objId string) (bool, error) {
+	if lbs.cipher == nil {
+		return false, nil
+	}
+	fileName, err := lbs.getFilePath(objId)
+	if err != nil {
+		return false, err
+	}
+
+	lbs.rwLock.Lock()
+	defer lbs.rwLock.Unlock()
+
+	bMeta, ok := lbs.objects[objId]
+	if !ok || bMeta.KeyId == lbs.cipher.ActiveKeyId() {
+		return false, nil
+	}
+	if _, ok := lbs.pending[objId]; ok {
+		// is being written by the active key now
+		return false, nil
+	}
+
+	data, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return false, err
+	}
+	if err = checkBlob(bMeta, data); err != nil {
+		return false, err
+	}
+	data, _, err = lbs.cipher.Decrypt(objId, data)
+	if err != nil {
+		return false, err
+	}
+	data, keyId, err := lbs.cipher.Encrypt(objId, data)
+	if err != nil {
+		return false, err
+	}
+
+	nbm := &BlobMeta{Id: objId, Timestamp: bMeta.Timestamp, Size: int64(len(data)), KeyId: keyId, Checksum: blobChecksum(data)}
+	if err = lbs.jrnl.logAdd(nbm); err != nil {
+		return false, err
+	}
+	if _, err = lbs.writeFile(fileName, bytes.NewReader(data)); err != nil {
+		// the old file is kept by writeFile
+		lbs.jrnl.logAdd(bMeta)
+		return false, err
+	}
+	// readers, which got the old meta, re-read the object with the new one
+	lbs.objects[objId] = nbm
+	lbs.logger.Debug("Re-encrypted BLOB id=", objId, " by key ", keyId)
+	lbs.compactIfNeeded()
+	return true, nil
+}
+
 // =============================== Private ===================================
 func compBlobMeta(a, b interface{}) int {
 	bm1 := a.(*BlobMeta)
@@ -255,6 +411,12 @@ func compBlobMeta(a, b interface{}) int {
 func (lbs *LfsBlobStorage) onLRUDelete(k, v interface{}) {
 	id := k.(string)
 	lbs.logger.Debug("LRU deleting object id=", id)
+	lbs.accountDelete(id)
+	if lbs.jrnl.logDelete(id) != nil {
+		// keep the file, it is still known after restart
+		delete(lbs.objects, id)
+		return
+	}
 	fp, err := lbs.getFilePath(id)
 	if err != nil {
 		lbs.logger.Warn("Could not obtain filePath for id=", id)
@@ -265,6 +427,74 @@ func (lbs *LfsBlobStorage) onLRUDelete(k, v interface{}) {
 }
 
 func (lbs *LfsBlobStorage) readObjects() error {
+	if !lbs.jrnl.exists() {
+		lbs.logger.Info("No journal found in ", lbs.storeDir, ", will scan the folder")
+		err := lbs.readObjectsFromFolder()
+		if err != nil {
+			return err
+		}
+	} else {
+		err := lbs.readObjectsFromJournal()
+		if err != nil {
+			lbs.logger.Error("Could not read the journal, err=", err)
+			return err
+		}
+	}
+
+	// writes the snapshot and starts new journal
+	err := lbs.compact()
+	if err == nil {
+		err = lbs.jrnl.open()
+	}
+	if err != nil {
+		return err
+	}
+	os.Remove(lbs.metaFN)
+	lbs.logger.Info(len(lbs.objects), " objects in the store ")
+	return nil
+}
+
+func (lbs *LfsBlobStorage) readObjectsFromJournal() error {
+	st, err := lbs.jrnl.load()
+	if err != nil {
+		return err
+	}
+
+	// check the final state of blobs mentioned in the journal
+	checked := 0
+	for id := range st.touched {
+		fileName, err := lbs.getFilePath(id)
+		if err != nil {
+			return err
+		}
+		bm, ok := st.objects[id]
+		if !ok {
+			// could be deleted, but the file was not removed yet
+			os.Remove(fileName)
+			continue
+		}
+		fi, err := os.Stat(fileName)
+		if err != nil {
+			lbs.logger.Debug("Journaled object id=", id, " has no file, skipping it.")
+			delete(st.objects, id)
+			continue
+		}
+		bm.Size = fi.Size()
+		checked++
+	}
+
+	lbs.objects = st.objects
+	lbs.lru.Clear()
+	for _, bm := range st.ordered {
+		if lbs.objects[bm.Id] == bm {
+			lbs.lru.Add(bm.Id, bm, bm.Size)
+		}
+	}
+	lbs.logger.Info(len(lbs.objects), " objects restored from the journal, ", checked, " were checked in the folder")
+	return nil
+}
+
+func (lbs *LfsBlobStorage) readObjectsFromFolder() error {
 	lbs.logger.Info("Reading data objects from ", lbs.storeDir, " ...")
 	scanned, err := lbs.scanFolder(lbs.storeDir)
 	if err != nil {
@@ -304,10 +534,7 @@ func (lbs *LfsBlobStorage) readObjects() error {
 		bm := sl.At(i).(*BlobMeta)
 		lbs.lru.Add(bm.Id, bm, bm.Size)
 	}
-
-	lbs.logger.Info(len(lbs.objects), " objects in the store ")
 	return nil
-
 }
 
 func (lbs *LfsBlobStorage) scanFolder(folder string) (map[string]*BlobMeta, error) {
@@ -335,6 +562,7 @@ func (lbs *LfsBlobStorage) scanFolder(folder string) (map[string]*BlobMeta, erro
 			bm.Id = file
 			bm.Size = info.Size()
 			bm.Timestamp = info.ModTime()
+			bm.KeyId = readBlobKeyId(path)
 			res[file] = bm
 		}
 		return nil
@@ -376,37 +604,135 @@ func (lbs *LfsBlobStorage) readMetaFile() (map[string]*BlobMeta, error) {
 	return res, nil
 }
 
-func (lbs *LfsBlobStorage) saveObjects(checkTime bool) {
-	now := time.Now()
-	if checkTime && now.Sub(lbs.lastSave) < time.Minute {
-		lbs.logger.Debug("Skip save metadata due to timeout")
+// writes all known objects ordered by timestamp (the LRU order) to the
+// snapshot. Must be called under the write lock, waits for the background
+// compaction if it runs.
+func (lbs *LfsBlobStorage) compact() error {
+	if lbs.compactDone != nil {
+		<-lbs.compactDone
+		lbs.compactDone = nil
+	}
+	objs, stored := lbs.snapshotObjects()
+	if err := lbs.jrnl.rotate(); err != nil {
+		return err
+	}
+	sortBlobMetas(objs[:stored])
+	return lbs.jrnl.writeSnapshot(objs)
+}
+
+// called under the write lock. Only the metas are copied and the journal is
+// rotated under the lock, the snapshot is sorted and written in background.
+func (lbs *LfsBlobStorage) compactIfNeeded() {
+	if lbs.compactDone != nil {
+		select {
+		case <-lbs.compactDone:
+			lbs.compactDone = nil
+		default:
+			return
+		}
+	}
+	if !lbs.jrnl.needsCompaction(len(lbs.objects)) {
 		return
 	}
-	lbs.lastSave = now
 
-	_, err := os.Stat(lbs.metaFN)
-	if err == nil {
-		err := os.Remove(lbs.metaFN)
+	objs, stored := lbs.snapshotObjects()
+	if lbs.jrnl.rotate() != nil {
+		return
+	}
+	done := make(chan struct{})
+	lbs.compactDone = done
+	go func() {
+		defer close(done)
+		sortBlobMetas(objs[:stored])
+		lbs.jrnl.writeSnapshot(objs)
+	}()
+}
+
+// copies metas of all known objects, the stored ones go first. Pending ones
+// go last with unknown size, so they will be checked on the next start.
+// Returns the metas and the number of stored ones.
+func (lbs *LfsBlobStorage) snapshotObjects() ([]*BlobMeta, int) {
+	objs := make([]*BlobMeta, 0, len(lbs.objects)+len(lbs.pending))
+	for _, bm := range lbs.objects {
+		objs = append(objs, bm)
+	}
+	for _, bm := range lbs.pending {
+		objs = append(objs, &BlobMeta{Id: bm.Id, Timestamp: bm.Timestamp, Size: -1, KeyId: bm.KeyId, Checksum: bm.Checksum})
+	}
+	return objs, len(lbs.objects)
+}
+
+// sorts the metas by timestamp, the LRU order
+func sortBlobMetas(objs []*BlobMeta) {
+	sort.Slice(objs, func(i, j int) bool {
+		return compBlobMeta(objs[i], objs[j]) < 0
+	})
+}
+
+// reads the object file content and checks it against the object meta. The
+// file is read out of the lock, so it could be replaced by Add() for the same
+// id in between, the second attempt is made in the case.
+func (lbs *LfsBlobStorage) readVerified(objId string) ([]byte, *BlobMeta, error) {
+	fileName, err := lbs.getFilePath(objId)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for attempt := 0; ; attempt++ {
+		lbs.rwLock.RLock()
+		bMeta, ok := lbs.objects[objId]
+		lbs.rwLock.RUnlock()
+		if !ok {
+			return nil, nil, common.NewError(common.ERR_NOT_FOUND, "Could not find BLOB by id="+objId)
+		}
+
+		data, err := ioutil.ReadFile(fileName)
+		if os.IsNotExist(err) {
+			return nil, bMeta, common.NewError(common.ERR_NOT_FOUND, "Found metadata, but could not find the file "+fileName)
+		}
 		if err != nil {
-			lbs.logger.Error("Could not remove file ", lbs.metaFN, " seems unrecoverable error=", err)
-			panic(err)
+			return nil, bMeta, err
+		}
+
+		err = checkBlob(bMeta, data)
+		if err == nil {
+			return data, bMeta, nil
+		}
+
+		lbs.rwLock.RLock()
+		bMeta2 := lbs.objects[objId]
+		lbs.rwLock.RUnlock()
+		if bMeta2 == bMeta || attempt > 0 {
+			return nil, bMeta, err
 		}
 	}
+}
 
-	file, err := os.Create(lbs.metaFN)
+// writes content to a temporary file and renames it, so the blob file is
+// either complete or absent
+func (lbs *LfsBlobStorage) writeFile(fileName string, r io.Reader) (int64, error) {
+	tmpFN := fileName + ".tmp"
+	file, err := os.Create(tmpFN)
 	if err != nil {
-		lbs.logger.Error("Could not save objects infos to ", lbs.metaFN)
-		return
+		lbs.logger.Error("Could not create new file ", tmpFN)
+		return 0, err
 	}
 
-	defer file.Close()
-	lbs.logger.Debug("Saving ", len(lbs.objects), " objects to ", lbs.metaFN)
+	size, err := io.Copy(file, r)
+	file.Close()
+	if err != nil {
+		lbs.logger.Error("Could not copy data to fileName=", tmpFN, ", err=", err)
+		os.Remove(tmpFN)
+		return 0, err
+	}
 
-	encoder := json.NewEncoder(file)
-	err = encoder.Encode(lbs.objects)
+	err = os.Rename(tmpFN, fileName)
 	if err != nil {
-		lbs.logger.Error("Could not save objects infos to ", lbs.metaFN, " error=", err)
+		lbs.logger.Error("Could not rename ", tmpFN, " to ", fileName, ", err=", err)
+		os.Remove(tmpFN)
+		return 0, err
 	}
+	return size, nil
 }
 
 func (lbs *LfsBlobStorage) getFilePath(id string) (string, error) {
@@ -4,11 +4,13 @@ import (
 	"bytes"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
 	"path/filepath"
 	"reflect"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/pixty/console/common"
 )
@@ -88,8 +90,9 @@ func TestStandartCycle(t *testing.T) {
 
 	lbs2.Shutdown()
 
-	// remove .meta
-	os.Remove(filepath.Join(lbs.storeDir, cMetaFileName))
+	// remove the journal, so the folder will be scanned
+	os.Remove(filepath.Join(lbs.storeDir, cJrnlSnapFileName))
+	os.Remove(filepath.Join(lbs.storeDir, cJrnlFileName))
 	lbs3 := NewLfsBlobStorage(lbs.storeDir, 1000000000)
 
 	r2, meta2 = lbs3.Read(id)
@@ -144,6 +147,150 @@ func TestSize(t *testing.T) {
 	lbs.Shutdown()
 }
 
+func TestJournalCrashRestore(t *testing.T) {
+	lbs := initLbs()
+	defer removeDir(lbs.storeDir)
+
+	id1, _ := lbs.Add(strings.NewReader("0123456789"), nil)
+	id2, _ := lbs.Add(strings.NewReader("01234"), nil)
+	id3, _ := lbs.Add(strings.NewReader("012"), nil)
+	lbs.Delete(id2)
+	// same id again must not be counted twice
+	lbs.Add(strings.NewReader("0123"), &BlobMeta{Id: id3, Timestamp: time.Now()})
+
+	// kill -9, no Shutdown()
+	lbs.jrnl.close()
+
+	lbs2 := NewLfsBlobStorage(lbs.storeDir, 1000000000)
+	defer lbs2.Shutdown()
+	if lbs2.ReadMeta(id1) == nil || lbs2.ReadMeta(id3) == nil {
+		t.Fatal("id1 and id3 should be restored")
+	}
+	if lbs2.ReadMeta(id2) != nil {
+		t.Fatal("id2 was deleted")
+	}
+	if lbs2.lru.Len() != 2 || lbs2.lru.Size() != 14 {
+		t.Fatal("Expecting 2 objects 14 bytes, but ", lbs2)
+	}
+	r, _ := lbs2.Read(id3)
+	if readString(r) != "0123" {
+		t.Fatal("id3 content is wrong")
+	}
+}
+
+func TestJournalTornRecord(t *testing.T) {
+	lbs := initLbs()
+	defer removeDir(lbs.storeDir)
+
+	id, _ := lbs.Add(strings.NewReader("0123456789"), nil)
+	lbs.jrnl.close()
+
+	// the process died in the middle of writing records, the file for
+	// the first one was not created
+	f, _ := os.OpenFile(filepath.Join(lbs.storeDir, cJrnlFileName), os.O_WRONLY|os.O_APPEND, 0644)
+	f.WriteString("A ghost 1500000000000000000\nA torn 15000")
+	f.Close()
+
+	lbs2 := NewLfsBlobStorage(lbs.storeDir, 1000000000)
+	if lbs2.ReadMeta(id) == nil || lbs2.ReadMeta("ghost") != nil || lbs2.ReadMeta("torn") != nil {
+		t.Fatal("Only id should be restored")
+	}
+
+	// the torn tail must not break records written after the restart
+	id2, _ := lbs2.Add(strings.NewReader("01234"), nil)
+	lbs2.jrnl.close()
+
+	lbs3 := NewLfsBlobStorage(lbs.storeDir, 1000000000)
+	defer lbs3.Shutdown()
+	if lbs3.ReadMeta(id) == nil || lbs3.ReadMeta(id2) == nil || lbs3.lru.Size() != 15 {
+		t.Fatal("Both objects should be restored, but ", lbs3)
+	}
+}
+
+func TestJournalCompaction(t *testing.T) {
+	lbs := initLbs()
+	defer removeDir(lbs.storeDir)
+
+	id, _ := lbs.Add(strings.NewReader("01234"), nil)
+	for i := 0; i < cJrnlCompactMinRecs; i++ {
+		lbs.Delete(lbs.ReadMeta(id).Id)
+		id, _ = lbs.Add(strings.NewReader("01234"), nil)
+	}
+	if lbs.jrnl.recs >= cJrnlCompactMinRecs {
+		t.Fatal("The journal should be compacted, but it has ", lbs.jrnl.recs, " records")
+	}
+	<-lbs.compactDone
+	if common.DoesFileExist(lbs.jrnl.oldFN) {
+		t.Fatal("The old journal should be removed after the snapshot is written")
+	}
+	lbs.jrnl.close()
+
+	lbs2 := NewLfsBlobStorage(lbs.storeDir, 1000000000)
+	defer lbs2.Shutdown()
+	if lbs2.lru.Len() != 1 || lbs2.ReadMeta(id) == nil {
+		t.Fatal("Expecting only one object ", id, ", but ", lbs2)
+	}
+}
+
+func TestJournalRotatedRestore(t *testing.T) {
+	lbs := initLbs()
+	defer removeDir(lbs.storeDir)
+
+	id1, _ := lbs.Add(strings.NewReader("0123456789"), nil)
+	id2, _ := lbs.Add(strings.NewReader("01234"), nil)
+	// the process died after the journal rotation, but before the snapshot
+	// was written
+	lbs.jrnl.rotate()
+	lbs.Delete(id1)
+	id3, _ := lbs.Add(strings.NewReader("012"), nil)
+	lbs.jrnl.close()
+
+	lbs2 := NewLfsBlobStorage(lbs.storeDir, 1000000000)
+	if lbs2.ReadMeta(id1) != nil || lbs2.ReadMeta(id2) == nil || lbs2.ReadMeta(id3) == nil {
+		t.Fatal("Expecting id2 and id3 only, but ", lbs2)
+	}
+	// the failed snapshot again, the old journal records must be kept
+	lbs2.jrnl.rotate()
+	id4, _ := lbs2.Add(strings.NewReader("0"), nil)
+	lbs2.jrnl.rotate()
+	lbs2.jrnl.close()
+
+	lbs3 := NewLfsBlobStorage(lbs.storeDir, 1000000000)
+	defer lbs3.Shutdown()
+	if lbs3.lru.Len() != 3 || lbs3.lru.Size() != 9 || lbs3.ReadMeta(id4) == nil {
+		t.Fatal("Expecting id2, id3 and id4, but ", lbs3)
+	}
+	if common.DoesFileExist(lbs3.jrnl.oldFN) {
+		t.Fatal("The old journal should be removed on start")
+	}
+}
+
+func TestLfsVerify(t *testing.T) {
+	lbs := initLbs()
+	defer lbs.Shutdown()
+	defer removeDir(lbs.storeDir)
+
+	id, _ := lbs.Add(strings.NewReader("0123456789"), nil)
+	if bm := lbs.ReadMeta(id); bm.Checksum == "" || lbs.Verify(id) != nil {
+		t.Fatal("The object must have checksum and be consistent ", bm)
+	}
+
+	// same size, but different content
+	fn, _ := lbs.getFilePath(id)
+	ioutil.WriteFile(fn, []byte("0123456780"), 0640)
+	if err := lbs.Verify(id); !common.CheckError(err, common.ERR_INVALID_VAL) {
+		t.Fatal("Expecting the object is corrupted, but err=", err)
+	}
+	if r, _ := lbs.Read(id); r != nil {
+		t.Fatal("Corrupted object must not be read")
+	}
+
+	os.Remove(fn)
+	if err := lbs.Verify(id); !common.CheckError(err, common.ERR_NOT_FOUND) {
+		t.Fatal("Expecting the object is missing, but err=", err)
+	}
+}
+
 func initLbs() *LfsBlobStorage {
 	lbs := NewLfsBlobStorage(getUniqueDir(), 1000000000)
 	return lbs
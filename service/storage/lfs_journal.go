@@ -0,0 +1,352 @@
+package storage
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jrivets/log4g"
+	"github.com/pixty/console/common"
+)
+
+const (
+	cJrnlFileName     = ".journal"
+	cJrnlOldFileName  = ".journal.old"
+	cJrnlSnapFileName = ".snapshot"
+
+	// the journal is compacted when it has more records than objects in the
+	// storage, but not earlier than it reaches the number
+	cJrnlCompactMinRecs = 10000
+
+	cJrnlOpAdd    = "A"
+	cJrnlOpDelete = "D"
+)
+
+type (
+	// lfs_journal keeps LfsBlobStorage metadata in two files:
+	// - .snapshot contains all known objects ordered from oldest to newest
+	//   (in LRU order), one "<id> <unix nanos> <size> [<keyId> [<checksum>]]" line per object. It is
+	//   always written to a temporary file and then renamed, so it is either
+	//   old or new one, but never partial.
+	// - .journal is append-only log of changes made after the snapshot:
+	//   "A <id> <unix nanos> [<keyId> [<checksum>]]" is written BEFORE a blob file is created and
+	//   "D <id>" is written BEFORE the blob file is removed.
+	//   Empty keyId is written as "-" if the checksum follows.
+	// - .journal.old is the journal which is being compacted. The journal is
+	//   rotated under the storage lock, and the snapshot is written later, so
+	//   new records go to the new journal meanwhile. The file is removed as
+	//   soon as the snapshot is written.
+	//
+	// Sizes are not journaled. The blobs mentioned in the journal are checked
+	// against the file system on load, which is cheap since the journal is
+	// short after compaction. Every record hits the OS as soon as it is
+	// written, so killing the process loses nothing. A torn last line is
+	// ignored on load.
+	lfs_journal struct {
+		logger log4g.Logger
+		jrnlFN string
+		oldFN  string
+		snapFN string
+		file   *os.File
+		recs   int
+	}
+
+	// state restored from snapshot and journal
+	lfs_jrnl_state struct {
+		// objects in LRU order, could contain same id several times, only
+		// the last one is relevant
+		ordered []*BlobMeta
+		objects map[string]*BlobMeta
+		// ids which were mentioned in the journal, their final state should
+		// be checked against the file system
+		touched map[string]bool
+	}
+)
+
+func newLfsJournal(dir string) *lfs_journal {
+	jrnl := new(lfs_journal)
+	jrnl.logger = log4g.GetLogger("pixty.service.LfsBlobStorage.journal")
+	jrnl.jrnlFN = filepath.Join(dir, cJrnlFileName)
+	jrnl.oldFN = filepath.Join(dir, cJrnlOldFileName)
+	jrnl.snapFN = filepath.Join(dir, cJrnlSnapFileName)
+	return jrnl
+}
+
+// returns whether either snapshot or journal exists, if not the storage
+// metadata should be restored by scanning the folder.
+func (jrnl *lfs_journal) exists() bool {
+	return common.DoesFileExist(jrnl.snapFN) || common.DoesFileExist(jrnl.jrnlFN) ||
+		common.DoesFileExist(jrnl.oldFN)
+}
+
+// reads snapshot and replays the old and the current journals on top of it
+func (jrnl *lfs_journal) load() (*lfs_jrnl_state, error) {
+	st := &lfs_jrnl_state{ordered: make([]*BlobMeta, 0, 100), objects: make(map[string]*BlobMeta),
+		touched: make(map[string]bool)}
+
+	snaps, err := jrnl.readLines(jrnl.snapFN, func(flds []string) bool {
+		if len(flds) < 3 || len(flds) > 5 {
+			return false
+		}
+		ts, err1 := strconv.ParseInt(flds[1], 10, 64)
+		sz, err2 := strconv.ParseInt(flds[2], 10, 64)
+		if err1 != nil || err2 != nil {
+			return false
+		}
+		bm := &BlobMeta{Id: flds[0], Timestamp: time.Unix(0, ts), Size: sz}
+		setOptFields(bm, flds[3:])
+		if sz < 0 {
+			// the blob was being written while the snapshot was made
+			st.touched[bm.Id] = true
+		}
+		st.add(bm)
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	replay := func(flds []string) bool {
+		switch {
+		case len(flds) >= 3 && len(flds) <= 5 && flds[0] == cJrnlOpAdd:
+			ts, err := strconv.ParseInt(flds[2], 10, 64)
+			if err != nil {
+				return false
+			}
+			bm := &BlobMeta{Id: flds[1], Timestamp: time.Unix(0, ts), Size: -1}
+			setOptFields(bm, flds[3:])
+			st.add(bm)
+		case len(flds) == 2 && flds[0] == cJrnlOpDelete:
+			delete(st.objects, flds[1])
+		default:
+			return false
+		}
+		st.touched[flds[1]] = true
+		return true
+	}
+	oldRecs, err := jrnl.readLines(jrnl.oldFN, replay)
+	if err != nil {
+		return nil, err
+	}
+	recs, err := jrnl.readLines(jrnl.jrnlFN, replay)
+	if err != nil {
+		return nil, err
+	}
+	recs += oldRecs
+
+	jrnl.logger.Info(snaps, " records read from snapshot, ", recs, " records replayed from journal")
+	return st, nil
+}
+
+func (st *lfs_jrnl_state) add(bm *BlobMeta) {
+	st.objects[bm.Id] = bm
+	st.ordered = append(st.ordered, bm)
+}
+
+// opens the journal for appending
+func (jrnl *lfs_journal) open() error {
+	f, err := os.OpenFile(jrnl.jrnlFN, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		jrnl.logger.Error("Could not open journal ", jrnl.jrnlFN, ", err=", err)
+		return err
+	}
+	jrnl.file = f
+	return nil
+}
+
+func (jrnl *lfs_journal) close() {
+	if jrnl.file != nil {
+		jrnl.file.Close()
+		jrnl.file = nil
+	}
+}
+
+func (jrnl *lfs_journal) logAdd(bm *BlobMeta) error {
+	return jrnl.write(fmt.Sprint(cJrnlOpAdd, " ", bm.Id, " ", bm.Timestamp.UnixNano(), optFields(bm), "\n"))
+}
+
+func (jrnl *lfs_journal) logDelete(id string) error {
+	return jrnl.write(fmt.Sprint(cJrnlOpDelete, " ", id, "\n"))
+}
+
+func (jrnl *lfs_journal) needsCompaction(objects int) bool {
+	return jrnl.recs >= cJrnlCompactMinRecs && jrnl.recs > objects
+}
+
+// writes new snapshot which contains objs (LRU order, oldest first) and
+// truncates the journal.
+func (jrnl *lfs_journal) compact(objs []*BlobMeta) error {
+	if err := jrnl.rotate(); err != nil {
+		return err
+	}
+	return jrnl.writeSnapshot(objs)
+}
+
+// moves the journal records to .journal.old and starts new journal, so the
+// snapshot of the current state could be written by writeSnapshot() while
+// new records are journaled. If .journal.old is left by a failed snapshot,
+// the journal is appended to it, the records are needed still.
+func (jrnl *lfs_journal) rotate() error {
+	reopen := jrnl.file != nil
+	jrnl.close()
+
+	var err error
+	if common.DoesFileExist(jrnl.oldFN) {
+		err = jrnl.appendTo(jrnl.oldFN)
+	} else {
+		err = os.Rename(jrnl.jrnlFN, jrnl.oldFN)
+	}
+	if err != nil && !os.IsNotExist(err) {
+		jrnl.logger.Error("Could not rotate journal ", jrnl.jrnlFN, ", err=", err)
+		if reopen {
+			jrnl.open()
+		}
+		return err
+	}
+	jrnl.recs = 0
+	if reopen {
+		return jrnl.open()
+	}
+	return nil
+}
+
+// appends the journal to fn and removes it
+func (jrnl *lfs_journal) appendTo(fn string) error {
+	src, err := os.Open(jrnl.jrnlFN)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := os.OpenFile(fn, os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(dst, src)
+	if err == nil {
+		err = dst.Sync()
+	}
+	dst.Close()
+	if err != nil {
+		return err
+	}
+	return os.Remove(jrnl.jrnlFN)
+}
+
+// writes new snapshot which contains objs (LRU order, oldest first) and
+// removes the rotated journal. Touches the files only, so it could be called
+// out of the storage lock while new records go to the journal. If the process
+// dies in the middle the old snapshot and both journals stay valid; if it dies
+// after the rename, but before the old journal removal, replaying the old
+// journal on top of the new snapshot gives the same state.
+func (jrnl *lfs_journal) writeSnapshot(objs []*BlobMeta) error {
+	tmpFN := jrnl.snapFN + ".tmp"
+	f, err := os.Create(tmpFN)
+	if err != nil {
+		jrnl.logger.Error("Could not create ", tmpFN, ", err=", err)
+		return err
+	}
+
+	w := bufio.NewWriter(f)
+	for _, bm := range objs {
+		fmt.Fprint(w, bm.Id, " ", bm.Timestamp.UnixNano(), " ", bm.Size, optFields(bm), "\n")
+	}
+	err = w.Flush()
+	if err == nil {
+		err = f.Sync()
+	}
+	f.Close()
+	if err == nil {
+		err = os.Rename(tmpFN, jrnl.snapFN)
+	}
+	if err != nil {
+		jrnl.logger.Error("Could not write snapshot ", jrnl.snapFN, ", err=", err)
+		os.Remove(tmpFN)
+		return err
+	}
+
+	err = os.Remove(jrnl.oldFN)
+	if err != nil && !os.IsNotExist(err) {
+		jrnl.logger.Error("Could not remove old journal ", jrnl.oldFN, ", err=", err)
+		return err
+	}
+	jrnl.logger.Info("Compacted, ", len(objs), " objects written to snapshot")
+	return nil
+}
+
+func (jrnl *lfs_journal) write(rec string) error {
+	if jrnl.file == nil {
+		return common.NewError(common.ERR_INVALID_VAL, "journal is closed")
+	}
+	_, err := io.WriteString(jrnl.file, rec)
+	if err != nil {
+		jrnl.logger.Error("Could not write journal record ", rec, ", err=", err)
+		return err
+	}
+	jrnl.recs++
+	return nil
+}
+
+// reads the file line by line and calls f for every complete line. Stops on
+// the first line which is not accepted by f - this is the place where the
+// process died.
+func (jrnl *lfs_journal) readLines(fn string, f func(flds []string) bool) (int, error) {
+	file, err := os.Open(fn)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		jrnl.logger.Error("Could not open ", fn, ", err=", err)
+		return 0, err
+	}
+	defer file.Close()
+
+	cnt := 0
+	r := bufio.NewReader(file)
+	for {
+		line, err := r.ReadString('\n')
+		if err == io.EOF {
+			if line != "" {
+				jrnl.logger.Warn("Incomplete last record in ", fn, " will be ignored: ", line)
+			}
+			return cnt, nil
+		}
+		if err != nil {
+			jrnl.logger.Error("Could not read ", fn, ", err=", err)
+			return cnt, err
+		}
+		if !f(strings.Fields(line)) {
+			jrnl.logger.Warn("Corrupted record in ", fn, " after ", cnt, " good ones, the rest is ignored: ", line)
+			return cnt, nil
+		}
+		cnt++
+	}
+}
+
+func optFields(bm *BlobMeta) string {
+	if bm.Checksum != "" {
+		keyId := bm.KeyId
+		if keyId == "" {
+			keyId = "-"
+		}
+		return " " + keyId + " " + bm.Checksum
+	}
+	if bm.KeyId != "" {
+		return " " + bm.KeyId
+	}
+	return ""
+}
+
+func setOptFields(bm *BlobMeta, flds []string) {
+	if len(flds) > 0 && flds[0] != "-" {
+		bm.KeyId = flds[0]
+	}
+	if len(flds) > 1 {
+		bm.Checksum = flds[1]
+	}
+}
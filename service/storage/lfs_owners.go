@@ -0,0 +1,225 @@
+package storage
+
+import (
+	"math"
+	"os"
+
+	"github.com/jrivets/gorivets"
+)
+
+type (
+	// objects of one owner. The owner LRU is limited by the owner quota, so
+	// when the owner exceeds it, only its own oldest objects are evicted.
+	lfs_owner struct {
+		id    int64
+		lru   gorivets.LRU
+		usage map[int64]*BlobUsage
+	}
+
+	lfs_owned struct {
+		// the size the object is accounted with
+		size     int64
+		subOwner int64
+	}
+
+	// the object owner and the owner quota got from the OwnerResolver
+	lfs_owner_ref struct {
+		owner    int64
+		subOwner int64
+		quota    int64
+	}
+)
+
+// Returns the owner usage. The BlobUsageReporter implementation.
+func (lbs *LfsBlobStorage) GetOwnerUsage(owner int64) *BlobOwnerUsage {
+	lbs.prepareOwners()
+	var quota int64
+	if lbs.OwnerResolver != nil {
+		quota = lbs.OwnerResolver.GetOwnerQuota(owner)
+	}
+
+	lbs.rwLock.RLock()
+	defer lbs.rwLock.RUnlock()
+
+	o, ok := lbs.owners[owner]
+	if !ok {
+		return nil
+	}
+
+	res := &BlobOwnerUsage{Owner: owner, Quota: quota, SubOwners: make(map[int64]*BlobUsage, len(o.usage))}
+	for so, u := range o.usage {
+		res.SubOwners[so] = &BlobUsage{Objects: u.Objects, Size: u.Size}
+		res.Objects += u.Objects
+		res.Size += u.Size
+	}
+	return res
+}
+
+// The owners accounting is built lazily on first demand, cause the resolver
+// could require other components (DB) which are not available while the
+// storage is initialized. The resolver is never called under the storage
+// lock, so the owners of the known objects are resolved first and then
+// accounted under the lock. If objects were added in between, their owners
+// are resolved and the lock is taken again. Must be called without the lock
+// held.
+func (lbs *LfsBlobStorage) prepareOwners() {
+	if lbs.OwnerResolver == nil {
+		return
+	}
+
+	// resolved object ids, refs contains the ones which have an owner
+	resolved := make(map[string]bool)
+	refs := make(map[string]*lfs_owner_ref)
+	quotas := make(map[int64]int64)
+
+	lbs.rwLock.RLock()
+	if lbs.ownersReady {
+		lbs.rwLock.RUnlock()
+		return
+	}
+	ids := lbs.unresolvedIds(resolved)
+	lbs.rwLock.RUnlock()
+
+	for {
+		for _, id := range ids {
+			resolved[id] = true
+			owner, subOwner, ok := lbs.OwnerResolver.GetOwner(id)
+			if !ok {
+				continue
+			}
+			quota, ok := quotas[owner]
+			if !ok {
+				quota = lbs.OwnerResolver.GetOwnerQuota(owner)
+				quotas[owner] = quota
+			}
+			refs[id] = &lfs_owner_ref{owner, subOwner, quota}
+		}
+
+		lbs.rwLock.Lock()
+		if lbs.ownersReady {
+			lbs.rwLock.Unlock()
+			return
+		}
+		ids = lbs.unresolvedIds(resolved)
+		if len(ids) == 0 {
+			break
+		}
+		lbs.rwLock.Unlock()
+	}
+	defer lbs.rwLock.Unlock()
+
+	lbs.ownersReady = true
+	lbs.owners = make(map[int64]*lfs_owner)
+	lbs.objOwners = make(map[string]*lfs_owner)
+
+	sl, err := gorivets.NewSortedSliceByComp(compBlobMeta, gorivets.Max(len(lbs.objects), 1))
+	if err != nil {
+		lbs.logger.Error("Could not create new sorted slice, err=", err)
+		return
+	}
+	for _, v := range lbs.objects {
+		sl.Add(v)
+	}
+	for i := 0; i < sl.Len(); i++ {
+		bm := sl.At(i).(*BlobMeta)
+		lbs.accountAdd(bm, refs[bm.Id])
+	}
+	lbs.logger.Info("Objects of ", len(lbs.owners), " owners are accounted, ", len(lbs.objOwners), " objects total")
+}
+
+// returns ids of the known objects which are not resolved yet. Must be called
+// under the lock.
+func (lbs *LfsBlobStorage) unresolvedIds(resolved map[string]bool) []string {
+	var ids []string
+	for id := range lbs.objects {
+		if !resolved[id] {
+			ids = append(ids, id)
+		}
+	}
+	return ids
+}
+
+// Resolves the object owner, returns nil if the object has no owner. Must be
+// called without the lock held.
+func (lbs *LfsBlobStorage) resolveOwner(id string) *lfs_owner_ref {
+	if lbs.OwnerResolver == nil {
+		return nil
+	}
+	owner, subOwner, ok := lbs.OwnerResolver.GetOwner(id)
+	if !ok {
+		return nil
+	}
+	return &lfs_owner_ref{owner, subOwner, lbs.OwnerResolver.GetOwnerQuota(owner)}
+}
+
+// accounts the object for the owner resolved by resolveOwner() before
+func (lbs *LfsBlobStorage) accountAdd(bm *BlobMeta, ref *lfs_owner_ref) {
+	if !lbs.ownersReady || ref == nil {
+		return
+	}
+
+	o, ok := lbs.owners[ref.owner]
+	if !ok {
+		quota := ref.quota
+		if quota <= 0 {
+			quota = math.MaxInt64
+		}
+		o = &lfs_owner{id: ref.owner, usage: make(map[int64]*BlobUsage)}
+		o.lru = gorivets.NewLRU(quota, lbs.onOwnerLRUDelete)
+		lbs.owners[ref.owner] = o
+		lbs.logger.Info("New owner ", ref.owner, " with quota ", quota, " bytes")
+	}
+
+	u, ok := o.usage[ref.subOwner]
+	if !ok {
+		u = &BlobUsage{}
+		o.usage[ref.subOwner] = u
+	}
+	u.Objects++
+	u.Size += bm.Size
+	lbs.objOwners[bm.Id] = o
+	o.lru.Add(bm.Id, &lfs_owned{bm.Size, ref.subOwner}, bm.Size)
+}
+
+func (lbs *LfsBlobStorage) accountDelete(id string) {
+	o, ok := lbs.objOwners[id]
+	if !ok {
+		return
+	}
+	v := o.lru.DeleteWithCallback(id, false)
+	if v != nil {
+		lbs.unaccount(o, id, v.(*lfs_owned))
+	}
+}
+
+func (lbs *LfsBlobStorage) unaccount(o *lfs_owner, id string, ow *lfs_owned) {
+	delete(lbs.objOwners, id)
+	u := o.usage[ow.subOwner]
+	u.Objects--
+	u.Size -= ow.size
+	if u.Objects <= 0 {
+		delete(o.usage, ow.subOwner)
+	}
+}
+
+// The owner exceeds its quota, the object is removed from the storage
+func (lbs *LfsBlobStorage) onOwnerLRUDelete(k, v interface{}) {
+	id := k.(string)
+	ow := v.(*lfs_owned)
+	o := lbs.objOwners[id]
+	lbs.logger.Debug("Owner ", o.id, " quota exceeded, deleting object id=", id)
+	lbs.unaccount(o, id, ow)
+
+	lbs.lru.DeleteWithCallback(id, false)
+	delete(lbs.objects, id)
+	if lbs.jrnl.logDelete(id) != nil {
+		// keep the file, it is still known after restart
+		return
+	}
+	fp, err := lbs.getFilePath(id)
+	if err != nil {
+		lbs.logger.Warn("Could not obtain filePath for id=", id)
+	} else {
+		go os.Remove(fp)
+	}
+}
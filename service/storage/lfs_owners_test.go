@@ -0,0 +1,129 @@
+package storage
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// ids are like "<owner>_<subOwner>_<n>"
+type test_owner_resolver struct {
+	quotas map[int64]int64
+}
+
+func (tor *test_owner_resolver) GetOwner(objId string) (int64, int64, bool) {
+	parts := strings.Split(objId, "_")
+	if len(parts) != 3 {
+		return 0, 0, false
+	}
+	owner, _ := strconv.ParseInt(parts[0], 10, 64)
+	subOwner, _ := strconv.ParseInt(parts[1], 10, 64)
+	return owner, subOwner, true
+}
+
+func (tor *test_owner_resolver) GetOwnerQuota(owner int64) int64 {
+	return tor.quotas[owner]
+}
+
+func addOwned(lbs *LfsBlobStorage, id string, ts time.Time) {
+	lbs.Add(strings.NewReader("0123456789"), &BlobMeta{Id: id, Timestamp: ts})
+}
+
+func TestOwnerQuota(t *testing.T) {
+	lbs := initLbs()
+	defer lbs.Shutdown()
+	defer removeDir(lbs.storeDir)
+	lbs.OwnerResolver = &test_owner_resolver{map[int64]int64{1: 30}}
+
+	now := time.Now()
+	addOwned(lbs, "1_1_1", now)
+	addOwned(lbs, "1_2_2", now.Add(time.Second))
+	addOwned(lbs, "2_3_3", now.Add(2*time.Second))
+	addOwned(lbs, "2_3_4", now.Add(3*time.Second))
+	addOwned(lbs, "1_1_5", now.Add(4*time.Second))
+	addOwned(lbs, "unknown", now.Add(5*time.Second))
+
+	ou := lbs.GetOwnerUsage(1)
+	if ou.Objects != 3 || ou.Size != 30 || ou.Quota != 30 || len(ou.SubOwners) != 2 || ou.SubOwners[1].Objects != 2 {
+		t.Fatal("Wrong usage of the owner 1 ", ou)
+	}
+
+	// owner 1 exceeds the quota, its oldest object must go away
+	addOwned(lbs, "1_2_6", now.Add(6*time.Second))
+	if lbs.ReadMeta("1_1_1") != nil {
+		t.Fatal("1_1_1 should be evicted")
+	}
+	if lbs.ReadMeta("2_3_3") == nil || lbs.ReadMeta("unknown") == nil {
+		t.Fatal("Other owners objects must not be affected")
+	}
+	ou = lbs.GetOwnerUsage(1)
+	if ou.Objects != 3 || ou.Size != 30 || ou.SubOwners[1].Objects != 1 || ou.SubOwners[2].Objects != 2 {
+		t.Fatal("Wrong usage of the owner 1 after eviction ", ou)
+	}
+
+	lbs.Delete("2_3_3")
+	lbs.DeleteAllWithPrefix("2_3_")
+	if lbs.GetOwnerUsage(2).Objects != 0 || len(lbs.GetOwnerUsage(2).SubOwners) != 0 {
+		t.Fatal("Owner 2 must have no objects ", lbs.GetOwnerUsage(2))
+	}
+	if lbs.GetOwnerUsage(3) != nil {
+		t.Fatal("Owner 3 is not known")
+	}
+}
+
+func TestOwnersLazyInit(t *testing.T) {
+	lbs := initLbs()
+	defer removeDir(lbs.storeDir)
+
+	now := time.Now()
+	for i := 0; i < 5; i++ {
+		addOwned(lbs, "1_1_"+strconv.Itoa(i), now.Add(time.Duration(i)*time.Second))
+	}
+	lbs.Shutdown()
+
+	// the storage is restarted with the quota, which is already exceeded
+	lbs2 := NewLfsBlobStorage(lbs.storeDir, 1000000000)
+	defer lbs2.Shutdown()
+	lbs2.OwnerResolver = &test_owner_resolver{map[int64]int64{1: 20}}
+
+	ou := lbs2.GetOwnerUsage(1)
+	if ou.Objects != 2 || ou.Size != 20 {
+		t.Fatal("Expecting 2 objects of owner 1, but ", ou)
+	}
+	if lbs2.ReadMeta("1_1_3") == nil || lbs2.ReadMeta("1_1_4") == nil || lbs2.lru.Len() != 2 {
+		t.Fatal("Only 2 newest objects should stay, but ", lbs2)
+	}
+}
+
+// adds an object while the owners of the known ones are being resolved
+type test_racy_resolver struct {
+	test_owner_resolver
+	lbs *LfsBlobStorage
+}
+
+func (trr *test_racy_resolver) GetOwner(objId string) (int64, int64, bool) {
+	if lbs := trr.lbs; lbs != nil {
+		trr.lbs = nil
+		bm := &BlobMeta{Id: "1_2_new", Timestamp: time.Now(), Size: 10}
+		lbs.rwLock.Lock()
+		lbs.objects[bm.Id] = bm
+		lbs.lru.Add(bm.Id, bm, bm.Size)
+		lbs.rwLock.Unlock()
+	}
+	return trr.test_owner_resolver.GetOwner(objId)
+}
+
+func TestOwnersAddedWhileResolved(t *testing.T) {
+	lbs := initLbs()
+	defer lbs.Shutdown()
+	defer removeDir(lbs.storeDir)
+
+	addOwned(lbs, "1_1_1", time.Now())
+	lbs.OwnerResolver = &test_racy_resolver{test_owner_resolver{map[int64]int64{}}, lbs}
+
+	ou := lbs.GetOwnerUsage(1)
+	if ou.Objects != 2 || ou.Size != 20 || ou.SubOwners[2] == nil {
+		t.Fatal("The object added in between must be accounted, but ", ou)
+	}
+}
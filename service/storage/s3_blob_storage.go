@@ -0,0 +1,458 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jrivets/log4g"
+	"github.com/pixty/console/common"
+)
+
+const (
+	// user metadata header which keeps BlobMeta.Timestamp (unix millis)
+	cS3MetaTimestamp = "X-Amz-Meta-Pixty-Ts"
+	// user metadata header which keeps BlobMeta.KeyId
+	cS3MetaKeyId = "X-Amz-Meta-Pixty-Key"
+	// user metadata header which keeps BlobMeta.Checksum
+	cS3MetaChecksum = "X-Amz-Meta-Pixty-Crc"
+	cS3ListPageSize = 1000
+)
+
+type (
+	// S3 connection parameters
+	S3Config struct {
+		// Endpoint URL like "https://s3.amazonaws.com" or "http://127.0.0.1:9000"
+		Endpoint  string
+		Region    string
+		Bucket    string
+		AccessKey string
+		SecretKey string
+		// Prefix for all object keys, allows to share a bucket between
+		// different installations. Could be empty
+		KeyPrefix string
+	}
+
+	// BlobStorage on top of an S3-compatible object store. Objects are
+	// addressed in path-style (<endpoint>/<bucket>/<key>) so it works with
+	// AWS as well as with MinIO and similar. The storage doesn't limit its
+	// size, object expiration should be configured on the bucket level.
+	S3BlobStorage struct {
+		logger   log4g.Logger
+		cfg      S3Config
+		endpoint *url.URL
+		signer   *s3_signer
+		client   *http.Client
+		cipher   *BlobCipher
+	}
+
+	s3_list_result struct {
+		XMLName               xml.Name `xml:"ListBucketResult"`
+		Contents              []s3_list_obj
+		IsTruncated           bool
+		NextContinuationToken string
+	}
+
+	s3_list_obj struct {
+		Key  string
+		Size int64
+	}
+
+	s3_error struct {
+		XMLName xml.Name `xml:"Error"`
+		Code    string
+		Message string
+	}
+)
+
+func NewS3BlobStorage(cfg *S3Config) *S3BlobStorage {
+	logger := log4g.GetLogger("pixty.service.S3BlobStorage")
+	ep, err := url.Parse(strings.TrimRight(cfg.Endpoint, "/"))
+	if err != nil || ep.Host == "" {
+		logger.Error("Wrong S3 endpoint=", cfg.Endpoint, ", err=", err)
+		panic(common.NewError(common.ERR_INVALID_VAL, "wrong S3 endpoint "+cfg.Endpoint))
+	}
+	if cfg.Bucket == "" {
+		panic(common.NewError(common.ERR_INVALID_VAL, "S3 bucket name must be provided"))
+	}
+	s3bs := new(S3BlobStorage)
+	s3bs.logger = logger
+	s3bs.cfg = *cfg
+	s3bs.endpoint = ep
+	s3bs.signer = newS3Signer(cfg.AccessKey, cfg.SecretKey, cfg.Region)
+	s3bs.client = &http.Client{Timeout: 30 * time.Second}
+	return s3bs
+}
+
+// Turns on the encryption, all new objects will be encrypted by the cipher.
+// Must be called before the storage is used.
+func (s3bs *S3BlobStorage) SetCipher(bc *BlobCipher) {
+	s3bs.cipher = bc
+}
+
+func (e *s3_error) Error() string {
+	return fmt.Sprint("S3 error code=", e.Code, ", msg=", e.Message)
+}
+
+// ============================= LifeCycler ==================================
+func (s3bs *S3BlobStorage) DiPhase() int {
+	return common.CMP_PHASE_BLOB_STORE
+}
+
+func (s3bs *S3BlobStorage) DiInit() error {
+	s3bs.logger.Info("Initializing. Checking bucket ", s3bs)
+	resp, err := s3bs.do("HEAD", "", nil, nil, nil)
+	if err != nil {
+		s3bs.logger.Error("Could not reach the bucket, err=", err)
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		s3bs.logger.Error("The bucket check returned status=", resp.Status)
+		return common.NewError(common.ERR_NOT_FOUND, "S3 bucket "+s3bs.cfg.Bucket+" is not accessible: "+resp.Status)
+	}
+	return nil
+}
+
+func (s3bs *S3BlobStorage) DiShutdown() {
+	s3bs.logger.Info("Shutting down.")
+}
+
+func (s3bs *S3BlobStorage) String() string {
+	return fmt.Sprint("S3BlobStorage:{endpoint=", s3bs.endpoint, ", bucket=", s3bs.cfg.Bucket, ", region=",
+		s3bs.cfg.Region, ", keyPrefix=", s3bs.cfg.KeyPrefix, "}")
+}
+
+// ============================= BlobStorage =================================
+func (s3bs *S3BlobStorage) Add(r io.Reader, bMeta *BlobMeta) (string, error) {
+	s3bs.logger.Debug("Writing ", bMeta)
+	if r == nil {
+		s3bs.logger.Error("reader (r) is nil ")
+		return common.ID_NULL, common.NewError(common.ERR_INVALID_VAL, "reader cannot be nil")
+	}
+
+	if bMeta == nil {
+		bMeta = newBlobMeta()
+	}
+
+	id := bMeta.Id
+	if id == "" {
+		id = common.NewUUID()
+	}
+
+	// SigV4 requires the payload hash, so the content is read in advance.
+	// Images are small, so it is ok.
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		s3bs.logger.Error("Could not read data for id=", id, ", err=", err)
+		return "", err
+	}
+
+	hdrs := map[string]string{
+		"Content-Type":   "application/octet-stream",
+		cS3MetaTimestamp: strconv.FormatInt(int64(common.ToTimestamp(bMeta.Timestamp)), 10),
+	}
+	bMeta.KeyId = ""
+	if s3bs.cipher != nil {
+		data, bMeta.KeyId, err = s3bs.cipher.Encrypt(id, data)
+		if err != nil {
+			s3bs.logger.Error("Could not encrypt data for id=", id, ", err=", err)
+			return "", err
+		}
+		hdrs[cS3MetaKeyId] = bMeta.KeyId
+	}
+	bMeta.Checksum = blobChecksum(data)
+	hdrs[cS3MetaChecksum] = bMeta.Checksum
+	resp, err := s3bs.do("PUT", s3bs.key(id), nil, hdrs, data)
+	if err != nil {
+		s3bs.logger.Error("Could not put object id=", id, ", err=", err)
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		err = s3bs.respError(resp)
+		s3bs.logger.Error("Could not put object id=", id, ", err=", err)
+		return "", err
+	}
+
+	bMeta.Id = id
+	bMeta.Size = int64(len(data))
+	s3bs.logger.Debug("New BLOB with id=", id, " is created.")
+	return id, nil
+}
+
+func (s3bs *S3BlobStorage) Read(objId string) (io.ReadCloser, *BlobMeta) {
+	data, bMeta, err := s3bs.readVerified(objId)
+	if err != nil {
+		if common.CheckError(err, common.ERR_NOT_FOUND) {
+			s3bs.logger.Debug("Could not read BLOB id=", objId, ", err=", err)
+		} else {
+			s3bs.logger.Warn("Could not read BLOB id=", objId, ", err=", err)
+		}
+		return nil, nil
+	}
+
+	if bMeta.KeyId != "" {
+		if s3bs.cipher == nil {
+			s3bs.logger.Error("The object id=", objId, " is encrypted, but no keys provided.")
+			return nil, nil
+		}
+		data, _, err = s3bs.cipher.Decrypt(objId, data)
+		if err != nil {
+			s3bs.logger.Error("Could not decrypt object id=", objId, ", err=", err)
+			return nil, nil
+		}
+	}
+	s3bs.logger.Debug("Found BLOB id=", objId, " meta=", bMeta)
+	return newBlobMemReader(data), bMeta
+}
+
+func (s3bs *S3BlobStorage) ReadMeta(objId string) *BlobMeta {
+	resp, err := s3bs.do("HEAD", s3bs.key(objId), nil, nil, nil)
+	if err != nil {
+		s3bs.logger.Error("Could not get object meta id=", objId, ", err=", err)
+		return nil
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		s3bs.logger.Debug("Could not find BLOB by id=", objId, ", status=", resp.Status)
+		return nil
+	}
+	return s3bs.toBlobMeta(objId, resp)
+}
+
+func (s3bs *S3BlobStorage) Delete(objIds ...string) error {
+	var lastErr error
+	for _, objId := range objIds {
+		if err := s3bs.deleteObj(objId); err != nil {
+			lastErr = err
+		}
+	}
+	return lastErr
+}
+
+// Deletes the listed objects and returns the number of deleted ones. Nothing
+// is deleted if the objects could not be listed, the partial list is not
+// trusted.
+func (s3bs *S3BlobStorage) DeleteAllWithPrefix(prefix string) int {
+	ids, err := s3bs.list(prefix)
+	if err != nil {
+		s3bs.logger.Error("Could not list objects with prefix=", prefix, ", err=", err)
+		return 0
+	}
+	if len(ids) == 0 {
+		return 0
+	}
+
+	s3bs.logger.Debug("Deleting ", len(ids), " objects with prefix=", prefix)
+	deleted := 0
+	for _, id := range ids {
+		if s3bs.deleteObj(id) == nil {
+			deleted++
+		}
+	}
+	if deleted < len(ids) {
+		s3bs.logger.Warn("Only ", deleted, " of ", len(ids), " objects with prefix=", prefix, " are deleted")
+	}
+	return deleted
+}
+
+func (s3bs *S3BlobStorage) deleteObj(objId string) error {
+	s3bs.logger.Debug("Deleting BLOB by id=", objId)
+	resp, err := s3bs.do("DELETE", s3bs.key(objId), nil, nil, nil)
+	if err != nil {
+		s3bs.logger.Warn("Could not delete object id=", objId, ", err=", err)
+		return err
+	}
+	defer resp.Body.Close()
+	// S3 returns 204 even when the object doesn't exist
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK &&
+		resp.StatusCode != http.StatusNotFound {
+		err = s3bs.respError(resp)
+		s3bs.logger.Warn("Could not delete object id=", objId, ", err=", err)
+		return err
+	}
+	return nil
+}
+
+// ============================= BlobVerifier ================================
+func (s3bs *S3BlobStorage) Verify(objId string) error {
+	_, _, err := s3bs.readVerified(objId)
+	return err
+}
+
+// ============================= BlobLister ==================================
+func (s3bs *S3BlobStorage) ListIds(prefix string) ([]string, error) {
+	return s3bs.list(prefix)
+}
+
+// ============================ BlobEncryptor ================================
+func (s3bs *S3BlobStorage) ActiveKeyId() string {
+	if s3bs.cipher == nil {
+		return ""
+	}
+	return s3bs.cipher.ActiveKeyId()
+}
+
+// S3 has no locks, so the object is read and written back. An object which
+// is deleted in between could be restored, it is found by the pictures
+// reconciliation then.
+func (s3bs *S3BlobStorage) Rekey(objId string) (bool, error) {
+	if s3bs.cipher == nil {
+		return false, nil
+	}
+	data, bMeta, err := s3bs.readVerified(objId)
+	if common.CheckError(err, common.ERR_NOT_FOUND) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	if bMeta.KeyId == s3bs.cipher.ActiveKeyId() {
+		return false, nil
+	}
+
+	data, _, err = s3bs.cipher.Decrypt(objId, data)
+	if err != nil {
+		return false, err
+	}
+	_, err = s3bs.Add(bytes.NewReader(data), &BlobMeta{Id: objId, Timestamp: bMeta.Timestamp})
+	return err == nil, err
+}
+
+// =============================== Private ===================================
+func (s3bs *S3BlobStorage) key(id string) string {
+	return s3bs.cfg.KeyPrefix + id
+}
+
+// lists ids (keys without KeyPrefix) of all objects which id starts from prefix
+func (s3bs *S3BlobStorage) list(prefix string) ([]string, error) {
+	res := make([]string, 0, 10)
+	contToken := ""
+	for {
+		q := url.Values{}
+		q.Set("list-type", "2")
+		q.Set("prefix", s3bs.key(prefix))
+		q.Set("max-keys", strconv.Itoa(cS3ListPageSize))
+		if contToken != "" {
+			q.Set("continuation-token", contToken)
+		}
+
+		resp, err := s3bs.do("GET", "", q, nil, nil)
+		if err != nil {
+			return res, err
+		}
+		if resp.StatusCode != http.StatusOK {
+			err = s3bs.respError(resp)
+			resp.Body.Close()
+			return res, err
+		}
+
+		var lr s3_list_result
+		err = xml.NewDecoder(resp.Body).Decode(&lr)
+		resp.Body.Close()
+		if err != nil {
+			return res, err
+		}
+
+		for _, o := range lr.Contents {
+			res = append(res, strings.TrimPrefix(o.Key, s3bs.cfg.KeyPrefix))
+		}
+
+		if !lr.IsTruncated || lr.NextContinuationToken == "" {
+			return res, nil
+		}
+		contToken = lr.NextContinuationToken
+	}
+}
+
+// reads the object content and checks it against its meta
+func (s3bs *S3BlobStorage) readVerified(objId string) ([]byte, *BlobMeta, error) {
+	resp, err := s3bs.do("GET", s3bs.key(objId), nil, nil, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, nil, common.NewError(common.ERR_NOT_FOUND, "Could not find BLOB by id="+objId)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, nil, s3bs.respError(resp)
+	}
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	bMeta := s3bs.toBlobMeta(objId, resp)
+	err = checkBlob(bMeta, data)
+	if err != nil {
+		return nil, bMeta, err
+	}
+	bMeta.Size = int64(len(data))
+	return data, bMeta, nil
+}
+
+// sends signed request to the bucket. key could be empty for bucket-level
+// operations.
+func (s3bs *S3BlobStorage) do(method, key string, q url.Values, hdrs map[string]string, body []byte) (*http.Response, error) {
+	u := *s3bs.endpoint
+	u.Path = u.Path + "/" + s3bs.cfg.Bucket
+	if key != "" {
+		u.Path += "/" + key
+	}
+	u.RawPath = s3UriEncode(u.Path, false)
+	if q != nil {
+		u.RawQuery = s3CanonicalQuery(q)
+	}
+
+	var br io.Reader
+	payloadHash := cS3EmptyHash
+	if body != nil {
+		br = bytes.NewReader(body)
+		payloadHash = s3HexSha256(body)
+	}
+
+	req, err := http.NewRequest(method, u.String(), br)
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range hdrs {
+		req.Header.Set(k, v)
+	}
+	s3bs.signer.sign(req, payloadHash, time.Now())
+	return s3bs.client.Do(req)
+}
+
+func (s3bs *S3BlobStorage) respError(resp *http.Response) error {
+	var s3e s3_error
+	data, _ := ioutil.ReadAll(resp.Body)
+	if len(data) > 0 && xml.Unmarshal(data, &s3e) == nil {
+		return &s3e
+	}
+	return &s3_error{Code: strconv.Itoa(resp.StatusCode), Message: resp.Status}
+}
+
+func (s3bs *S3BlobStorage) toBlobMeta(id string, resp *http.Response) *BlobMeta {
+	bm := newBlobMeta()
+	bm.Id = id
+	bm.Size = resp.ContentLength
+	bm.KeyId = resp.Header.Get(cS3MetaKeyId)
+	bm.Checksum = resp.Header.Get(cS3MetaChecksum)
+	if ts, err := strconv.ParseInt(resp.Header.Get(cS3MetaTimestamp), 10, 64); err == nil {
+		bm.Timestamp = common.Timestamp(ts).ToTime()
+	} else if lm, err := http.ParseTime(resp.Header.Get("Last-Modified")); err == nil {
+		bm.Timestamp = lm
+	}
+	return bm
+}
@@ -0,0 +1,145 @@
+package storage
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	cS3SignAlgorithm = "AWS4-HMAC-SHA256"
+	cS3Service       = "s3"
+	cS3AmzDateFrmt   = "20060102T150405Z"
+	cS3EmptyHash     = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+)
+
+// AWS Signature Version 4 signer for S3 requests. It is used by S3BlobStorage
+// and works for any S3-compatible object store (AWS, MinIO, Ceph RGW etc.)
+type s3_signer struct {
+	accessKey string
+	secretKey string
+	region    string
+}
+
+func newS3Signer(accessKey, secretKey, region string) *s3_signer {
+	return &s3_signer{accessKey: accessKey, secretKey: secretKey, region: region}
+}
+
+// Signs the request. payloadHash is hex encoded SHA256 of the request body.
+// All headers that are set on the request at the moment of the call are signed,
+// so they must not be changed after that.
+func (s *s3_signer) sign(req *http.Request, payloadHash string, now time.Time) {
+	amzDate := now.UTC().Format(cS3AmzDateFrmt)
+	date := amzDate[:8]
+	req.Header.Set("x-amz-date", amzDate)
+	req.Header.Set("x-amz-content-sha256", payloadHash)
+
+	signedHdrs, canonHdrs := s3CanonicalHeaders(req)
+	canonReq := strings.Join([]string{
+		req.Method,
+		s3UriEncode(req.URL.Path, false),
+		s3CanonicalQuery(req.URL.Query()),
+		canonHdrs,
+		signedHdrs,
+		payloadHash,
+	}, "\n")
+
+	scope := date + "/" + s.region + "/" + cS3Service + "/aws4_request"
+	strToSign := cS3SignAlgorithm + "\n" + amzDate + "\n" + scope + "\n" + s3HexSha256([]byte(canonReq))
+
+	key := s3HmacSha256([]byte("AWS4"+s.secretKey), date)
+	key = s3HmacSha256(key, s.region)
+	key = s3HmacSha256(key, cS3Service)
+	key = s3HmacSha256(key, "aws4_request")
+	signature := hex.EncodeToString(s3HmacSha256(key, strToSign))
+
+	req.Header.Set("Authorization", cS3SignAlgorithm+" Credential="+s.accessKey+"/"+scope+
+		", SignedHeaders="+signedHdrs+", Signature="+signature)
+}
+
+// Returns list of signed headers and the canonical headers block (including
+// the trailing empty line)
+func s3CanonicalHeaders(req *http.Request) (string, string) {
+	host := req.Host
+	if host == "" {
+		host = req.URL.Host
+	}
+	hdrs := map[string]string{"host": host}
+	for k, v := range req.Header {
+		lk := strings.ToLower(k)
+		if lk == "authorization" || lk == "user-agent" {
+			continue
+		}
+		vals := make([]string, len(v))
+		for i, vv := range v {
+			vals[i] = strings.Join(strings.Fields(vv), " ")
+		}
+		hdrs[lk] = strings.Join(vals, ",")
+	}
+
+	names := make([]string, 0, len(hdrs))
+	for k := range hdrs {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+
+	var canon string
+	for _, n := range names {
+		canon += n + ":" + hdrs[n] + "\n"
+	}
+	return strings.Join(names, ";"), canon
+}
+
+func s3CanonicalQuery(q url.Values) string {
+	keys := make([]string, 0, len(q))
+	for k := range q {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	parts := make([]string, 0, len(keys))
+	for _, k := range keys {
+		vals := q[k]
+		sort.Strings(vals)
+		for _, v := range vals {
+			parts = append(parts, s3UriEncode(k, true)+"="+s3UriEncode(v, true))
+		}
+	}
+	return strings.Join(parts, "&")
+}
+
+// URI encoding as S3 expects it: everything except unreserved characters
+// is percent-encoded. The slash is kept as is for the object path.
+func s3UriEncode(s string, encodeSlash bool) string {
+	const hexChars = "0123456789ABCDEF"
+	var res []byte
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch {
+		case (c >= 'A' && c <= 'Z') || (c >= 'a' && c <= 'z') || (c >= '0' && c <= '9'),
+			c == '-', c == '_', c == '.', c == '~':
+			res = append(res, c)
+		case c == '/' && !encodeSlash:
+			res = append(res, c)
+		default:
+			res = append(res, '%', hexChars[c>>4], hexChars[c&15])
+		}
+	}
+	return string(res)
+}
+
+func s3HexSha256(data []byte) string {
+	h := sha256.Sum256(data)
+	return hex.EncodeToString(h[:])
+}
+
+func s3HmacSha256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
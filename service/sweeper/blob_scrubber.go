@@ -0,0 +1,160 @@
+package sweeper
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/jrivets/gorivets"
+	"github.com/jrivets/log4g"
+	"github.com/pixty/console/common"
+	"github.com/pixty/console/service/storage"
+	"golang.org/x/net/context"
+)
+
+const cScrubMaxIds = 1000
+
+type (
+	BlobScrubber interface {
+		// Returns the last completed scrub report, nil if no one was made yet
+		GetLastReport() *ScrubReport
+	}
+
+	ScrubReport struct {
+		StartedAt  time.Time
+		FinishedAt time.Time
+		Scanned    int
+		Missing    int
+		Corrupted  int
+		// ids of missing and corrupted objects, no more than cScrubMaxIds
+		MissingIds   []string
+		CorruptedIds []string
+		// the last error, which is not related to an object integrity
+		Err error
+	}
+
+	// blob_scrubber walks through the blob storage and checks integrity of
+	// every object. It only reports problems, doesn't fix them.
+	blob_scrubber struct {
+		CConfig     *common.ConsoleConfig `inject:""`
+		MainCtx     context.Context       `inject:"mainCtx"`
+		BlobStorage storage.BlobStorage   `inject:""`
+		lock        sync.Mutex
+		lastReport  *ScrubReport
+		logger      log4g.Logger
+	}
+)
+
+func NewBlobScrubber() BlobScrubber {
+	return new(blob_scrubber)
+}
+
+// ========================== PostConstructor ================================
+func (bs *blob_scrubber) DiPostConstruct() {
+	bs.logger = log4g.GetLogger("pixty.BlobScrubber")
+	bs.logger.Info("Post construct.")
+
+	verifier, ok := bs.BlobStorage.(storage.BlobVerifier)
+	lister, ok2 := bs.BlobStorage.(storage.BlobLister)
+	if !ok || !ok2 {
+		bs.logger.Warn("The blob storage doesn't support verification, no scrubbing.")
+		return
+	}
+
+	go func() {
+		bs.logger.Info("Entering job routine.")
+		// the first pass runs shortly after the start, the pause between
+		// the passes is long
+		to := cSweepStartDelay
+		for {
+			select {
+			case <-bs.MainCtx.Done():
+				bs.logger.Info("Leaving job routine.")
+				return
+			// kick the scrubbing routine
+			case <-time.After(to):
+				err := gorivets.CheckPanic(func() { bs.scrub(lister, verifier) })
+				if err != nil {
+					bs.logger.Error("Got the panic in scrubbing blobs: ", err)
+				}
+			}
+			to = time.Second * time.Duration(bs.CConfig.SweepScrubToSec)
+		}
+	}()
+}
+
+// ============================= BlobScrubber ================================
+func (bs *blob_scrubber) GetLastReport() *ScrubReport {
+	bs.lock.Lock()
+	defer bs.lock.Unlock()
+	return bs.lastReport
+}
+
+func (bs *blob_scrubber) scrub(lister storage.BlobLister, verifier storage.BlobVerifier) {
+	rep := new(ScrubReport)
+	rep.StartedAt = time.Now()
+	defer func() {
+		rep.FinishedAt = time.Now()
+		bs.lock.Lock()
+		bs.lastReport = rep
+		bs.lock.Unlock()
+		bs.logger.Info("Done with scrubbing. Report is \"", rep, "\"")
+	}()
+
+	ids, err := lister.ListIds("")
+	if err != nil {
+		bs.logger.Error("Could not list objects, err=", err)
+		rep.Err = err
+		return
+	}
+
+	for _, id := range ids {
+		select {
+		case <-bs.MainCtx.Done():
+			bs.logger.Info("Interrupted.")
+			return
+		default:
+		}
+
+		rep.Scanned++
+		err := verifier.Verify(id)
+		switch {
+		case err == nil:
+		case common.CheckError(err, common.ERR_NOT_FOUND):
+			// could be deleted while scrubbing
+			if bs.BlobStorage.ReadMeta(id) != nil {
+				bs.logger.Warn("Object is missing: ", err)
+				rep.onMissing(id)
+			}
+		case common.CheckError(err, common.ERR_INVALID_VAL):
+			bs.logger.Warn("Object is corrupted: ", err)
+			rep.onCorrupted(id)
+		default:
+			bs.logger.Error("Could not verify object id=", id, ", err=", err)
+			rep.Err = err
+		}
+
+		if rep.Scanned%bs.CConfig.SweepImagesPackSize == 0 && bs.CConfig.SweepImagesPackSizePauseMs > 0 {
+			time.Sleep(time.Millisecond * time.Duration(bs.CConfig.SweepImagesPackSizePauseMs))
+		}
+	}
+}
+
+func (sr *ScrubReport) String() string {
+	return fmt.Sprint("scanned=", sr.Scanned, ", missing=", sr.Missing, ", corrupted=", sr.Corrupted, ", for ",
+		sr.FinishedAt.Sub(sr.StartedAt), ", err=", sr.Err)
+}
+
+func (sr *ScrubReport) onMissing(id string) {
+	sr.Missing++
+	if len(sr.MissingIds) < cScrubMaxIds {
+		sr.MissingIds = append(sr.MissingIds, id)
+	}
+}
+
+func (sr *ScrubReport) onCorrupted(id string) {
+	sr.Corrupted++
+	if len(sr.CorruptedIds) < cScrubMaxIds {
+		sr.CorruptedIds = append(sr.CorruptedIds, id)
+	}
+}
@@ -0,0 +1,164 @@
+package sweeper
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/jrivets/gorivets"
+	"github.com/jrivets/log4g"
+	"github.com/pixty/console/common"
+	"github.com/pixty/console/model"
+	"github.com/pixty/console/service/image"
+	"golang.org/x/net/context"
+)
+
+type (
+	CropsMigrator interface {
+		// Returns the last completed migration report, nil if no one was made yet
+		GetLastReport() *CropsMigrationReport
+		// Starts the migration in background, returns ERR_LIMIT_VIOLATION
+		// if it is already running
+		Migrate() error
+	}
+
+	CropsMigrationReport struct {
+		StartedAt   time.Time
+		FinishedAt  time.Time
+		PicsScanned int
+		// face images deleted from the storage
+		ImagesDropped int
+		// the last error happened
+		Err error
+	}
+
+	// crops_migrator deletes the face images stored before ImgsCropOnDemand
+	// was turned on. The face images are cut from their frames when they
+	// are requested. Images used by persons or profiles are kept, they
+	// should outlive the frames.
+	crops_migrator struct {
+		CConfig    *common.ConsoleConfig `inject:""`
+		Persister  model.Persister       `inject:"persister"`
+		MainCtx    context.Context       `inject:"mainCtx"`
+		ImgService *image.ImageService   `inject:""`
+		lock       sync.Mutex
+		running    bool
+		lastReport *CropsMigrationReport
+		logger     log4g.Logger
+	}
+)
+
+func NewCropsMigrator() CropsMigrator {
+	return new(crops_migrator)
+}
+
+// ========================== PostConstructor ================================
+func (cm *crops_migrator) DiPostConstruct() {
+	cm.logger = log4g.GetLogger("pixty.CropsMigrator")
+	cm.logger.Info("Post construct.")
+}
+
+// ============================= CropsMigrator ===============================
+func (cm *crops_migrator) GetLastReport() *CropsMigrationReport {
+	cm.lock.Lock()
+	defer cm.lock.Unlock()
+	return cm.lastReport
+}
+
+func (cm *crops_migrator) Migrate() error {
+	if !cm.CConfig.ImgsCropOnDemand {
+		return common.NewError(common.ERR_INVALID_VAL, "Face images are stored, ImgsCropOnDemand is off.")
+	}
+
+	cm.lock.Lock()
+	defer cm.lock.Unlock()
+	if cm.running {
+		return common.NewError(common.ERR_LIMIT_VIOLATION, "The face images migration is already running.")
+	}
+	cm.running = true
+	go cm.run()
+	return nil
+}
+
+func (cm *crops_migrator) run() {
+	rep := &CropsMigrationReport{StartedAt: time.Now()}
+	err := gorivets.CheckPanic(func() { cm.migrate(rep) })
+	if err != nil {
+		cm.logger.Error("Got the panic in face images migration: ", err)
+		rep.Err = fmt.Errorf("%v", err)
+	}
+
+	rep.FinishedAt = time.Now()
+	cm.lock.Lock()
+	cm.lastReport = rep
+	cm.running = false
+	cm.lock.Unlock()
+	cm.logger.Info("Done with face images migration. Report is \"", rep, "\"")
+}
+
+func (cm *crops_migrator) migrate(rep *CropsMigrationReport) {
+	pxt, err := cm.Persister.GetPartitionTx("FAKE")
+	if err != nil {
+		cm.logger.Error("Could not get PartitionTx object err=", err)
+		rep.Err = err
+		return
+	}
+
+	afterId := ""
+	for {
+		select {
+		case <-cm.MainCtx.Done():
+			cm.logger.Info("Interrupted.")
+			return
+		case <-time.After(time.Millisecond * time.Duration(cm.CConfig.SweepImagesPackSizePauseMs)):
+		}
+
+		pics, err := pxt.FindPics(afterId, cm.CConfig.SweepImagesPackSize)
+		if err != nil {
+			cm.logger.Error("Could not read pictures after id=", afterId, ", err=", err)
+			rep.Err = err
+			return
+		}
+		if len(pics) == 0 {
+			return
+		}
+		afterId = pics[len(pics)-1].Id
+
+		for _, p := range pics {
+			rep.PicsScanned++
+			// the face refers to its image, others are persons and profiles
+			if p.Refs != 1 {
+				continue
+			}
+			// the pack could be read a while ago, a person or profile could
+			// refer to the image since then
+			if !cm.isFaceOnly(pxt, p.Id) {
+				continue
+			}
+			dropped, err := cm.ImgService.DropFaceImage(p.Id)
+			if err != nil {
+				cm.logger.Error("Could not delete face image ", p.Id, ", err=", err)
+				rep.Err = err
+				continue
+			}
+			if dropped {
+				rep.ImagesDropped++
+			}
+		}
+	}
+}
+
+// re-reads the picture and returns whether it is referred by the face only
+func (cm *crops_migrator) isFaceOnly(pxt model.PartTx, picId string) bool {
+	pics, err := pxt.FindPicsByIds([]string{picId})
+	if err != nil {
+		cm.logger.Warn("Could not re-read picture ", picId, ", it is kept, err=", err)
+		return false
+	}
+	return len(pics) == 1 && pics[0].Refs == 1
+}
+
+func (cmr *CropsMigrationReport) String() string {
+	return fmt.Sprint("picsScanned=", cmr.PicsScanned, ", imagesDropped=", cmr.ImagesDropped, ", for ",
+		cmr.FinishedAt.Sub(cmr.StartedAt), ", err=", cmr.Err)
+}
@@ -2,6 +2,7 @@ package sweeper
 
 import (
 	"fmt"
+	"sort"
 	"time"
 
 	"github.com/jrivets/gorivets"
@@ -126,14 +127,7 @@ func (fs *faces_sweeper) sweepFacesTx(ptx model.PartTx) bool {
 	// Build list of faces to be deleted
 	delIds := make([]int64, 0, 100)
 	for _, fcs := range p2fs {
-		fcsCnt := len(fcs)
-		toDel := fcsCnt - common.MAX_FACES_PER_PERSON
-		step := (float32(fcsCnt) - 2.0) / float32(toDel)
-		startIdx := fcsCnt - 2
-		for i := 0; i < toDel; i++ {
-			idx := int(float32(startIdx) - float32(i)*step)
-			delIds = append(delIds, fcs[idx].Id)
-		}
+		delIds = append(delIds, facesToDelete(fcs, len(fcs)-common.MAX_FACES_PER_PERSON)...)
 		if len(delIds) > 100 {
 			err = ptx.DeleteFaces(delIds)
 			if err != nil {
@@ -158,6 +152,47 @@ func (fs *faces_sweeper) sweepFacesTx(ptx model.PartTx) bool {
 	return true
 }
 
+// Chooses toDel faces to be deleted from the person faces ordered by the
+// capture time. The latest and the oldest faces are kept, others with the
+// worst quality are deleted. Faces stored before the quality was introduced
+// don't have it, so they are deleted first, or evenly if all of them are such.
+func facesToDelete(fcs []*model.Face, toDel int) []int64 {
+	fcsCnt := len(fcs)
+	if toDel <= 0 || fcsCnt < 3 {
+		return nil
+	}
+	if toDel > fcsCnt-2 {
+		toDel = fcsCnt - 2
+	}
+	res := make([]int64, 0, toDel)
+
+	cands := make([]*model.Face, fcsCnt-2)
+	copy(cands, fcs[1:fcsCnt-1])
+	known := false
+	for _, f := range cands {
+		if f.Quality > 0 {
+			known = true
+			break
+		}
+	}
+
+	if !known {
+		step := (float32(fcsCnt) - 2.0) / float32(toDel)
+		startIdx := fcsCnt - 2
+		for i := 0; i < toDel; i++ {
+			idx := int(float32(startIdx) - float32(i)*step)
+			res = append(res, fcs[idx].Id)
+		}
+		return res
+	}
+
+	sort.SliceStable(cands, func(i, j int) bool { return cands[i].Quality < cands[j].Quality })
+	for _, f := range cands[:toDel] {
+		res = append(res, f.Id)
+	}
+	return res
+}
+
 func (s *faces_swpr_stats) start() {
 	s.startedAt = time.Now()
 	s.persns = 0
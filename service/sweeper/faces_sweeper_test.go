@@ -0,0 +1,49 @@
+package sweeper
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/pixty/console/model"
+)
+
+// faces with ids 1..len(qs) ordered by the capture time
+func newTestFaces(qs ...float32) []*model.Face {
+	res := make([]*model.Face, len(qs))
+	for i, q := range qs {
+		res[i] = &model.Face{Id: int64(i + 1), CapturedAt: uint64(i), Quality: q}
+	}
+	return res
+}
+
+func TestFacesToDelete(t *testing.T) {
+	tests := []struct {
+		name  string
+		fcs   []*model.Face
+		toDel int
+		res   []int64
+	}{
+		{"nothing to delete", newTestFaces(0.1, 0.2, 0.3), 0, nil},
+		{"too few faces", newTestFaces(0.1, 0.2), 1, nil},
+		{"the worst", newTestFaces(0.9, 0.1, 0.5, 0.3, 0.9), 1, []int64{2}},
+		{"first and last are kept", newTestFaces(0.1, 0.5, 0.3, 0.9, 0.1), 10, []int64{3, 2, 4}},
+		{"unknown quality first", newTestFaces(0.5, 0.8, 0.2, 0, 0.6, 0.5), 2, []int64{4, 3}},
+		{"same quality in order", newTestFaces(0.5, 0.3, 0.3, 0.3, 0.5), 2, []int64{2, 3}},
+		{"unknown quality evenly", newTestFaces(0, 0, 0, 0, 0, 0, 0), 2, []int64{6, 3}},
+		{"unknown quality all", newTestFaces(0, 0, 0, 0, 0, 0, 0), 5, []int64{6, 5, 4, 3, 2}},
+	}
+	for _, tst := range tests {
+		res := facesToDelete(tst.fcs, tst.toDel)
+		if len(res) == 0 && len(tst.res) == 0 {
+			continue
+		}
+		if !reflect.DeepEqual(res, tst.res) {
+			t.Fatal("Test \"", tst.name, "\": expecting ", tst.res, ", but got ", res)
+		}
+		for i, f := range tst.fcs {
+			if f.Id != int64(i+1) {
+				t.Fatal("Test \"", tst.name, "\": the faces order must not be changed")
+			}
+		}
+	}
+}
@@ -97,8 +97,11 @@ func (is *images_sweeper) sweepImagesTx(pxt model.PartTx, limit int) bool {
 	}
 
 	for i, p := range pics {
-		// TODO. Are we sure about making IDs the way?
-		err = is.ImgService.DeleteImageByFile(p.Id)
+		err = is.keepFaceImages(pxt, p.Id)
+		if err == nil {
+			// TODO. Are we sure about making IDs the way?
+			err = is.ImgService.DeleteImageByFile(p.Id)
+		}
 		if err != nil {
 			is.stats.onError(err)
 			is.logger.Error("Could not delete picture by id=", p.Id, ", err=", err)
@@ -119,6 +122,35 @@ func (is *images_sweeper) sweepImagesTx(pxt model.PartTx, limit int) bool {
 	return len(pics) > 0
 }
 
+// Face images of the frame, which are still in use by persons or profiles,
+// could be not stored, but cut from the frame on demand. They are stored
+// before the frame is deleted.
+func (is *images_sweeper) keepFaceImages(pxt model.PartTx, frameFn string) error {
+	pfx, ok := is.ImgService.GetFaceFilesPrefix(frameFn)
+	if !ok {
+		return nil
+	}
+	pics, err := pxt.FindPicsByPrefix(pfx)
+	if err != nil {
+		is.logger.Error("Could not read face pictures of the frame ", frameFn, ", err=", err)
+		return err
+	}
+	for _, p := range pics {
+		if p.Refs <= 0 {
+			continue
+		}
+		err = is.ImgService.KeepFaceImage(p.Id)
+		if common.CheckError(err, common.ERR_NOT_FOUND) {
+			is.logger.Warn("Could not keep face image ", p.Id, ", err=", err)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
 func (iss *images_swpr_stats) String() string {
 	return fmt.Sprint("transactions=", iss.dbTrans, ", picsDeleted=", iss.picsDeleted, ", for ", time.Now().Sub(iss.startedAt), ", err=", iss.err)
 }
@@ -0,0 +1,138 @@
+package sweeper
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/jrivets/gorivets"
+	"github.com/jrivets/log4g"
+	"github.com/pixty/console/common"
+	"github.com/pixty/console/service/storage"
+	"golang.org/x/net/context"
+)
+
+// the pause before the first pass, gives the console time to start
+const cSweepStartDelay = time.Minute
+
+type (
+	KeysRotator interface {
+	}
+
+	// keys_rotator re-encrypts by the active key all objects which were
+	// stored before the key rotation (or before the encryption was turned on).
+	// Keys are read once on start, the first pass runs shortly after the
+	// start, the next ones pick up what the previous passes could not rekey.
+	keys_rotator struct {
+		CConfig     *common.ConsoleConfig `inject:""`
+		MainCtx     context.Context       `inject:"mainCtx"`
+		BlobStorage storage.BlobStorage   `inject:""`
+		stats       *keys_rotator_stats
+		logger      log4g.Logger
+	}
+
+	keys_rotator_stats struct {
+		startedAt time.Time
+		scanned   int
+		rekeyed   int
+		err       error
+	}
+)
+
+func NewKeysRotator() KeysRotator {
+	return new(keys_rotator)
+}
+
+// ========================== PostConstructor ================================
+func (kr *keys_rotator) DiPostConstruct() {
+	kr.logger = log4g.GetLogger("pixty.KeysRotator")
+	kr.logger.Info("Post construct.")
+	kr.stats = new(keys_rotator_stats)
+
+	enc, ok := kr.BlobStorage.(storage.BlobEncryptor)
+	if !ok || enc.ActiveKeyId() == "" {
+		kr.logger.Info("Encryption is off, nothing to rotate.")
+		return
+	}
+	lister, ok := kr.BlobStorage.(storage.BlobLister)
+	if !ok {
+		kr.logger.Warn("The blob storage cannot list objects, keys rotation is not possible.")
+		return
+	}
+
+	go func() {
+		kr.logger.Info("Entering job routine.")
+		to := cSweepStartDelay
+		for {
+			select {
+			case <-kr.MainCtx.Done():
+				kr.logger.Info("Leaving job routine.")
+				return
+			case <-time.After(to):
+				err := gorivets.CheckPanic(func() { kr.rotateKeys(lister, enc) })
+				if err != nil {
+					kr.logger.Error("Got the panic in rotating keys: ", err)
+				}
+			}
+			to = time.Second * time.Duration(kr.CConfig.SweepKeysToSec)
+		}
+	}()
+}
+
+func (kr *keys_rotator) rotateKeys(lister storage.BlobLister, enc storage.BlobEncryptor) {
+	ids, err := lister.ListIds("")
+	if err != nil {
+		kr.logger.Error("Could not list objects, err=", err)
+		return
+	}
+
+	activeKeyId := enc.ActiveKeyId()
+	kr.logger.Info("Checking ", len(ids), " objects for keys other than ", activeKeyId)
+	kr.stats.start()
+	for _, id := range ids {
+		select {
+		case <-kr.MainCtx.Done():
+			kr.logger.Info("Interrupted. Stats is \"", kr.stats, "\"")
+			return
+		default:
+		}
+
+		kr.stats.scanned++
+		bm := kr.BlobStorage.ReadMeta(id)
+		if bm == nil || bm.KeyId == activeKeyId {
+			continue
+		}
+		if kr.rekey(enc, id) && kr.stats.rekeyed%kr.CConfig.SweepImagesPackSize == 0 && kr.CConfig.SweepImagesPackSizePauseMs > 0 {
+			time.Sleep(time.Millisecond * time.Duration(kr.CConfig.SweepImagesPackSizePauseMs))
+		}
+	}
+	kr.logger.Info("Done with rotating keys. Stats is \"", kr.stats, "\"")
+}
+
+// re-encrypts the object by the active key in the storage
+func (kr *keys_rotator) rekey(enc storage.BlobEncryptor, id string) bool {
+	ok, err := enc.Rekey(id)
+	if err != nil {
+		kr.logger.Error("Could not re-encrypt object id=", id, ", err=", err)
+		kr.stats.onError(err)
+		return false
+	}
+	if ok {
+		kr.stats.rekeyed++
+	}
+	return ok
+}
+
+func (krs *keys_rotator_stats) String() string {
+	return fmt.Sprint("scanned=", krs.scanned, ", rekeyed=", krs.rekeyed, ", for ", time.Now().Sub(krs.startedAt), ", err=", krs.err)
+}
+
+func (krs *keys_rotator_stats) start() {
+	krs.startedAt = time.Now()
+	krs.scanned = 0
+	krs.rekeyed = 0
+	krs.err = nil
+}
+
+func (krs *keys_rotator_stats) onError(err error) {
+	krs.err = err
+}
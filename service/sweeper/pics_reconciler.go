@@ -0,0 +1,360 @@
+package sweeper
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jrivets/gorivets"
+	"github.com/jrivets/log4g"
+	"github.com/pixty/console/common"
+	"github.com/pixty/console/model"
+	"github.com/pixty/console/service/image"
+	"github.com/pixty/console/service/scene"
+	"github.com/pixty/console/service/storage"
+	"golang.org/x/net/context"
+)
+
+const (
+	// images younger than the value could be not in the picture table yet
+	cRcnclBlobGrace = 10 * time.Minute
+	cRcnclMaxIds    = 1000
+)
+
+type (
+	PicsReconciler interface {
+		// Returns the last completed reconciliation report, nil if no one was made yet
+		GetLastReport() *ReconcileReport
+		// Starts the reconciliation in background, returns ERR_LIMIT_VIOLATION
+		// if it is already running
+		Reconcile(dryRun bool) error
+	}
+
+	ReconcileReport struct {
+		StartedAt  time.Time
+		FinishedAt time.Time
+		DryRun     bool
+		// number of image objects and picture records checked
+		BlobsScanned int
+		PicsScanned  int
+		// images which don't have picture records
+		OrphanImages int
+		// picture records which don't have images
+		MissingImages int
+		// what was fixed in repair mode
+		ImagesDeleted int
+		// file names of the orphan and missing images, no more than cRcnclMaxIds
+		OrphanImageIds  []string
+		MissingImageIds []string
+		// the last error happened
+		Err error
+	}
+
+	// pics_reconciler looks for the drift between the picture table and the
+	// blob storage. Images are stored first and then the picture records are
+	// created by the DB triggers, so a crash in between or a failed delete
+	// leaves images without records or records without images. In
+	// repair mode the orphan images are deleted from the storage together
+	// with their derived renditions. The
+	// records without images are only reported, they are still referenced
+	// by faces or persons (the LFS storage evicts such images normally).
+	pics_reconciler struct {
+		CConfig     *common.ConsoleConfig `inject:""`
+		Persister   model.Persister       `inject:"persister"`
+		MainCtx     context.Context       `inject:"mainCtx"`
+		ImgService  *image.ImageService   `inject:""`
+		BlobStorage storage.BlobStorage   `inject:""`
+		ScnService  *scene.SceneProcessor `inject:"scnProcessor"`
+		lister      storage.BlobLister
+		lock        sync.Mutex
+		running     bool
+		lastReport  *ReconcileReport
+		logger      log4g.Logger
+	}
+)
+
+func NewPicsReconciler() PicsReconciler {
+	return new(pics_reconciler)
+}
+
+// ========================== PostConstructor ================================
+func (pr *pics_reconciler) DiPostConstruct() {
+	pr.logger = log4g.GetLogger("pixty.PicsReconciler")
+	pr.logger.Info("Post construct.")
+
+	lister, ok := pr.BlobStorage.(storage.BlobLister)
+	if !ok {
+		pr.logger.Warn("The blob storage cannot list objects, no reconciliation.")
+		return
+	}
+	pr.lister = lister
+
+	mode := pr.CConfig.ReconcileMode
+	if mode != common.RECONCILE_DRY_RUN && mode != common.RECONCILE_REPAIR {
+		pr.logger.Info("Periodic reconciliation is off, mode=", mode)
+		return
+	}
+
+	go func() {
+		pr.logger.Info("Entering job routine, mode=", mode)
+		for {
+			select {
+			case <-pr.MainCtx.Done():
+				pr.logger.Info("Leaving job routine.")
+				return
+			// kick the reconciliation routine
+			case <-time.After(time.Second * time.Duration(pr.CConfig.SweepReconcileToSec)):
+				if !pr.start() {
+					continue
+				}
+				pr.run(mode == common.RECONCILE_DRY_RUN)
+			}
+		}
+	}()
+}
+
+// ============================ PicsReconciler ===============================
+func (pr *pics_reconciler) GetLastReport() *ReconcileReport {
+	pr.lock.Lock()
+	defer pr.lock.Unlock()
+	return pr.lastReport
+}
+
+func (pr *pics_reconciler) Reconcile(dryRun bool) error {
+	if pr.lister == nil {
+		return common.NewError(common.ERR_NOT_FOUND, "The blob storage doesn't support reconciliation.")
+	}
+	if !pr.start() {
+		return common.NewError(common.ERR_LIMIT_VIOLATION, "The reconciliation is already running.")
+	}
+	go pr.run(dryRun)
+	return nil
+}
+
+func (pr *pics_reconciler) start() bool {
+	pr.lock.Lock()
+	defer pr.lock.Unlock()
+	if pr.running {
+		return false
+	}
+	pr.running = true
+	return true
+}
+
+func (pr *pics_reconciler) run(dryRun bool) {
+	rep := &ReconcileReport{StartedAt: time.Now(), DryRun: dryRun}
+	err := gorivets.CheckPanic(func() { pr.reconcile(rep) })
+	if err != nil {
+		pr.logger.Error("Got the panic in reconciliation: ", err)
+		rep.onError(fmt.Errorf("%v", err))
+	}
+
+	rep.FinishedAt = time.Now()
+	pr.lock.Lock()
+	pr.lastReport = rep
+	pr.running = false
+	pr.lock.Unlock()
+	pr.logger.Info("Done with reconciliation. Report is \"", rep, "\"")
+}
+
+func (pr *pics_reconciler) reconcile(rep *ReconcileReport) {
+	pxt, err := pr.Persister.GetPartitionTx("FAKE")
+	if err != nil {
+		pr.logger.Error("Could not get PartitionTx object err=", err)
+		rep.onError(err)
+		return
+	}
+
+	// file name -> store ids of the image sizes and renditions
+	images, err := pr.listImages(rep)
+	if err != nil {
+		return
+	}
+	// images of the spooled scenes don't have picture records until the
+	// scenes are replayed
+	if !rep.DryRun && pr.ScnService.HasSpooledScenes() {
+		pr.logger.Warn("There are spooled scenes, skipping orphan images check.")
+	} else if !pr.checkOrphanImages(pxt, images, rep) {
+		return
+	}
+	pr.checkMissingImages(pxt, images, rep)
+}
+
+// lists the permanent images and their renditions, temporary images don't
+// have picture records. The renditions of the image, which is gone, are
+// listed under its file name, so they are reconciled as well.
+func (pr *pics_reconciler) listImages(rep *ReconcileReport) (map[string][]string, error) {
+	res := make(map[string][]string)
+	derived := 0
+	for _, pfx := range []string{image.PFX_PERM + "_", image.PFX_DERIVED + image.PFX_PERM + "_"} {
+		ids, err := pr.lister.ListIds(pfx)
+		if err != nil {
+			pr.logger.Error("Could not list images with prefix ", pfx, ", err=", err)
+			rep.onError(err)
+			return nil, err
+		}
+
+		for _, id := range ids {
+			fn, drvd, ok := pr.ImgService.GetFileNameByStoreId(id)
+			if !ok {
+				pr.logger.Debug("Skipping not image object id=", id)
+				continue
+			}
+			rep.BlobsScanned++
+			if drvd {
+				derived++
+			}
+			res[fn] = append(res[fn], id)
+		}
+	}
+	pr.logger.Info("Found ", len(res), " images in ", rep.BlobsScanned, " objects, ", derived, " of them are renditions")
+	return res, nil
+}
+
+// looks for images, which don't have picture records. Returns false if the
+// reconciliation should be stopped.
+func (pr *pics_reconciler) checkOrphanImages(pxt model.PartTx, images map[string][]string, rep *ReconcileReport) bool {
+	fns := make([]string, 0, pr.CConfig.SweepImagesPackSize)
+	for fn := range images {
+		fns = append(fns, fn)
+		if len(fns) < pr.CConfig.SweepImagesPackSize {
+			continue
+		}
+		if !pr.checkOrphanImagesPack(pxt, fns, images, rep) {
+			return false
+		}
+		fns = fns[:0]
+	}
+	return pr.checkOrphanImagesPack(pxt, fns, images, rep)
+}
+
+func (pr *pics_reconciler) checkOrphanImagesPack(pxt model.PartTx, fns []string, images map[string][]string, rep *ReconcileReport) bool {
+	if len(fns) == 0 {
+		return true
+	}
+	if !pr.pause() {
+		return false
+	}
+
+	pics, err := pxt.FindPicsByIds(fns)
+	if err != nil {
+		pr.logger.Error("Could not read ", len(fns), " pictures, err=", err)
+		rep.onError(err)
+		return false
+	}
+	known := make(map[string]bool, len(pics))
+	for _, p := range pics {
+		known[p.Id] = true
+	}
+
+	for _, fn := range fns {
+		if known[fn] || pr.isYoung(images[fn]) {
+			continue
+		}
+		rep.onOrphanImage(fn)
+		if rep.DryRun {
+			pr.logger.Warn("Image ", fn, " doesn't have picture record")
+			continue
+		}
+		pr.logger.Warn("Image ", fn, " doesn't have picture record, deleting it.")
+		err = pr.ImgService.DeleteImageByFile(fn)
+		if err != nil {
+			pr.logger.Error("Could not delete image ", fn, ", err=", err)
+			rep.onError(err)
+			continue
+		}
+		rep.ImagesDeleted++
+	}
+	return true
+}
+
+// looks for the picture records, which don't have images
+func (pr *pics_reconciler) checkMissingImages(pxt model.PartTx, images map[string][]string, rep *ReconcileReport) {
+	afterId := ""
+	for pr.pause() {
+		pics, err := pxt.FindPics(afterId, pr.CConfig.SweepImagesPackSize)
+		if err != nil {
+			pr.logger.Error("Could not read pictures after id=", afterId, ", err=", err)
+			rep.onError(err)
+			return
+		}
+		if len(pics) == 0 {
+			return
+		}
+		afterId = pics[len(pics)-1].Id
+
+		for _, p := range pics {
+			rep.PicsScanned++
+			// zero refs records are removed by the images sweeper
+			if p.Refs <= 0 || hasStoredSize(images[p.Id]) {
+				continue
+			}
+			// the image could be stored after the listing
+			ok, err := pr.ImgService.HasImage(p.Id)
+			if err != nil {
+				pr.logger.Debug("Skipping picture with unexpected id=", p.Id, ", err=", err)
+				continue
+			}
+			if !ok {
+				pr.logger.Warn("Picture ", p.Id, " (refs=", p.Refs, ") doesn't have image")
+				rep.onMissingImage(p.Id)
+			}
+		}
+	}
+}
+
+// returns whether one of the ids is a stored image size, not a rendition
+func hasStoredSize(ids []string) bool {
+	for _, id := range ids {
+		if !strings.HasPrefix(id, image.PFX_DERIVED) {
+			return true
+		}
+	}
+	return false
+}
+
+func (pr *pics_reconciler) isYoung(ids []string) bool {
+	for _, id := range ids {
+		bm := pr.BlobStorage.ReadMeta(id)
+		if bm != nil && time.Now().Sub(bm.Timestamp) < cRcnclBlobGrace {
+			return true
+		}
+	}
+	return false
+}
+
+// makes the pause between packs, returns false if the console is shutting down
+func (pr *pics_reconciler) pause() bool {
+	select {
+	case <-pr.MainCtx.Done():
+		pr.logger.Info("Interrupted.")
+		return false
+	case <-time.After(time.Millisecond * time.Duration(pr.CConfig.SweepImagesPackSizePauseMs)):
+		return true
+	}
+}
+
+func (rr *ReconcileReport) String() string {
+	return fmt.Sprint("dryRun=", rr.DryRun, ", blobsScanned=", rr.BlobsScanned, ", picsScanned=", rr.PicsScanned,
+		", orphanImages=", rr.OrphanImages, ", missingImages=", rr.MissingImages, ", imagesDeleted=", rr.ImagesDeleted,
+		", for ", rr.FinishedAt.Sub(rr.StartedAt), ", err=", rr.Err)
+}
+
+func (rr *ReconcileReport) onOrphanImage(fn string) {
+	rr.OrphanImages++
+	if len(rr.OrphanImageIds) < cRcnclMaxIds {
+		rr.OrphanImageIds = append(rr.OrphanImageIds, fn)
+	}
+}
+
+func (rr *ReconcileReport) onMissingImage(fn string) {
+	rr.MissingImages++
+	if len(rr.MissingImageIds) < cRcnclMaxIds {
+		rr.MissingImageIds = append(rr.MissingImageIds, fn)
+	}
+}
+
+func (rr *ReconcileReport) onError(err error) {
+	rr.Err = err
+}